@@ -0,0 +1,238 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+//
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/enescakir/emoji"
+	"github.com/foxcpp/go-assuan/pinentry"
+	"github.com/jorgelbg/pinentry-touchid/audit"
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/logging"
+	"github.com/jorgelbg/pinentry-touchid/metadata"
+	"github.com/keybase/go-keychain"
+)
+
+// pinentryMacCreator is recorded as the creator of the entries imported from pinentry-mac
+const pinentryMacCreator = "pinentry-mac"
+
+// keptAccountSuffix is appended to the account of an entry imported from pinentry-mac when the
+// original entry is kept, the keychain identifies an item by its service and account.
+const keptAccountSuffix = "+pinentry-touchid"
+
+// removePinentryMacItems makes importing the passphrase saved by pinentry-mac remove its entry, it
+// is kept by default so that pinentry-mac can still use it
+var removePinentryMacItems bool
+
+// keygripFromAccount returns the keygrip of the keychain item with the given account
+func keygripFromAccount(account string) string {
+	return strings.TrimSuffix(account, keptAccountSuffix)
+}
+
+// keychainItemByKeygrip returns the attributes of the item that pinentry-mac creates for keygrip
+// when "Save in Keychain" is checked: its service is GnuPG and its account is the keygrip, but it
+// doesn't have our label. An item created by pinentry-touchid is never returned.
+func keychainItemByKeygrip(keygrip string) (keychain.QueryResult, bool, error) {
+	if keygrip == "" {
+		return keychain.QueryResult{}, false, nil
+	}
+
+	query := keychain.NewItem()
+	query.SetSecClass(keychain.SecClassGenericPassword)
+	query.SetService(keychainService)
+	query.SetAccount(keygrip)
+	query.SetMatchLimit(keychain.MatchLimitAll)
+	query.SetReturnAttributes(true)
+
+	results, err := keychain.QueryItem(query)
+	if err == keychain.ErrorItemNotFound {
+		return keychain.QueryResult{}, false, nil
+	}
+	if err != nil {
+		return keychain.QueryResult{}, false, err
+	}
+
+	// our own entry may be stored under a label that isn't derived from the key anymore
+	for _, item := range results {
+		if item.Description != keychainDescription {
+			return item, true, nil
+		}
+	}
+
+	return keychain.QueryResult{}, false, nil
+}
+
+// adoptKeychainItem stores pin in an entry owned by pinentry-touchid with the given label. The
+// keychain identifies an item by its service and account: the entry gets an account of its own
+// while item is kept, otherwise item is removed first and put back if the new entry can't be
+// created.
+func adoptKeychainItem(item keychain.QueryResult, label string, pin []byte, remove bool) error {
+	if !remove {
+		return updatePasswordInKeychain(label, item.Account+keptAccountSuffix, pin)
+	}
+
+	if err := deleteKeychainItem(item); err != nil {
+		return err
+	}
+
+	if err := storePasswordInKeychain(label, item.Account, pin); err != nil {
+		if restoreErr := storePasswordInKeychain(item.Label, item.Account, pin); restoreErr != nil {
+			return fmt.Errorf("%w (the original entry couldn't be restored: %s)", err, restoreErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// adoptPIN imports the passphrase that pinentry-mac saved for the key of s into an entry owned by
+// pinentry-touchid, after the user authenticates. It returns a nil PIN when there is nothing to
+// import or the user can't authenticate right now, the passphrase is then typed and stored like the
+// imported one. Every decision is recorded in trail.
+func adoptPIN(authenticator auth.Authenticator, s pinentry.Settings, caller requestCaller, label string,
+	entries *metadata.Store, trail *audit.Log, logger *logging.Logger) ([]byte, error) {
+	item, found, err := keychainItemByKeygrip(keygripFromKeyInfo(s.KeyInfo))
+	if err != nil {
+		return nil, storeError{err}
+	}
+	if !found {
+		return nil, nil
+	}
+
+	logger.Info("Found an entry saved by pinentry-mac", "label", label, "item", item.Label)
+
+	event := auditRecord(s, caller, label, audit.ModeAdopt)
+	result, err := authenticateEvent(authenticator, s,
+		caller.reason(fmt.Sprintf("import the passphrase of %s saved by pinentry-mac", label)), label, &event)
+	switch result {
+	case auth.Success:
+	case auth.Mismatch, auth.LockedOut, auth.NotEnrolled, auth.Unavailable:
+		// the passphrase is typed and recorded by GetPIN
+		logger.Info("Touch ID authentication not possible, asking for the PIN", "label", label, "result", result)
+		return nil, nil
+	default:
+		_ = recordAudit(trail, event, audit.Denied, authError{result, err}, logger)
+		return nil, authError{result, err}
+	}
+
+	// macOS asks the user to allow reading an item created by pinentry-mac
+	data, err := keychainData(item)
+	if err != nil {
+		_ = recordAudit(trail, event, audit.Failed, err, logger)
+		return nil, storeError{err}
+	}
+
+	if err := adoptKeychainItem(item, label, data.Bytes(), removePinentryMacItems); err != nil {
+		data.Wipe()
+		_ = recordAudit(trail, event, audit.Failed, err, logger)
+		return nil, storeError{err}
+	}
+
+	if err := recordAudit(trail, event, audit.Released, nil, logger); err != nil {
+		data.Wipe()
+		return nil, err
+	}
+
+	logger.Info("Imported the passphrase saved by pinentry-mac", "label", label)
+
+	record := entryRecord(s, label)
+	record.Created = item.CreationDate
+	record.CreatedBy = pinentryMacCreator
+	recordCreated(entries, record, logger)
+
+	// the bytes are wiped by the pinentry server once they are sent to the gpg-agent
+	return data.Bytes(), nil
+}
+
+// importPinentryMac imports every entry of the GnuPG service not created by pinentry-touchid. The
+// label of an entry is taken from its metadata record if there is one, otherwise the label given by
+// pinentry-mac is kept. The entries of pinentry-mac are only removed with remove, the ones imported
+// before are skipped. The user authenticates once for all the entries, with dryRun the entries are
+// only printed.
+func importPinentryMac(w io.Writer, authenticator auth.Authenticator, entries *metadata.Store, dryRun, remove bool) error {
+	query := keychain.NewItem()
+	query.SetSecClass(keychain.SecClassGenericPassword)
+	query.SetService(keychainService)
+	query.SetMatchLimit(keychain.MatchLimitAll)
+	query.SetReturnAttributes(true)
+
+	results, err := keychain.QueryItem(query)
+	if err != nil && err != keychain.ErrorItemNotFound {
+		return err
+	}
+
+	imported := make(map[string]bool)
+	for _, item := range results {
+		if item.Description == keychainDescription {
+			imported[keygripFromAccount(item.Account)] = true
+		}
+	}
+
+	var items []keychain.QueryResult
+	labels := make(map[string]string)
+	for _, item := range results {
+		if item.Description == keychainDescription || imported[item.Account] {
+			continue
+		}
+
+		labels[item.Account] = item.Label
+		if r, ok, err := entries.Get(item.Account); err == nil && ok && r.Label != "" {
+			labels[item.Account] = r.Label
+		}
+
+		items = append(items, item)
+		fmt.Fprintf(w, "%v %s: importing as %s\n", emoji.Information, item.Label, labels[item.Account])
+	}
+
+	if len(items) == 0 {
+		fmt.Fprintf(w, "%v No entries saved by pinentry-mac found\n", emoji.CheckMarkButton)
+		return nil
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	result, err := authenticate(authenticator, pinentry.Settings{},
+		fmt.Sprintf("import %d passphrase(s) saved by pinentry-mac", len(items)), "")
+	if result != auth.Success {
+		return authError{result, err}
+	}
+
+	for _, item := range items {
+		data, err := keychainData(item)
+		if err != nil {
+			return fmt.Errorf("%s: %w", item.Label, err)
+		}
+
+		err = adoptKeychainItem(item, labels[item.Account], data.Bytes(), remove)
+		data.Wipe()
+		if err != nil {
+			return fmt.Errorf("%s: %w", item.Label, err)
+		}
+
+		if _, ok, _ := entries.Get(item.Account); !ok {
+			_, err = entries.Created(metadata.Record{
+				Keygrip:   item.Account,
+				Label:     labels[item.Account],
+				Created:   item.CreationDate,
+				CreatedBy: pinentryMacCreator,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "%v Imported %d entries\n", emoji.CheckMarkButton, len(items))
+
+	return nil
+}
@@ -0,0 +1,207 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/foxcpp/go-assuan/common"
+	"github.com/foxcpp/go-assuan/pinentry"
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/logging"
+	"github.com/jorgelbg/pinentry-touchid/metadata"
+)
+
+// pinentryMacLabel is the label of an entry saved by pinentry-mac
+const pinentryMacLabel = "GnuPG: 8043823CBC5C5A0C66866520F333076D"
+
+func TestGetPINAdoptsPinentryMacEntry(t *testing.T) {
+	keychainLabel := `Firstname Lastname <test@email.com> (61AF059BD632F971)`
+	keygrip := keygripFromKeyInfo(keyInfo)
+
+	tests := []struct {
+		result  auth.Result
+		typed   string
+		want    string
+		code    common.ErrorCode
+		adopted bool
+		remove  bool
+	}{
+		{result: auth.Success, want: testPassword, adopted: true},
+		{result: auth.Success, want: testPassword, adopted: true, remove: true},
+		{result: auth.Declined, code: common.ErrCanceled},
+		// Touch ID can't be used, the typed passphrase is stored like the imported one
+		{result: auth.Unavailable, typed: "typed", want: "typed", adopted: true},
+		{result: auth.Unavailable, typed: "typed", want: "typed", adopted: true, remove: true},
+	}
+
+	defer func() { removePinentryMacItems = false }()
+
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				_ = cleanKeychain(keychainLabel)
+				_ = cleanKeychain(pinentryMacLabel)
+			}()
+
+			addKeychainItem(t, keychainService, keygrip, pinentryMacLabel, "", testPassword)
+			removePinentryMacItems = tt.remove
+
+			logger := logging.New(ioutil.Discard, logging.LevelDebug, logging.FormatText)
+			entries := metadata.NewStore(filepath.Join(t.TempDir(), metadata.DefaultFilename), metadata.Reentry{})
+			params := pinentry.Settings{
+				Desc:    keyDesc,
+				KeyInfo: keyInfo,
+			}
+
+			prompted := false
+			prompt := func(s pinentry.Settings) ([]byte, error) {
+				prompted = true
+				return []byte(tt.typed), nil
+			}
+
+			pass, pinErr := GetPIN(auth.NewScripted(tt.result), prompt, entries, nil, logger)(params)
+			if tt.code != 0 {
+				if pinErr == nil || pinErr.Code != tt.code {
+					t.Fatalf("%s: expected error code %d, got: %v", tt.result, tt.code, pinErr)
+				}
+			} else if pinErr != nil || string(pass) != tt.want {
+				t.Fatalf("%s: got %q (%v) want: %q", tt.result, pass, pinErr, tt.want)
+			}
+
+			if prompted != (tt.typed != "") {
+				t.Fatalf("%s: the passphrase should only be typed if Touch ID can't be used", tt.result)
+			}
+
+			// the passphrase of pinentry-mac is stored in an entry owned by pinentry-touchid
+			matches, _ := keychainMatches(keychainLabel, keygrip)
+			if adopted := len(matches) == 1 && matches[0].Description == keychainDescription; adopted != tt.adopted {
+				t.Fatalf("%s: entry adopted: %t, want: %t", tt.result, adopted, tt.adopted)
+			}
+
+			if matches, _ := keychainMatches(pinentryMacLabel, keygrip); (len(matches) == 0) != (tt.adopted && tt.remove) {
+				t.Fatalf("%s: the entry of pinentry-mac should only be removed if asked to, once it is adopted", tt.result)
+			}
+
+			if stored, err := passwordFromKeychain(keychainLabel, keygrip); tt.adopted && (err != nil || string(stored.Bytes()) != tt.want) {
+				t.Fatalf("%s: the adopted entry should hold the passphrase: %v", tt.result, err)
+			}
+
+			if _, ok, _ := entries.Get(keygrip); ok != tt.adopted {
+				t.Fatalf("%s: the adopted entry should have a metadata record", tt.result)
+			}
+
+			// the entry is found by its label from now on, and it is not mistaken for one of pinentry-mac
+			if item, found, _ := keychainItemByKeygrip(keygrip); found && item.Description == keychainDescription {
+				t.Fatalf("%s: our own entry should not be returned: %+v", tt.result, item)
+			}
+		}()
+	}
+}
+
+func TestKeychainItemByKeygripSkipsOwnEntries(t *testing.T) {
+	keychainLabel := `Firstname Lastname <test@email.com> (61AF059BD632F971)`
+	defer func() { _ = cleanKeychain("outdated label") }()
+
+	if err := storePasswordInKeychain("outdated label", "KEYGRIP", []byte(testPassword)); err != nil {
+		t.Fatalf("failed precreating entry in the Keychain: %s", err)
+	}
+
+	if item, found, err := keychainItemByKeygrip("KEYGRIP"); err != nil || found {
+		t.Fatalf("our own entry should not be returned: %+v (%v)", item, err)
+	}
+
+	// the passphrase typed for a key whose entry has an outdated label replaces it
+	defer func() { _ = cleanKeychain(keychainLabel) }()
+	logger := logging.New(ioutil.Discard, logging.LevelDebug, logging.FormatText)
+	entries := metadata.NewStore(filepath.Join(t.TempDir(), metadata.DefaultFilename), metadata.Reentry{})
+	params := pinentry.Settings{Desc: keyDesc, KeyInfo: "n/KEYGRIP"}
+	prompt := func(s pinentry.Settings) ([]byte, error) { return []byte("typed"), nil }
+
+	if _, pinErr := GetPIN(auth.NewScripted(auth.Success), prompt, entries, nil, logger)(params); pinErr != nil {
+		t.Fatalf("call to GetPIN should succeed: %s", pinErr)
+	}
+
+	if pass, err := passwordFromKeychain(keychainLabel, "KEYGRIP"); err != nil || string(pass.Bytes()) != "typed" {
+		t.Fatalf("the typed passphrase should be stored with the new label: %v", err)
+	}
+
+	if r, _, _ := entries.Get("KEYGRIP"); r.CreatedBy == pinentryMacCreator {
+		t.Fatalf("our own entry should not be recorded as created by pinentry-mac: %+v", r)
+	}
+}
+
+func TestImportPinentryMac(t *testing.T) {
+	keychainLabel := `Firstname Lastname <test@email.com> (61AF059BD632F971)`
+	defer func() {
+		_ = cleanKeychain(keychainLabel)
+		_ = cleanKeychain(pinentryMacLabel)
+		_ = cleanKeychain("other")
+	}()
+
+	entries := metadata.NewStore(filepath.Join(t.TempDir(), metadata.DefaultFilename), metadata.Reentry{})
+	// the label of a known key is restored from its metadata
+	if _, err := entries.Created(metadata.Record{Keygrip: "KEYGRIP", Label: keychainLabel}); err != nil {
+		t.Fatalf("recording a new entry should succeed: %s", err)
+	}
+
+	addKeychainItem(t, keychainService, "KEYGRIP", pinentryMacLabel, "", testPassword)
+	addKeychainItem(t, keychainService, "OTHER", "other", "", "other")
+
+	authenticator := auth.NewScripted(auth.Success)
+	if err := importPinentryMac(ioutil.Discard, authenticator, entries, true, false); err != nil {
+		t.Fatalf("a dry run should succeed: %s", err)
+	}
+	if len(authenticator.Requests()) != 0 {
+		t.Fatalf("a dry run should not ask to authenticate")
+	}
+
+	if err := importPinentryMac(ioutil.Discard, authenticator, entries, false, false); err != nil {
+		t.Fatalf("importing the entries should succeed: %s", err)
+	}
+	if len(authenticator.Requests()) != 1 {
+		t.Fatalf("the user should authenticate once, got: %d", len(authenticator.Requests()))
+	}
+
+	for label, want := range map[string]string{keychainLabel: testPassword, "other": "other"} {
+		matches, err := keychainMatches(label, "")
+		if err != nil || len(matches) == 0 || matches[0].Description != keychainDescription {
+			t.Fatalf("%s should be owned by pinentry-touchid: %+v %v", label, matches, err)
+		}
+
+		if pass, err := passwordFromKeychain(label, ""); err != nil || string(pass.Bytes()) != want {
+			t.Fatalf("%s: passphrase mismatch: %v", label, err)
+		}
+	}
+
+	// the entries of pinentry-mac are kept by default
+	if matches, _ := keychainMatches(pinentryMacLabel, ""); len(matches) != 1 {
+		t.Fatalf("the entry of pinentry-mac should be kept, found %d", len(matches))
+	}
+
+	if r, _, _ := entries.Get("OTHER"); r.CreatedBy != pinentryMacCreator {
+		t.Fatalf("the imported entry should have a metadata record: %+v", r)
+	}
+
+	// nothing is left to import
+	authenticator = auth.NewScripted(auth.Success)
+	if err := importPinentryMac(ioutil.Discard, authenticator, entries, false, false); err != nil || len(authenticator.Requests()) != 0 {
+		t.Fatalf("importing twice should be a no-op: %v", err)
+	}
+
+	defer func() { _ = cleanKeychain("third") }()
+	addKeychainItem(t, keychainService, "THIRD", "third", "", "third")
+
+	if err := importPinentryMac(ioutil.Discard, authenticator, entries, false, true); err != nil {
+		t.Fatalf("importing the entries should succeed: %s", err)
+	}
+
+	matches, err := keychainMatches("third", "")
+	if err != nil || len(matches) != 1 || matches[0].Description != keychainDescription || matches[0].Account != "THIRD" {
+		t.Fatalf("the entry of pinentry-mac should be replaced: %+v %v", matches, err)
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+//
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/enescakir/emoji"
+	"github.com/foxcpp/go-assuan/pinentry"
+	"github.com/jorgelbg/pinentry-touchid/audit"
+	"github.com/jorgelbg/pinentry-touchid/config"
+	"github.com/jorgelbg/pinentry-touchid/logging"
+)
+
+// auditFromConfig returns the audit log, it lives next to the configuration file
+func auditFromConfig(config.Config) *audit.Log {
+	return audit.New(filepath.Join(filepath.Dir(config.DefaultPath()), audit.DefaultFilename))
+}
+
+// auditRecord describes a request for the passphrase of label made with s by caller
+func auditRecord(s pinentry.Settings, caller requestCaller, label string, mode audit.Mode) audit.Record {
+	return audit.Record{
+		Label:   label,
+		Keygrip: keygripFromKeyInfo(s.KeyInfo),
+		Mode:    mode,
+		Owner:   s.Opts.Owner,
+		TTY:     s.Opts.TTYName,
+		Process: caller.String(),
+	}
+}
+
+// recordAudit appends r to trail with the given outcome. A passphrase is only released once its
+// release is recorded, for the other outcomes failing to record them is only logged.
+func recordAudit(trail *audit.Log, r audit.Record, outcome audit.Outcome, err error, logger *logging.Logger) error {
+	if trail == nil {
+		return nil
+	}
+
+	r.Outcome = outcome
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	if _, auditErr := trail.Append(r); auditErr != nil {
+		logger.Error("Error recording the audit log", "label", r.Label, "outcome", outcome, "err", auditErr)
+		if outcome == audit.Released {
+			return fmt.Errorf("the release couldn't be recorded in the audit log: %w", auditErr)
+		}
+	}
+
+	return nil
+}
+
+// errAuditUsage describes the arguments of the audit command
+var errAuditUsage = fmt.Errorf("usage: audit verify | audit show [--key <label or keygrip>] [--mode <mode>] " +
+	"[--outcome <outcome>] [--since <duration or date>] [--last <n>]")
+
+// auditCommand runs the audit subcommands: verify checks the chain of records and show prints the
+// records that match the given filters
+func auditCommand(w io.Writer, trail *audit.Log, args []string) error {
+	if len(args) == 0 {
+		return errAuditUsage
+	}
+
+	switch args[0] {
+	case "verify":
+		if len(args) > 1 {
+			return errAuditUsage
+		}
+		return verifyAudit(w, trail)
+	case "show":
+		filter, last, err := parseAuditFilter(args[1:])
+		if err != nil {
+			return err
+		}
+		return showAudit(w, trail, filter, last)
+	}
+
+	return errAuditUsage
+}
+
+// verifyAudit checks that no record of trail was modified, removed or reordered
+func verifyAudit(w io.Writer, trail *audit.Log) error {
+	n, err := trail.Verify()
+	if err != nil {
+		return fmt.Errorf("%s: %w", trail.Path(), err)
+	}
+
+	fmt.Fprintf(w, "%v %d records verified, the audit log is intact\n", emoji.CheckMarkButton, n)
+	return nil
+}
+
+// parseAuditFilter parses the arguments of audit show
+func parseAuditFilter(args []string) (audit.Filter, int, error) {
+	var (
+		filter               audit.Filter
+		mode, outcome, since string
+		last                 int
+	)
+
+	fs := flag.NewFlagSet("audit show", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&filter.Key, "key", "", "")
+	fs.StringVar(&mode, "mode", "", "")
+	fs.StringVar(&outcome, "outcome", "", "")
+	fs.StringVar(&since, "since", "", "")
+	fs.IntVar(&last, "last", 0, "")
+
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		return filter, 0, errAuditUsage
+	}
+
+	filter.Mode, filter.Outcome = audit.Mode(mode), audit.Outcome(outcome)
+	if since != "" {
+		t, err := parseSince(since, time.Now())
+		if err != nil {
+			return filter, 0, fmt.Errorf("invalid value for --since %q, expected a duration or a date", since)
+		}
+		filter.Since = t
+	}
+
+	return filter, last, nil
+}
+
+// parseSince parses a duration before now (e.g. 24h or 7d) or a date (2006-01-02)
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if d, err := config.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+// showAudit prints the records of trail selected by filter, only the last ones if last is positive
+func showAudit(w io.Writer, trail *audit.Log, filter audit.Filter, last int) error {
+	records, err := trail.Records()
+	if err != nil {
+		return err
+	}
+
+	var selected []audit.Record
+	for _, r := range records {
+		if filter.Match(r) {
+			selected = append(selected, r)
+		}
+	}
+	if last > 0 && len(selected) > last {
+		selected = selected[len(selected)-last:]
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SEQ\tTIME\tLABEL\tMODE\tMETHOD\tOUTCOME\tCALLER\tERROR")
+	for _, r := range selected {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Seq, formatTime(r.Time), r.Label, r.Mode,
+			orDash(r.Method), r.Outcome, orDash(auditCaller(r)), orDash(r.Error))
+	}
+
+	return tw.Flush()
+}
+
+// auditCaller describes the process that made the request of r
+func auditCaller(r audit.Record) string {
+	switch {
+	case r.Process != "":
+		return r.Process
+	case r.Owner != "" && r.TTY != "":
+		return r.Owner + " on " + r.TTY
+	case r.TTY != "":
+		return r.TTY
+	}
+
+	return r.Owner
+}
+
+// orDash returns s, or a dash if it is empty so the columns stay aligned
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
@@ -0,0 +1,351 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package audit keeps an append-only record of every time a cached passphrase is requested: which
+// key, who asked, how the user authenticated and whether the passphrase was released. Each record
+// includes the hash of the previous one, so a record that is modified, removed or reordered breaks
+// the chain. The sequence number and hash of the last record are also kept next to the log, which
+// reveals a truncated log.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jorgelbg/pinentry-touchid/internal/jsonfile"
+)
+
+// DefaultFilename is the name of the audit log
+const DefaultFilename = "pinentry-touchid-audit.log"
+
+// Mode is how the passphrase was obtained
+type Mode string
+
+const (
+	// ModeCache releases the passphrase from the store after the user authenticates
+	ModeCache Mode = "cache"
+	// ModePrompt means the passphrase was typed because the user couldn't authenticate
+	ModePrompt Mode = "prompt"
+	// ModeNew means the passphrase was typed for the first time and cached
+	ModeNew Mode = "new"
+	// ModeReentry means the cached passphrase had to be typed again
+	ModeReentry Mode = "reentry"
+	// ModeAdopt releases a passphrase saved by pinentry-mac
+	ModeAdopt Mode = "adopt"
+	// ModeExport releases the passphrase into an encrypted backup
+	ModeExport Mode = "export"
+	// ModeDedupe reads the passphrases of duplicated keychain entries to merge them
+	ModeDedupe Mode = "dedupe"
+)
+
+// Outcome is the decision taken for a request
+type Outcome string
+
+const (
+	// Released means the cached passphrase left the store
+	Released Outcome = "released"
+	// Typed means the passphrase was typed by the user, nothing left the store
+	Typed Outcome = "typed"
+	// Denied means the user didn't authenticate
+	Denied Outcome = "denied"
+	// Failed means the request failed because of an error
+	Failed Outcome = "failed"
+)
+
+// Record is an entry of the audit log
+type Record struct {
+	// Seq numbers the records from 1
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+	// Label and Keygrip identify the key
+	Label   string `json:"label"`
+	Keygrip string `json:"keygrip,omitempty"`
+	Mode    Mode   `json:"mode"`
+	// Owner and TTY describe the calling process as reported by the gpg-agent
+	Owner string `json:"owner,omitempty"`
+	TTY   string `json:"tty,omitempty"`
+	// Process describes the calling process and its ancestors, e.g. git commit ← bash ← tmux
+	Process string `json:"process,omitempty"`
+	// Method is the authenticator that answered, empty if the user wasn't asked to authenticate
+	Method string `json:"method,omitempty"`
+	// Result of the authentication (e.g. success, declined or timeout), empty if it wasn't
+	// attempted
+	Result string `json:"result,omitempty"`
+	// Latency is how long the authentication took
+	Latency time.Duration `json:"latency,omitempty"`
+	Outcome Outcome       `json:"outcome"`
+	Error   string        `json:"error,omitempty"`
+	// Prev is the hash of the previous record, empty for the first one
+	Prev string `json:"prev"`
+	// Hash of the record, including Prev
+	Hash string `json:"hash"`
+}
+
+// hash returns the SHA-256 of the record without its own hash
+func (r Record) hash() string {
+	r.Hash = ""
+	data, _ := json.Marshal(r)
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// head is the position of the last record, kept next to the log
+type head struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// Log is an audit log stored in a file, one JSON record per line. Appending is safe across
+// processes.
+type Log struct {
+	path string
+	now  func() time.Time
+	mu   sync.Mutex
+}
+
+// New returns the audit log stored at path, the file is created on the first record
+func New(path string) *Log {
+	return &Log{path: path, now: time.Now}
+}
+
+// Path returns the location of the log
+func (l *Log) Path() string {
+	return l.path
+}
+
+// headPath is where the head of the log is kept
+func (l *Log) headPath() string {
+	return l.path + ".head"
+}
+
+// Append chains r to the last record and writes it. Seq, Time, Prev and Hash are set by Append,
+// the complete record is returned.
+func (l *Log) Append(r Record) (Record, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return r, err
+	}
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		return r, err
+	}
+	defer f.Close()
+
+	// other pinentry processes may be appending to the same log
+	if err := lockFile(f); err != nil {
+		return r, err
+	}
+	defer unlockFile(f)
+
+	line, err := lastLine(f)
+	if err != nil {
+		return r, err
+	}
+
+	r.Seq, r.Prev = 1, ""
+	if len(line) > 0 {
+		var last Record
+		if err := json.Unmarshal(line, &last); err != nil {
+			return r, fmt.Errorf("reading the last record of %s: %w", l.path, err)
+		}
+		r.Seq, r.Prev = last.Seq+1, last.Hash
+	}
+
+	r.Time = l.now().UTC()
+	r.Hash = r.hash()
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return r, err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return r, err
+	}
+
+	return r, jsonfile.Save(l.headPath(), head{Seq: r.Seq, Hash: r.Hash})
+}
+
+// lastLine returns the last line of f, nil if f is empty
+func lastLine(f *os.File) ([]byte, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	size := info.Size()
+	if size == 0 {
+		return nil, nil
+	}
+
+	for chunk := int64(4096); ; chunk *= 2 {
+		if chunk > size {
+			chunk = size
+		}
+
+		buf := make([]byte, chunk)
+		if _, err := f.ReadAt(buf, size-chunk); err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		buf = bytes.TrimRight(buf, "\n")
+		if i := bytes.LastIndexByte(buf, '\n'); i >= 0 {
+			return buf[i+1:], nil
+		}
+		if chunk == size {
+			return buf, nil
+		}
+	}
+}
+
+// Records returns every record of the log in order, without verifying them
+func (l *Log) Records() ([]Record, error) {
+	var records []Record
+	err := l.scan(func(n int, line []byte) error {
+		var r Record
+		if err := json.Unmarshal(line, &r); err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+
+		records = append(records, r)
+		return nil
+	})
+
+	return records, err
+}
+
+// scan calls fn with every line of the log, a missing log has no lines
+func (l *Log) scan(fn func(n int, line []byte) error) error {
+	f, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		if err := fn(n, scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// TamperError describes the first inconsistency found by Verify
+type TamperError struct {
+	// Seq of the record where the chain breaks
+	Seq    uint64
+	Reason string
+}
+
+func (e *TamperError) Error() string {
+	return fmt.Sprintf("record %d: %s", e.Seq, e.Reason)
+}
+
+// Verify walks the whole chain and returns the number of records verified. A *TamperError is
+// returned if a record was modified, removed, reordered or the log was truncated.
+func (l *Log) Verify() (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var h *head
+	if err := jsonfile.Load(l.headPath(), &h); err != nil {
+		return 0, fmt.Errorf("reading the head of the log: %w", err)
+	}
+
+	var (
+		last     Record
+		verified int
+		atHead   bool
+	)
+	err := l.scan(func(n int, line []byte) error {
+		seq := last.Seq + 1
+
+		var r Record
+		if err := json.Unmarshal(line, &r); err != nil {
+			return &TamperError{seq, fmt.Sprintf("line %d can't be read: %s", n, err)}
+		}
+
+		switch {
+		case r.Seq != seq:
+			return &TamperError{seq, fmt.Sprintf("found record %d instead, records were removed or reordered", r.Seq)}
+		case r.Prev != last.Hash:
+			return &TamperError{seq, "doesn't follow the previous record"}
+		case r.Hash != r.hash():
+			return &TamperError{seq, "was modified"}
+		}
+
+		if h != nil && r.Seq == h.Seq {
+			if r.Hash != h.Hash {
+				return &TamperError{seq, "doesn't match the head of the log"}
+			}
+			atHead = true
+		}
+
+		last = r
+		verified++
+		return nil
+	})
+	if err != nil {
+		return verified, err
+	}
+
+	switch {
+	case h == nil && verified > 0:
+		return verified, &TamperError{last.Seq, "the head of the log is missing"}
+	case h != nil && !atHead:
+		// the head is written after the record, it may lag behind but never be ahead
+		return verified, &TamperError{last.Seq + 1,
+			fmt.Sprintf("is missing, the log ends at record %d but %d were written", last.Seq, h.Seq)}
+	}
+
+	return verified, nil
+}
+
+// Filter selects records, the zero value selects every record
+type Filter struct {
+	// Key matches the label or the keygrip
+	Key     string
+	Mode    Mode
+	Outcome Outcome
+	// Since and Until bound the time of the records
+	Since time.Time
+	Until time.Time
+}
+
+// Match reports whether r is selected by f
+func (f Filter) Match(r Record) bool {
+	switch {
+	case f.Key != "" && f.Key != r.Label && f.Key != r.Keygrip:
+		return false
+	case f.Mode != "" && f.Mode != r.Mode:
+		return false
+	case f.Outcome != "" && f.Outcome != r.Outcome:
+		return false
+	case !f.Since.IsZero() && r.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !r.Time.Before(f.Until):
+		return false
+	}
+
+	return true
+}
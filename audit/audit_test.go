@@ -0,0 +1,289 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestLog returns a log in a temporary directory with n records
+func newTestLog(t *testing.T, n int) *Log {
+	t.Helper()
+
+	l := New(filepath.Join(t.TempDir(), DefaultFilename))
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+
+	for i := 0; i < n; i++ {
+		r := Record{Label: "Name <email> (KEYID)", Keygrip: "ABCD", Mode: ModeCache, Method: "touchid", Outcome: Released}
+		if i%2 == 1 {
+			r.Outcome, r.Error = Denied, "declined"
+		}
+		if _, err := l.Append(r); err != nil {
+			t.Fatalf("appending a record should succeed: %s", err)
+		}
+	}
+
+	return l
+}
+
+// editLines replaces the lines of the log with the result of fn
+func editLines(t *testing.T, l *Log, fn func(lines [][]byte) [][]byte) {
+	t.Helper()
+
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := fn(bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")))
+	data = append(bytes.Join(lines, []byte("\n")), '\n')
+	if err := os.WriteFile(l.path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	l := newTestLog(t, 3)
+
+	records, err := l.Records()
+	if err != nil {
+		t.Fatalf("reading the records should succeed: %s", err)
+	}
+
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got: %d", len(records))
+	}
+	for i, r := range records {
+		if r.Seq != uint64(i+1) {
+			t.Fatalf("record %d has seq %d", i+1, r.Seq)
+		}
+		if i > 0 && r.Prev != records[i-1].Hash {
+			t.Fatalf("record %d should include the hash of the previous record", r.Seq)
+		}
+	}
+	if records[0].Prev != "" {
+		t.Fatalf("the first record shouldn't have a previous hash, got: %s", records[0].Prev)
+	}
+
+	info, err := os.Stat(l.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("the log should only be accessible by the user, got: %s", info.Mode())
+	}
+
+	// a new Log continues the chain of the file
+	r, err := New(l.path).Append(Record{Label: "other", Mode: ModeExport, Outcome: Released})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Seq != 4 || r.Prev != records[2].Hash {
+		t.Fatalf("the record should follow the last one, got: %+v", r)
+	}
+
+	if n, err := l.Verify(); err != nil || n != 4 {
+		t.Fatalf("an untouched log should verify, got: %d %v", n, err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(t *testing.T, l *Log)
+		seq  uint64
+	}{
+		{"modified", func(t *testing.T, l *Log) {
+			editLines(t, l, func(lines [][]byte) [][]byte {
+				lines[1] = bytes.Replace(lines[1], []byte(`"denied"`), []byte(`"released"`), 1)
+				return lines
+			})
+		}, 2},
+		{"removed", func(t *testing.T, l *Log) {
+			editLines(t, l, func(lines [][]byte) [][]byte {
+				return append(lines[:1], lines[2:]...)
+			})
+		}, 2},
+		{"reordered", func(t *testing.T, l *Log) {
+			editLines(t, l, func(lines [][]byte) [][]byte {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			})
+		}, 2},
+		{"first removed", func(t *testing.T, l *Log) {
+			editLines(t, l, func(lines [][]byte) [][]byte {
+				return lines[1:]
+			})
+		}, 1},
+		{"truncated", func(t *testing.T, l *Log) {
+			editLines(t, l, func(lines [][]byte) [][]byte {
+				return lines[:2]
+			})
+		}, 3},
+		{"emptied", func(t *testing.T, l *Log) {
+			if err := os.Remove(l.path); err != nil {
+				t.Fatal(err)
+			}
+		}, 1},
+		{"head removed", func(t *testing.T, l *Log) {
+			if err := os.Remove(l.headPath()); err != nil {
+				t.Fatal(err)
+			}
+		}, 4},
+		{"rehashed without the head", func(t *testing.T, l *Log) {
+			// the last record is rewritten with a valid hash, only the head reveals it
+			editLines(t, l, func(lines [][]byte) [][]byte {
+				records, _ := l.Records()
+				r := records[3]
+				r.Outcome, r.Error = Released, ""
+				r.Hash = r.hash()
+				data, _ := json.Marshal(r)
+				lines[3] = data
+				return lines
+			})
+		}, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestLog(t, 4)
+			tt.edit(t, l)
+
+			_, err := l.Verify()
+			var tamper *TamperError
+			if !errors.As(err, &tamper) {
+				t.Fatalf("tampering should be detected, got: %v", err)
+			}
+			if tamper.Seq != tt.seq {
+				t.Fatalf("the chain should break at record %d, got: %s", tt.seq, tamper)
+			}
+		})
+	}
+}
+
+func TestVerifyStaleHead(t *testing.T) {
+	l := newTestLog(t, 2)
+
+	// the process exited between writing a record and its head
+	data, err := os.ReadFile(l.headPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Append(Record{Label: "other", Mode: ModeCache, Outcome: Released}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(l.headPath(), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := l.Verify(); err != nil || n != 3 {
+		t.Fatalf("a head behind the log should verify, got: %d %v", n, err)
+	}
+}
+
+func TestVerifyEmpty(t *testing.T) {
+	l := New(filepath.Join(t.TempDir(), DefaultFilename))
+	if n, err := l.Verify(); err != nil || n != 0 {
+		t.Fatalf("a missing log should verify, got: %d %v", n, err)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	at := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	r := Record{Time: at, Label: "Name <email> (KEYID)", Keygrip: "ABCD", Mode: ModeCache, Outcome: Released}
+
+	tests := []struct {
+		filter Filter
+		want   bool
+	}{
+		{Filter{}, true},
+		{Filter{Key: "ABCD"}, true},
+		{Filter{Key: "Name <email> (KEYID)", Outcome: Released}, true},
+		{Filter{Key: "other"}, false},
+		{Filter{Mode: ModeExport}, false},
+		{Filter{Outcome: Denied}, false},
+		{Filter{Since: at}, true},
+		{Filter{Since: at.Add(time.Second)}, false},
+		{Filter{Until: at}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.filter.Match(r); got != tt.want {
+			t.Fatalf("%+v: match mismatch got: %t want: %t", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestLastLine(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "lines"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if line, err := lastLine(f); err != nil || line != nil {
+		t.Fatalf("an empty file has no last line, got: %q %v", line, err)
+	}
+
+	long := strings.Repeat("x", 10000)
+	if _, err := f.WriteString("first\n" + long + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := lastLine(f); err != nil || string(line) != long {
+		t.Fatalf("the last line should span several chunks, got %d bytes: %v", len(line), err)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	const work, personal = "Work <work@example.com> (A)", "Me <me@example.com> (B)"
+
+	records := []Record{
+		{Label: work, Mode: ModeCache, Method: "touchid", Result: "success", Latency: 2 * time.Second, Outcome: Released},
+		{Label: work, Mode: ModeCache, Method: "touchid", Result: "declined", Latency: 4 * time.Second, Outcome: Denied, Error: "authentication declined"},
+		{Label: work, Mode: ModePrompt, Method: "touchid", Result: "unavailable", Latency: time.Millisecond, Outcome: Typed},
+		{Label: personal, Mode: ModeCache, Method: "touchid", Result: "timeout", Latency: 30 * time.Second, Outcome: Denied, Error: "authentication timeout"},
+		{Label: personal, Mode: ModeCache, Result: "locked out", Outcome: Typed},
+		{Label: personal, Mode: ModeReentry, Outcome: Failed, Error: "canceled"},
+		{Label: "*", Mode: ModeExport, Method: "touchid", Result: "success", Latency: time.Second, Outcome: Released},
+	}
+
+	total, keys := Summarize(records, 2)
+	if total.Requests != 6 || total.Outcomes[Released] != 1 || total.Outcomes[Typed] != 2 ||
+		total.Outcomes[Denied] != 2 || total.Outcomes[Failed] != 1 {
+		t.Fatalf("unexpected totals: %+v", total)
+	}
+	if total.Results["success"] != 1 || total.Results["declined"] != 1 || total.Results["timeout"] != 1 {
+		t.Fatalf("unexpected results: %+v", total.Results)
+	}
+	// the lockout answered without asking the user
+	if got := total.MedianLatency(); got != 3*time.Second {
+		t.Fatalf("median latency mismatch got: %s want: 3s", got)
+	}
+	if len(total.Errors) != 2 || total.Errors[0].Error != "authentication timeout" || total.Errors[1].Error != "canceled" {
+		t.Fatalf("only the last errors should be kept: %+v", total.Errors)
+	}
+
+	if len(keys) != 2 || keys[0].Label != personal || keys[1].Label != work {
+		t.Fatalf("the keys should be sorted by label: %+v", keys)
+	}
+	if keys[1].Requests != 3 || keys[1].MedianLatency() != 2*time.Second || len(keys[1].Errors) != 1 {
+		t.Fatalf("unexpected statistics of %s: %+v", work, keys[1])
+	}
+
+	if total, keys := Summarize(nil, 5); total.Requests != 0 || total.MedianLatency() != 0 || len(keys) != 0 {
+		t.Fatalf("no records should be summarised as empty: %+v %+v", total, keys)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package audit
+
+import (
+	"sort"
+	"time"
+)
+
+// Stats summarises the requests for the passphrase of a key, or of every key
+type Stats struct {
+	// Label of the key, empty for the summary of every key
+	Label string
+	// Requests is the number of passphrases requested by the gpg-agent
+	Requests int
+	// Outcomes counts the requests by outcome: Released passphrases were served from the cache,
+	// Typed ones were typed instead (fallback prompt, re-entry or new passphrase)
+	Outcomes map[Outcome]int
+	// Results counts the authentications by result, e.g. success, declined or timeout
+	Results map[string]int
+	// Latencies of the authentications answered by an authenticator, in the order of the records
+	Latencies []time.Duration
+	// Errors are the last records that were denied or failed, the most recent last
+	Errors []Record
+}
+
+// MedianLatency returns the median of the latencies, zero if there are none
+func (s Stats) MedianLatency() time.Duration {
+	if len(s.Latencies) == 0 {
+		return 0
+	}
+
+	sorted := append([]time.Duration(nil), s.Latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// add counts r, keeping up to maxErrors errors
+func (s *Stats) add(r Record, maxErrors int) {
+	if s.Outcomes == nil {
+		s.Outcomes = map[Outcome]int{}
+		s.Results = map[string]int{}
+	}
+
+	s.Requests++
+	s.Outcomes[r.Outcome]++
+
+	if r.Result != "" {
+		s.Results[r.Result]++
+	}
+	// without a method the authentication was refused before the user was asked
+	if r.Method != "" && r.Latency > 0 {
+		s.Latencies = append(s.Latencies, r.Latency)
+	}
+
+	if (r.Outcome == Denied || r.Outcome == Failed) && maxErrors > 0 {
+		s.Errors = append(s.Errors, r)
+		if len(s.Errors) > maxErrors {
+			s.Errors = s.Errors[1:]
+		}
+	}
+}
+
+// Summarize returns the statistics of every key and of each key, sorted by label. Exported and
+// merged passphrases weren't requested by the gpg-agent and are left out. Up to maxErrors errors are kept
+// in each summary.
+func Summarize(records []Record, maxErrors int) (total Stats, keys []Stats) {
+	byLabel := map[string]*Stats{}
+	for _, r := range records {
+		if r.Mode == ModeExport || r.Mode == ModeDedupe {
+			continue
+		}
+
+		total.add(r, maxErrors)
+
+		s, ok := byLabel[r.Label]
+		if !ok {
+			s = &Stats{Label: r.Label}
+			byLabel[r.Label] = s
+		}
+		s.add(r, maxErrors)
+	}
+
+	for _, s := range byLabel {
+		keys = append(keys, *s)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Label < keys[j].Label })
+
+	return total, keys
+}
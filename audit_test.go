@@ -0,0 +1,123 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/foxcpp/go-assuan/pinentry"
+	"github.com/jorgelbg/pinentry-touchid/audit"
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/logging"
+)
+
+func TestGetPINAudit(t *testing.T) {
+	keychainLabel := `Firstname Lastname <test@email.com> (61AF059BD632F971)`
+	defer func() { _ = cleanKeychain(keychainLabel) }()
+
+	if err := storePasswordInKeychain(keychainLabel, keyInfo, []byte(testPassword)); err != nil {
+		t.Fatalf("failed precreating entry in the Keychain: %s", err)
+	}
+
+	logger := logging.New(ioutil.Discard, logging.LevelDebug, logging.FormatText)
+	trail := audit.New(filepath.Join(t.TempDir(), audit.DefaultFilename))
+	authenticator := auth.Chain{Links: []auth.Link{
+		{Name: "scripted", Authenticator: auth.NewScripted(auth.Declined, auth.Success, auth.Unavailable)},
+	}}
+
+	params := pinentry.Settings{Desc: keyDesc, KeyInfo: keyInfo}
+	params.Opts.Owner = "1234/501 host"
+	params.Opts.TTYName = "/dev/ttys001"
+
+	fn := GetPIN(authenticator, dummyPrompt, nil, trail, logger)
+	for i := 0; i < 3; i++ {
+		pass, _ := fn(params)
+		for j := range pass {
+			pass[j] = 0
+		}
+	}
+
+	records, err := trail.Records()
+	if err != nil {
+		t.Fatalf("reading the audit log should succeed: %s", err)
+	}
+
+	want := []struct {
+		mode    audit.Mode
+		outcome audit.Outcome
+		result  string
+	}{
+		{audit.ModeCache, audit.Denied, "declined"},
+		{audit.ModeCache, audit.Released, "success"},
+		{audit.ModePrompt, audit.Typed, "unavailable"},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("expected %d records, got: %+v", len(want), records)
+	}
+	for i, r := range records {
+		if r.Mode != want[i].mode || r.Outcome != want[i].outcome || r.Result != want[i].result {
+			t.Fatalf("record %d mismatch got: %s %s %s want: %s %s %s", r.Seq, r.Mode, r.Outcome, r.Result,
+				want[i].mode, want[i].outcome, want[i].result)
+		}
+		if r.Label != keychainLabel || r.Keygrip != keygripFromKeyInfo(keyInfo) || r.Method != "scripted" ||
+			r.Owner != "1234/501 host" || r.TTY != "/dev/ttys001" {
+			t.Fatalf("record %d doesn't describe the request: %+v", r.Seq, r)
+		}
+	}
+
+	if _, err := trail.Verify(); err != nil {
+		t.Fatalf("the audit log should verify: %s", err)
+	}
+}
+
+func TestAuditCommand(t *testing.T) {
+	trail := audit.New(filepath.Join(t.TempDir(), audit.DefaultFilename))
+	for _, r := range []audit.Record{
+		{Label: "first", Keygrip: "AAAA", Mode: audit.ModeCache, Outcome: audit.Released},
+		{Label: "second", Keygrip: "BBBB", Mode: audit.ModeCache, Outcome: audit.Denied},
+		{Label: "first", Keygrip: "AAAA", Mode: audit.ModeExport, Outcome: audit.Released},
+	} {
+		if _, err := trail.Append(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := auditCommand(&out, trail, []string{"verify"}); err != nil || !strings.Contains(out.String(), "3 records") {
+		t.Fatalf("verify should succeed: %q (%v)", out.String(), err)
+	}
+
+	out.Reset()
+	if err := auditCommand(&out, trail, []string{"show", "--key", "AAAA", "--last", "1"}); err != nil {
+		t.Fatalf("show should succeed: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], "export") {
+		t.Fatalf("show should print the last record of the key: %q", lines)
+	}
+
+	for _, args := range [][]string{nil, {"verify", "now"}, {"show", "extra"}, {"show", "--since", "yesterday"}} {
+		if err := auditCommand(&out, trail, args); err == nil {
+			t.Fatalf("%q should fail", args)
+		}
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2021, 6, 10, 12, 0, 0, 0, time.Local)
+
+	if got, err := parseSince("7d", now); err != nil || !got.Equal(now.Add(-7*24*time.Hour)) {
+		t.Fatalf("unexpected time for 7d: %s (%v)", got, err)
+	}
+
+	if got, err := parseSince("2021-06-01", now); err != nil || !got.Equal(time.Date(2021, 6, 1, 0, 0, 0, 0, time.Local)) {
+		t.Fatalf("unexpected time for a date: %s (%v)", got, err)
+	}
+}
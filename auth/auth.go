@@ -0,0 +1,176 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package auth defines how pinentry-touchid verifies that the user is present before releasing a
+// PIN from the keychain.
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Result is the outcome of an authentication attempt
+type Result int
+
+const (
+	// Failed means that the authentication couldn't be completed because of an unexpected error.
+	// It is the zero value so a missing result never grants access.
+	Failed Result = iota
+	// Success means that the user was authenticated
+	Success
+	// Declined means that the user dismissed the authentication prompt
+	Declined
+	// Mismatch means that the user tried but couldn't be verified (e.g. unknown fingerprint)
+	Mismatch
+	// LockedOut means that the authentication method is locked after too many failed attempts
+	LockedOut
+	// NotEnrolled means that the user has no fingerprints (or equivalent) enrolled
+	NotEnrolled
+	// Unavailable means that the authentication method is not present in this device
+	Unavailable
+	// Timeout means that the user didn't authenticate in time
+	Timeout
+	// Canceled means that the request was canceled before the user authenticated
+	Canceled
+	// Forbidden means that the caller isn't allowed to access the PIN, the user wasn't asked
+	Forbidden
+)
+
+var resultNames = map[Result]string{
+	Failed:      "failed",
+	Success:     "success",
+	Declined:    "declined",
+	Mismatch:    "mismatch",
+	LockedOut:   "locked out",
+	NotEnrolled: "not enrolled",
+	Unavailable: "unavailable",
+	Timeout:     "timeout",
+	Canceled:    "canceled",
+	Forbidden:   "forbidden",
+}
+
+func (r Result) String() string {
+	if name, ok := resultNames[r]; ok {
+		return name
+	}
+
+	return "unknown"
+}
+
+// Key identifies the key whose PIN is requested
+type Key struct {
+	// Label of the keychain item, e.g. "Name <email> (KEYID)"
+	Label string
+	// KeyInfo as sent by gpg-agent with SETKEYINFO (x/cacheId)
+	KeyInfo string
+}
+
+// Caller describes who is asking for the PIN, as reported by gpg-agent
+type Caller struct {
+	// Owner as sent by gpg-agent with OPTION owner (pid/uid host), see process.ParseOwner
+	Owner string
+	// TTYName of the terminal where the request originated
+	TTYName string
+}
+
+// Request holds the details of an authentication request
+type Request struct {
+	// Reason shown to the user in the authentication prompt
+	Reason string
+	Key    Key
+	Caller Caller
+}
+
+// Authenticator verifies that the user is present and allowed to access a PIN. Implementations
+// should stop waiting for the user when ctx is done and report Timeout or Canceled accordingly.
+// The returned error gives details about a Failed result.
+type Authenticator interface {
+	Authenticate(ctx context.Context, req Request) (Result, error)
+}
+
+// ContextResult maps the error of a done context to a result
+func ContextResult(err error) Result {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return Timeout
+	}
+
+	return Canceled
+}
+
+// Func is an Authenticator for functions with the signature of touchid.Authenticate: it receives
+// the reason and reports whether the user authenticated.
+type Func func(reason string) (bool, error)
+
+// Authenticate runs the function in the background so the request can be abandoned when ctx is
+// done. A false result is reported as Declined.
+func (fn Func) Authenticate(ctx context.Context, req Request) (Result, error) {
+	type outcome struct {
+		ok  bool
+		err error
+	}
+
+	done := make(chan outcome, 1)
+	go func() {
+		ok, err := fn(req.Reason)
+		done <- outcome{ok, err}
+	}()
+
+	select {
+	case o := <-done:
+		if o.err != nil {
+			return Failed, o.err
+		}
+		if !o.ok {
+			return Declined, nil
+		}
+		return Success, nil
+	case <-ctx.Done():
+		return ContextResult(ctx.Err()), ctx.Err()
+	}
+}
+
+// Scripted is an Authenticator that returns a predefined sequence of results, meant to be used in
+// tests. Once the script runs out the last result is repeated.
+type Scripted struct {
+	mu       sync.Mutex
+	results  []Result
+	requests []Request
+}
+
+// NewScripted returns an Authenticator that answers with the given results in order
+func NewScripted(results ...Result) *Scripted {
+	return &Scripted{results: results}
+}
+
+// Authenticate returns the next result of the script, or the context result if ctx is done
+func (s *Scripted) Authenticate(ctx context.Context, req Request) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, req)
+	if err := ctx.Err(); err != nil {
+		return ContextResult(err), err
+	}
+
+	if len(s.results) == 0 {
+		return Failed, errors.New("empty script")
+	}
+
+	i := len(s.requests) - 1
+	if i >= len(s.results) {
+		i = len(s.results) - 1
+	}
+
+	return s.results[i], nil
+}
+
+// Requests returns the requests received so far
+func (s *Scripted) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Request(nil), s.requests...)
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFunc(t *testing.T) {
+	tests := []struct {
+		fn   Func
+		want Result
+	}{
+		{func(string) (bool, error) { return true, nil }, Success},
+		{func(string) (bool, error) { return false, nil }, Declined},
+		{func(string) (bool, error) { return false, errors.New("no biometrics") }, Failed},
+	}
+
+	for _, tt := range tests {
+		if got, _ := tt.fn.Authenticate(context.Background(), Request{}); got != tt.want {
+			t.Fatalf("result mismatch got: %s want: %s", got, tt.want)
+		}
+	}
+}
+
+func TestFuncContext(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	fn := Func(func(string) (bool, error) {
+		<-block
+		return true, nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if got, _ := fn.Authenticate(ctx, Request{}); got != Timeout {
+		t.Fatalf("an expired context should time out, got: %s", got)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+
+	if got, _ := fn.Authenticate(ctx, Request{}); got != Canceled {
+		t.Fatalf("a canceled context should cancel, got: %s", got)
+	}
+}
+
+func TestScripted(t *testing.T) {
+	s := NewScripted(Mismatch, Success)
+
+	for _, want := range []Result{Mismatch, Success, Success} {
+		if got, _ := s.Authenticate(context.Background(), Request{Reason: "test"}); got != want {
+			t.Fatalf("result mismatch got: %s want: %s", got, want)
+		}
+	}
+
+	if len(s.Requests()) != 3 || s.Requests()[0].Reason != "test" {
+		t.Fatalf("the requests should be recorded: %+v", s.Requests())
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"context"
+	"strings"
+)
+
+// Link is an authenticator in a Chain
+type Link struct {
+	Name          string
+	Authenticator Authenticator
+}
+
+// Chain tries a list of authenticators in order. When an authenticator can't be used right now
+// (Unavailable, NotEnrolled or LockedOut) the next one is tried, any other result ends the chain:
+// a user that declines the first prompt is not asked again by the next authenticator.
+type Chain struct {
+	Links []Link
+	// Observe, if set, is called with the outcome of every authenticator that was tried
+	Observe func(name string, result Result, err error)
+}
+
+// observerKey is the context key of the observer added with WithObserver
+type observerKey struct{}
+
+// WithObserver returns a context that makes every Chain authenticating with it call fn with the
+// outcome of each authenticator tried, in addition to its own Observe. It tells the caller which
+// authenticator answered a request.
+func WithObserver(ctx context.Context, fn func(name string, result Result, err error)) context.Context {
+	return context.WithValue(ctx, observerKey{}, fn)
+}
+
+// skip reports whether the next authenticator should be tried after result
+func skip(result Result) bool {
+	switch result {
+	case Unavailable, NotEnrolled, LockedOut:
+		return true
+	}
+
+	return false
+}
+
+// Authenticate runs the authenticators of the chain until one of them can be used. If none can,
+// the result of the last one is returned.
+func (c Chain) Authenticate(ctx context.Context, req Request) (Result, error) {
+	result, err := Unavailable, error(nil)
+	for _, link := range c.Links {
+		result, err = link.Authenticator.Authenticate(ctx, req)
+		if c.Observe != nil {
+			c.Observe(link.Name, result, err)
+		}
+		if observe, ok := ctx.Value(observerKey{}).(func(string, Result, error)); ok {
+			observe(link.Name, result, err)
+		}
+
+		if !skip(result) {
+			break
+		}
+	}
+
+	return result, err
+}
+
+// String returns the names of the authenticators in the order they are tried
+func (c Chain) String() string {
+	names := make([]string, len(c.Links))
+	for i, link := range c.Links {
+		names[i] = link.Name
+	}
+
+	return strings.Join(names, " → ")
+}
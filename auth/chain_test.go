@@ -0,0 +1,78 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"context"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []Result
+		want    Result
+		tried   int
+	}{
+		{"first succeeds", []Result{Success, Success}, Success, 1},
+		{"unavailable falls through", []Result{Unavailable, Success}, Success, 2},
+		{"not enrolled falls through", []Result{NotEnrolled, LockedOut, Success}, Success, 3},
+		{"declined stops", []Result{Declined, Success}, Declined, 1},
+		{"failure stops", []Result{Failed, Success}, Failed, 1},
+		{"none available", []Result{Unavailable, NotEnrolled}, NotEnrolled, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tried []string
+			chain := Chain{Observe: func(name string, result Result, err error) {
+				tried = append(tried, name)
+			}}
+			for i, r := range tt.results {
+				chain.Links = append(chain.Links, Link{Name: string(rune('a' + i)), Authenticator: NewScripted(r)})
+			}
+
+			if got, _ := chain.Authenticate(context.Background(), Request{}); got != tt.want {
+				t.Fatalf("result mismatch got: %s want: %s", got, tt.want)
+			}
+
+			if len(tried) != tt.tried {
+				t.Fatalf("expected %d authenticators to be tried, got: %v", tt.tried, tried)
+			}
+		})
+	}
+
+	if got, _ := (Chain{}).Authenticate(context.Background(), Request{}); got != Unavailable {
+		t.Fatalf("an empty chain should be unavailable, got: %s", got)
+	}
+}
+
+func TestChainContextObserver(t *testing.T) {
+	observed := 0
+	chain := Chain{
+		Links: []Link{
+			{Name: "fprintd", Authenticator: NewScripted(Unavailable)},
+			{Name: "polkit", Authenticator: NewScripted(Success)},
+		},
+		Observe: func(name string, result Result, err error) { observed++ },
+	}
+
+	var answered string
+	ctx := WithObserver(context.Background(), func(name string, result Result, err error) {
+		if result == Success {
+			answered = name
+		}
+	})
+
+	if got, _ := chain.Authenticate(ctx, Request{}); got != Success {
+		t.Fatalf("result mismatch got: %s want: %s", got, Success)
+	}
+	if answered != "polkit" {
+		t.Fatalf("the context observer should see polkit answer, got: %q", answered)
+	}
+	if observed != 2 {
+		t.Fatalf("the observer of the chain should still be called, got %d calls", observed)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package fprintd authenticates the user with a fingerprint reader managed by fprintd
+// (net.reactivated.Fprint on the system D-Bus).
+package fprintd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/internal/dbusutil"
+	dbus "github.com/keybase/go.dbus"
+)
+
+const (
+	// BusName is the well-known name of fprintd on the system bus
+	BusName = "net.reactivated.Fprint"
+	// ManagerPath is the object path of the fprintd device manager
+	ManagerPath = dbus.ObjectPath("/net/reactivated/Fprint/Manager")
+
+	managerInterface = BusName + ".Manager"
+	deviceInterface  = BusName + ".Device"
+	verifyStatus     = deviceInterface + ".VerifyStatus"
+
+	errNoSuchDevice     = BusName + ".Error.NoSuchDevice"
+	errNoEnrolledPrints = BusName + ".Error.NoEnrolledPrints"
+	errAlreadyInUse     = BusName + ".Error.AlreadyInUse"
+)
+
+// Authenticator verifies the user with any enrolled finger on the default fingerprint reader
+type Authenticator struct {
+	// Address of the bus where fprintd runs, the system bus if empty
+	Address string
+}
+
+// result maps the errors returned by fprintd
+func result(err error) auth.Result {
+	if dbusutil.IsUnavailable(err) {
+		return auth.Unavailable
+	}
+
+	switch dbusutil.ErrorName(err) {
+	case errNoEnrolledPrints:
+		return auth.NotEnrolled
+	case errNoSuchDevice, errAlreadyInUse:
+		return auth.Unavailable
+	}
+
+	return auth.Failed
+}
+
+// Authenticate claims the default device, starts a verification and waits for the final
+// VerifyStatus signal. The device is released before returning.
+func (a Authenticator) Authenticate(ctx context.Context, req auth.Request) (auth.Result, error) {
+	conn, err := dbusutil.Connect(a.Address)
+	if err != nil {
+		return auth.Unavailable, err
+	}
+	defer conn.Close()
+
+	var path dbus.ObjectPath
+	err = conn.Object(BusName, ManagerPath).Call(managerInterface+".GetDefaultDevice", 0).Store(&path)
+	if err != nil {
+		return result(err), err
+	}
+
+	// subscribe before starting the verification so no status is missed
+	signals := make(chan *dbus.Signal, 16)
+	conn.Signal(signals)
+	match := fmt.Sprintf("type='signal',interface='%s',member='VerifyStatus',path='%s'",
+		deviceInterface, path)
+	if err := conn.BusObject().Call("org.freedesktop.DBus.AddMatch", 0, match).Err; err != nil {
+		return auth.Failed, err
+	}
+
+	device := conn.Object(BusName, path)
+	if err := device.Call(deviceInterface+".Claim", 0, "").Err; err != nil {
+		return result(err), err
+	}
+	defer device.Call(deviceInterface+".Release", 0)
+
+	if err := device.Call(deviceInterface+".VerifyStart", 0, "any").Err; err != nil {
+		return result(err), err
+	}
+	defer device.Call(deviceInterface+".VerifyStop", 0)
+
+	for {
+		select {
+		case s, ok := <-signals:
+			if !ok {
+				return auth.Unavailable, errors.New("the connection to fprintd was closed")
+			}
+			if s.Name != verifyStatus || s.Path != path || len(s.Body) < 2 {
+				continue
+			}
+
+			status, _ := s.Body[0].(string)
+			done, _ := s.Body[1].(bool)
+			switch status {
+			case "verify-match":
+				return auth.Success, nil
+			case "verify-no-match":
+				return auth.Mismatch, nil
+			case "verify-disconnected":
+				return auth.Unavailable, errors.New("the fingerprint reader was disconnected")
+			}
+
+			// the remaining statuses (verify-retry-scan, verify-swipe-too-short, ...) ask the
+			// user to scan again unless the verification is over
+			if done {
+				return auth.Failed, fmt.Errorf("verification failed: %s", status)
+			}
+		case <-ctx.Done():
+			return auth.ContextResult(ctx.Err()), ctx.Err()
+		}
+	}
+}
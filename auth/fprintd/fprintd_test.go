@@ -0,0 +1,160 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fprintd
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/internal/dbustest"
+	"github.com/jorgelbg/pinentry-touchid/internal/dbusutil"
+	dbus "github.com/keybase/go.dbus"
+)
+
+const devicePath = dbus.ObjectPath("/net/reactivated/Fprint/Device/0")
+
+type status struct {
+	result string
+	done   bool
+}
+
+type fakeManager struct {
+	hasDevice bool
+}
+
+func (m *fakeManager) GetDefaultDevice() (dbus.ObjectPath, *dbus.Error) {
+	if !m.hasDevice {
+		return "", &dbus.Error{Name: errNoSuchDevice, Body: []interface{}{"No devices available"}}
+	}
+
+	return devicePath, nil
+}
+
+// fakeDevice emits the scripted statuses when a verification starts
+type fakeDevice struct {
+	conn     *dbusutil.Conn
+	enrolled bool
+	script   []status
+
+	mu       sync.Mutex
+	claimed  bool
+	verified bool
+}
+
+func (d *fakeDevice) Claim(username string) *dbus.Error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.claimed = true
+	return nil
+}
+
+func (d *fakeDevice) Release() *dbus.Error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.claimed = false
+	return nil
+}
+
+func (d *fakeDevice) VerifyStart(finger string) *dbus.Error {
+	if !d.enrolled {
+		return &dbus.Error{Name: errNoEnrolledPrints, Body: []interface{}{"No enrolled prints"}}
+	}
+
+	d.mu.Lock()
+	d.verified = true
+	d.mu.Unlock()
+
+	for _, s := range d.script {
+		_ = d.conn.Emit(devicePath, verifyStatus, s.result, s.done)
+	}
+
+	return nil
+}
+
+func (d *fakeDevice) VerifyStop() *dbus.Error {
+	return nil
+}
+
+func (d *fakeDevice) isClaimed() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.claimed
+}
+
+// startFprintd serves a stand-in of fprintd on a private bus and returns its address
+func startFprintd(t *testing.T, manager *fakeManager, device *fakeDevice) string {
+	address := dbustest.Start(t)
+	device.conn = dbustest.Serve(t, address, BusName, map[dbus.ObjectPath]map[string]interface{}{
+		ManagerPath: {managerInterface: manager},
+		devicePath:  {deviceInterface: device},
+	})
+
+	return address
+}
+
+func TestAuthenticate(t *testing.T) {
+	tests := []struct {
+		name   string
+		script []status
+		want   auth.Result
+	}{
+		{"match", []status{{"verify-match", true}}, auth.Success},
+		{"no match", []status{{"verify-no-match", true}}, auth.Mismatch},
+		{"retry then match", []status{{"verify-retry-scan", false}, {"verify-match", true}}, auth.Success},
+		{"disconnected", []status{{"verify-disconnected", true}}, auth.Unavailable},
+		{"unknown error", []status{{"verify-unknown-error", true}}, auth.Failed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device := &fakeDevice{enrolled: true, script: tt.script}
+			a := Authenticator{Address: startFprintd(t, &fakeManager{hasDevice: true}, device)}
+
+			got, err := a.Authenticate(context.Background(), auth.Request{})
+			if got != tt.want {
+				t.Fatalf("result mismatch got: %s (%v) want: %s", got, err, tt.want)
+			}
+
+			if device.isClaimed() {
+				t.Fatalf("the device should be released")
+			}
+		})
+	}
+}
+
+func TestAuthenticateTimeout(t *testing.T) {
+	device := &fakeDevice{enrolled: true, script: []status{{"verify-retry-scan", false}}}
+	a := Authenticator{Address: startFprintd(t, &fakeManager{hasDevice: true}, device)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if got, _ := a.Authenticate(ctx, auth.Request{}); got != auth.Timeout {
+		t.Fatalf("waiting for a finger should time out, got: %s", got)
+	}
+}
+
+func TestAuthenticateNotPossible(t *testing.T) {
+	address := startFprintd(t, &fakeManager{hasDevice: true}, &fakeDevice{})
+	if got, _ := (Authenticator{Address: address}).Authenticate(context.Background(), auth.Request{}); got != auth.NotEnrolled {
+		t.Fatalf("a reader without enrolled fingers should be reported, got: %s", got)
+	}
+
+	address = startFprintd(t, &fakeManager{}, &fakeDevice{})
+	if got, _ := (Authenticator{Address: address}).Authenticate(context.Background(), auth.Request{}); got != auth.Unavailable {
+		t.Fatalf("a missing reader should be reported, got: %s", got)
+	}
+
+	address = dbustest.Start(t)
+	if got, _ := (Authenticator{Address: address}).Authenticate(context.Background(), auth.Request{}); got != auth.Unavailable {
+		t.Fatalf("a missing fprintd should be reported, got: %s", got)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package polkit authenticates the user with the authentication agent of the desktop session
+// (password, fingerprint, smartcard, ...) by checking a polkit action on the system D-Bus.
+package polkit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/internal/dbusutil"
+	dbus "github.com/keybase/go.dbus"
+)
+
+const (
+	// BusName is the well-known name of polkit on the system bus
+	BusName = "org.freedesktop.PolicyKit1"
+	// AuthorityPath is the object path of the polkit authority
+	AuthorityPath = dbus.ObjectPath("/org/freedesktop/PolicyKit1/Authority")
+	// DefaultAction is the action defined in com.github.jorgelbg.pinentry-touchid.policy
+	DefaultAction = "com.github.jorgelbg.pinentry-touchid.access-pin"
+
+	authorityInterface = BusName + ".Authority"
+	errCancelled       = BusName + ".Error.Cancelled"
+
+	// allowUserInteraction lets polkit ask the authentication agent for credentials
+	allowUserInteraction = uint32(1)
+)
+
+// subject identifies who is asking for authorization, a (sa{sv}) struct
+type subject struct {
+	Kind    string
+	Details map[string]dbus.Variant
+}
+
+// authorizationResult is the (bba{ss}) struct returned by CheckAuthorization
+type authorizationResult struct {
+	IsAuthorized bool
+	IsChallenge  bool
+	Details      map[string]string
+}
+
+// Authenticator asks polkit to authorize Action for the current process
+type Authenticator struct {
+	// Address of the bus where polkit runs, the system bus if empty
+	Address string
+	// Action checked with polkit, DefaultAction if empty
+	Action string
+}
+
+func (a Authenticator) action() string {
+	if a.Action == "" {
+		return DefaultAction
+	}
+
+	return a.Action
+}
+
+// Authenticate calls CheckAuthorization allowing user interaction, polkit then asks the
+// authentication agent to verify the user. The check is canceled when ctx is done.
+func (a Authenticator) Authenticate(ctx context.Context, req auth.Request) (auth.Result, error) {
+	conn, err := dbusutil.Connect(a.Address)
+	if err != nil {
+		return auth.Unavailable, err
+	}
+	defer conn.Close()
+
+	// the connection identifies this process to polkit
+	caller := subject{
+		Kind:    "system-bus-name",
+		Details: map[string]dbus.Variant{"name": dbus.MakeVariant(conn.Names()[0])},
+	}
+	// polkitd refuses details from callers that aren't root, the agent shows the message of the
+	// action instead of the reason
+	details := map[string]string{}
+	cancelID := fmt.Sprintf("pinentry-touchid-%d-%d", os.Getpid(), time.Now().UnixNano())
+
+	authority := conn.Object(BusName, AuthorityPath)
+	call := authority.Go(authorityInterface+".CheckAuthorization", 0, make(chan *dbus.Call, 1),
+		caller, a.action(), details, allowUserInteraction, cancelID)
+
+	select {
+	case call = <-call.Done:
+	case <-ctx.Done():
+		// dismiss the dialog of the authentication agent
+		authority.Call(authorityInterface+".CancelCheckAuthorization", 0, cancelID)
+		return auth.ContextResult(ctx.Err()), ctx.Err()
+	}
+
+	var res authorizationResult
+	if err := call.Store(&res); err != nil {
+		switch {
+		case dbusutil.IsUnavailable(err):
+			return auth.Unavailable, err
+		case dbusutil.ErrorName(err) == errCancelled:
+			return auth.Canceled, err
+		}
+		return auth.Failed, err
+	}
+
+	switch {
+	case res.IsAuthorized:
+		return auth.Success, nil
+	case res.Details["polkit.dismissed"] != "":
+		return auth.Declined, nil
+	case res.IsChallenge:
+		// authorization requires interaction but no authentication agent could be reached
+		return auth.Unavailable, fmt.Errorf("no polkit authentication agent available for %s", a.action())
+	}
+
+	return auth.Declined, nil
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package polkit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/internal/dbustest"
+	dbus "github.com/keybase/go.dbus"
+)
+
+// errFailed is returned by polkitd for invalid checks
+const errFailed = BusName + ".Error.Failed"
+
+// fakeAuthority answers every check with result, or blocks until the check is canceled when
+// result is nil. Like polkitd, it refuses details unless the caller is privileged.
+type fakeAuthority struct {
+	result     *authorizationResult
+	privileged bool
+
+	mu       sync.Mutex
+	action   string
+	flags    uint32
+	details  map[string]string
+	canceled map[string]chan struct{}
+}
+
+func (f *fakeAuthority) CheckAuthorization(s subject, action string, details map[string]string,
+	flags uint32, cancelID string) (authorizationResult, *dbus.Error) {
+	if len(details) > 0 && !f.privileged {
+		return authorizationResult{}, &dbus.Error{Name: errFailed, Body: []interface{}{
+			"Only trusted callers (e.g. uid 0 or an action owner) can use CheckAuthorization() and pass details"}}
+	}
+
+	f.mu.Lock()
+	f.action, f.flags, f.details = action, flags, details
+	canceled := make(chan struct{})
+	f.canceled[cancelID] = canceled
+	f.mu.Unlock()
+
+	if f.result != nil {
+		return *f.result, nil
+	}
+
+	<-canceled
+	return authorizationResult{}, &dbus.Error{Name: errCancelled, Body: []interface{}{"canceled"}}
+}
+
+func (f *fakeAuthority) CancelCheckAuthorization(cancelID string) *dbus.Error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if ch, ok := f.canceled[cancelID]; ok {
+		close(ch)
+		delete(f.canceled, cancelID)
+	}
+
+	return nil
+}
+
+func (f *fakeAuthority) pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.canceled)
+}
+
+// startPolkit serves a stand-in of polkit on a private bus and returns its address
+func startPolkit(t *testing.T, authority *fakeAuthority) string {
+	authority.canceled = make(map[string]chan struct{})
+
+	address := dbustest.Start(t)
+	dbustest.Serve(t, address, BusName, map[dbus.ObjectPath]map[string]interface{}{
+		AuthorityPath: {authorityInterface: authority},
+	})
+
+	return address
+}
+
+func TestAuthenticate(t *testing.T) {
+	tests := []struct {
+		name   string
+		result authorizationResult
+		want   auth.Result
+	}{
+		{"authorized", authorizationResult{IsAuthorized: true}, auth.Success},
+		{"dismissed", authorizationResult{Details: map[string]string{"polkit.dismissed": "true"}}, auth.Declined},
+		{"not authorized", authorizationResult{}, auth.Declined},
+		{"no agent", authorizationResult{IsChallenge: true}, auth.Unavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authority := &fakeAuthority{result: &tt.result}
+			a := Authenticator{Address: startPolkit(t, authority)}
+
+			req := auth.Request{Reason: "access the PIN", Key: auth.Key{Label: "test"}}
+			got, err := a.Authenticate(context.Background(), req)
+			if got != tt.want {
+				t.Fatalf("result mismatch got: %s (%v) want: %s", got, err, tt.want)
+			}
+
+			if authority.action != DefaultAction || authority.flags != allowUserInteraction {
+				t.Fatalf("unexpected check of %s with flags %d", authority.action, authority.flags)
+			}
+
+			if len(authority.details) != 0 {
+				t.Fatalf("no details should be sent by an unprivileged caller: %v", authority.details)
+			}
+		})
+	}
+}
+
+func TestAuthenticateTimeout(t *testing.T) {
+	authority := &fakeAuthority{}
+	a := Authenticator{Address: startPolkit(t, authority)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if got, _ := a.Authenticate(ctx, auth.Request{}); got != auth.Timeout {
+		t.Fatalf("waiting for the agent should time out, got: %s", got)
+	}
+
+	if authority.pending() != 0 {
+		t.Fatalf("the authorization check should be canceled")
+	}
+}
+
+func TestAuthenticateNoPolkit(t *testing.T) {
+	a := Authenticator{Address: dbustest.Start(t)}
+	if got, _ := a.Authenticate(context.Background(), auth.Request{}); got != auth.Unavailable {
+		t.Fatalf("a missing polkit should be reported, got: %s", got)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+//
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/auth/fprintd"
+	"github.com/jorgelbg/pinentry-touchid/auth/polkit"
+	"github.com/jorgelbg/pinentry-touchid/config"
+	"github.com/jorgelbg/pinentry-touchid/lockout"
+	"github.com/jorgelbg/pinentry-touchid/logging"
+	"github.com/jorgelbg/pinentry-touchid/policy"
+	touchid "github.com/lox/go-touchid"
+)
+
+// defaultAuthenticator is used when no authenticator is configured
+const defaultAuthenticator = "touchid"
+
+// newAuthenticator returns the authenticator registered with name. fprintd and polkit talk to the
+// system D-Bus, where it isn't running they are unavailable and the next one of the chain is used.
+func newAuthenticator(name string) (auth.Authenticator, error) {
+	switch name {
+	case "touchid":
+		return auth.Func(touchid.Authenticate), nil
+	case "fprintd":
+		return fprintd.Authenticator{}, nil
+	case "polkit":
+		return polkit.Authenticator{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown authenticator %q", name)
+}
+
+// usesSensor reports whether the authenticator registered with name needs the biometric sensor of
+// the device
+func usesSensor(name string) bool {
+	return name == "touchid"
+}
+
+// authenticatorFromConfig returns the chain of authenticators configured by the user, only Touch
+// ID by default
+func authenticatorFromConfig(cfg config.Config) (auth.Chain, error) {
+	names := cfg.Authenticators
+	if len(names) == 0 {
+		names = []string{defaultAuthenticator}
+	}
+
+	var chain auth.Chain
+	for _, name := range names {
+		a, err := newAuthenticator(name)
+		if err != nil {
+			return auth.Chain{}, err
+		}
+
+		chain.Links = append(chain.Links, auth.Link{Name: name, Authenticator: a})
+	}
+
+	return chain, nil
+}
+
+// wrapAuthenticator puts the lockout and the access policy configured by the user in front of
+// authenticator. The policy is evaluated first, a caller that isn't allowed doesn't count as a
+// failure.
+func wrapAuthenticator(cfg config.Config, authenticator auth.Authenticator, logger *logging.Logger) auth.Authenticator {
+	if !cfg.NoLockout {
+		authenticator = lockout.Authenticator{
+			Store: lockoutFromConfig(cfg),
+			Next:  authenticator,
+		}
+	}
+
+	accessPolicy, err := policyFromConfig(cfg)
+	if err != nil {
+		logger.Warn("Error parsing the access policy, every PIN has to be typed", "err", err)
+	}
+	if len(accessPolicy.Rules) > 0 {
+		logger.Debug("Access policy configured", "rules", len(accessPolicy.Rules))
+		authenticator = policy.Authenticator{
+			Policy:    accessPolicy,
+			Processes: processes,
+			Next:      authenticator,
+		}
+	}
+
+	return authenticator
+}
+
+// lockoutFromConfig returns the store of failed authentications, it lives next to the
+// configuration file
+func lockoutFromConfig(cfg config.Config) *lockout.Store {
+	policy := lockout.DefaultPolicy()
+	if cfg.LockoutFailures > 0 {
+		policy.MaxFailures = cfg.LockoutFailures
+	}
+	if cfg.LockoutCooldown > 0 {
+		policy.Cooldown = cfg.LockoutCooldown
+	}
+	if cfg.LockoutMaxCooldown > 0 {
+		policy.MaxCooldown = cfg.LockoutMaxCooldown
+	}
+
+	path := filepath.Join(filepath.Dir(config.DefaultPath()), lockout.DefaultFilename)
+
+	return lockout.NewStore(path, policy)
+}
+
+// policyFromConfig returns the access policy configured by the user. An invalid policy is
+// replaced by one that makes every PIN typed: a typo must not release PINs to callers the user
+// meant to keep out.
+func policyFromConfig(cfg config.Config) (policy.Policy, error) {
+	p, err := policy.Parse(cfg.Policy)
+	if err != nil {
+		return policy.Policy{Rules: []policy.Rule{{Action: policy.Fallback}}}, err
+	}
+
+	return p, nil
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/auth/fprintd"
+	"github.com/jorgelbg/pinentry-touchid/auth/polkit"
+	"github.com/jorgelbg/pinentry-touchid/config"
+	"github.com/jorgelbg/pinentry-touchid/lockout"
+	"github.com/jorgelbg/pinentry-touchid/logging"
+	"github.com/jorgelbg/pinentry-touchid/policy"
+	"github.com/jorgelbg/pinentry-touchid/sensor"
+)
+
+func TestAuthenticatorFromConfig(t *testing.T) {
+	chain, err := authenticatorFromConfig(config.Config{Authenticators: []string{"fprintd", "polkit", "touchid"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := chain.String(), "fprintd → polkit → touchid"; got != want {
+		t.Fatalf("chain mismatch got: %q want: %q", got, want)
+	}
+	if _, ok := chain.Links[0].Authenticator.(fprintd.Authenticator); !ok {
+		t.Fatalf("fprintd link mismatch got: %T", chain.Links[0].Authenticator)
+	}
+	if _, ok := chain.Links[1].Authenticator.(polkit.Authenticator); !ok {
+		t.Fatalf("polkit link mismatch got: %T", chain.Links[1].Authenticator)
+	}
+
+	// the keychain is still used without a sensor, the first links don't need it
+	if got := chooseMode(sensor.Fake{Reason: "no sensor"}.Probe(), chain); got != modeKeychain {
+		t.Fatalf("mode mismatch got: %d want: %d", got, modeKeychain)
+	}
+
+	if _, err := authenticatorFromConfig(config.Config{Authenticators: []string{"polkit", "faceid"}}); err == nil {
+		t.Fatal("expected an error for an unknown authenticator")
+	}
+}
+
+func TestWrapAuthenticator(t *testing.T) {
+	logger := logging.New(ioutil.Discard, logging.LevelDebug, logging.FormatText)
+	chain := auth.Chain{Links: []auth.Link{{Name: "scripted", Authenticator: auth.NewScripted()}}}
+
+	// the policy is evaluated before the lockout, which guards the chain
+	wrapped := wrapAuthenticator(config.Config{Policy: []string{"allow exe=gpg"}}, chain, logger)
+	p, ok := wrapped.(policy.Authenticator)
+	if !ok {
+		t.Fatalf("the policy should be evaluated first, got: %T", wrapped)
+	}
+	l, ok := p.Next.(lockout.Authenticator)
+	if !ok {
+		t.Fatalf("the lockout should follow the policy, got: %T", p.Next)
+	}
+	if next, ok := l.Next.(auth.Chain); !ok || next.String() != chain.String() {
+		t.Fatalf("the lockout should guard the chain, got: %T", l.Next)
+	}
+
+	wrapped = wrapAuthenticator(config.Config{NoLockout: true}, chain, logger)
+	if next, ok := wrapped.(auth.Chain); !ok || next.String() != chain.String() {
+		t.Fatalf("nothing should be added to the chain without lockout and policy, got: %T", wrapped)
+	}
+}
@@ -0,0 +1,303 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package backup saves every cached passphrase of a store to a single encrypted file and restores
+// them, e.g. on a new machine. The file is encrypted with the local gpg, either to the public key of
+// a recipient or with a passphrase.
+package backup
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"time"
+
+	"github.com/jorgelbg/pinentry-touchid/secret"
+	"github.com/jorgelbg/pinentry-touchid/store"
+)
+
+// Version of the format of the backups
+const Version = 1
+
+// Cipher encrypts and decrypts the contents of a backup
+type Cipher interface {
+	// Encrypt writes the encrypted plaintext to w
+	Encrypt(w io.Writer, plaintext []byte) error
+	// Decrypt returns the plaintext read from r
+	Decrypt(r io.Reader) (*secret.Buffer, error)
+}
+
+// GPG encrypts backups with gpg. The passphrase of a symmetric backup, or of the secret key of the
+// recipient, is asked by the pinentry of the gpg-agent.
+type GPG struct {
+	// Program is the gpg binary, "gpg" if empty
+	Program string
+	// Homedir of gpg, the default one if empty
+	Homedir string
+	// Recipient is the key the backup is encrypted to, if empty it is encrypted with a passphrase
+	Recipient string
+}
+
+// run executes gpg with args, stdin is written to its standard input
+func (g GPG) run(stdout io.Writer, stdin io.Reader, args ...string) error {
+	program := g.Program
+	if program == "" {
+		program = "gpg"
+	}
+
+	if g.Homedir != "" {
+		args = append([]string{"--homedir", g.Homedir}, args...)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(program, append([]string{"--quiet", "--no-tty"}, args...)...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 {
+			return fmt.Errorf("%s: %w: %s", program, err, msg)
+		}
+		return fmt.Errorf("%s: %w", program, err)
+	}
+
+	return nil
+}
+
+// Encrypt encrypts plaintext to the recipient, or with a passphrase if there is none
+func (g GPG) Encrypt(w io.Writer, plaintext []byte) error {
+	args := []string{"--symmetric", "--cipher-algo", "AES256"}
+	if g.Recipient != "" {
+		args = []string{"--encrypt", "--recipient", g.Recipient}
+	}
+
+	return g.run(w, bytes.NewReader(plaintext), append(args, "--output", "-")...)
+}
+
+// Decrypt decrypts a backup made with either mode. The plaintext is read into a buffer that the
+// caller has to wipe.
+func (g GPG) Decrypt(r io.Reader) (*secret.Buffer, error) {
+	var plaintext wipingBuffer
+	if err := g.run(&plaintext, r, "--decrypt", "--output", "-"); err != nil {
+		plaintext.wipe()
+		return nil, err
+	}
+
+	// the bytes of the buffer are handed over instead of copied
+	return secret.New(plaintext.b), nil
+}
+
+// wipingBuffer collects plaintext, unlike bytes.Buffer it wipes the old memory whenever it grows.
+// The output of a command is read straight into it, exec copies into a ReaderFrom without a buffer
+// of its own.
+type wipingBuffer struct {
+	b []byte
+}
+
+// grow makes room for n more bytes
+func (w *wipingBuffer) grow(n int) {
+	if len(w.b)+n <= cap(w.b) {
+		return
+	}
+
+	grown := make([]byte, len(w.b), 2*cap(w.b)+n)
+	copy(grown, w.b)
+	w.wipe()
+	w.b = grown
+}
+
+func (w *wipingBuffer) Write(p []byte) (int, error) {
+	w.grow(len(p))
+	w.b = append(w.b, p...)
+
+	return len(p), nil
+}
+
+func (w *wipingBuffer) ReadFrom(r io.Reader) (int64, error) {
+	var total int64
+	for {
+		w.grow(512)
+		n, err := r.Read(w.b[len(w.b):cap(w.b)])
+		w.b = w.b[:len(w.b)+n]
+		total += int64(n)
+
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// wipe clears the contents of w
+func (w *wipingBuffer) wipe() {
+	secret.Wipe(w.b[:cap(w.b)])
+	w.b = nil
+}
+
+// file is the plaintext of a backup
+type file struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	Entries []entry   `json:"entries"`
+}
+
+// entryInfo describes an entry of a backup
+type entryInfo struct {
+	Label    string    `json:"label"`
+	Keygrip  string    `json:"keygrip"`
+	Created  time.Time `json:"created,omitempty"`
+	Modified time.Time `json:"modified,omitempty"`
+}
+
+// entry is a cached passphrase in a backup
+type entry struct {
+	entryInfo
+	Passphrase []byte `json:"passphrase"`
+}
+
+// wipe clears the passphrases of f
+func (f *file) wipe() {
+	for i := range f.Entries {
+		secret.Wipe(f.Entries[i].Passphrase)
+	}
+}
+
+// encode returns f as JSON. encoding/json keeps its buffers for reuse, so only the attributes are
+// encoded with it and the passphrases are base64 encoded straight into a buffer of the final size.
+func (f *file) encode() (*secret.Buffer, error) {
+	head, err := json.Marshal(struct {
+		Version int       `json:"version"`
+		Created time.Time `json:"created"`
+	}{f.Version, f.Created})
+	if err != nil {
+		return nil, err
+	}
+
+	// every entry is written as its attributes followed by the passphrase, the closing brace of
+	// the attributes is moved after it
+	infos := make([][]byte, len(f.Entries))
+	size := len(head) - 1 + len(`,"entries":[]}`+"\n")
+	for i, e := range f.Entries {
+		if infos[i], err = json.Marshal(e.entryInfo); err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			size++
+		}
+		size += len(infos[i]) + len(`,"passphrase":""`) + base64.StdEncoding.EncodedLen(len(e.Passphrase))
+	}
+
+	b := make([]byte, 0, size)
+	b = append(b, head[:len(head)-1]...)
+	b = append(b, `,"entries":[`...)
+	for i, e := range f.Entries {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, infos[i][:len(infos[i])-1]...)
+		b = append(b, `,"passphrase":"`...)
+		n := base64.StdEncoding.EncodedLen(len(e.Passphrase))
+		base64.StdEncoding.Encode(b[len(b):len(b)+n], e.Passphrase)
+		b = append(b[:len(b)+n], `"}`...)
+	}
+	b = append(b, "]}\n"...)
+
+	return secret.New(b), nil
+}
+
+// Export writes every entry of src to w encrypted with c and returns the entries that were saved
+func Export(w io.Writer, src store.Store, c Cipher) ([]store.Entry, error) {
+	entries, err := src.List()
+	if err != nil {
+		return nil, fmt.Errorf("listing the entries: %w", err)
+	}
+
+	var pins []*secret.Buffer
+	defer func() {
+		for _, pin := range pins {
+			pin.Wipe()
+		}
+	}()
+
+	f := file{Version: Version, Created: time.Now().UTC()}
+	for _, e := range entries {
+		pin, err := src.Get(e.Label, e.Keygrip)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", e.Label, err)
+		}
+		pins = append(pins, pin)
+
+		f.Entries = append(f.Entries, entry{
+			entryInfo: entryInfo{
+				Label:    e.Label,
+				Keygrip:  e.Keygrip,
+				Created:  e.Created,
+				Modified: e.Modified,
+			},
+			Passphrase: pin.Bytes(),
+		})
+	}
+
+	plaintext, err := f.encode()
+	if err != nil {
+		return nil, err
+	}
+	defer plaintext.Wipe()
+
+	if err := c.Encrypt(w, plaintext.Bytes()); err != nil {
+		return nil, fmt.Errorf("encrypting the backup: %w", err)
+	}
+
+	return entries, nil
+}
+
+// Import decrypts the backup read from r with c and copies its entries to dst with store.Migrate,
+// so every copy is verified and opts.DryRun only reports them. opts.Remove is ignored.
+func Import(r io.Reader, dst store.Store, c Cipher, opts store.MigrateOptions) (int, error) {
+	plaintext, err := c.Decrypt(r)
+	if err != nil {
+		return 0, fmt.Errorf("decrypting the backup: %w", err)
+	}
+	defer plaintext.Wipe()
+
+	var f file
+	defer f.wipe()
+	if err := json.Unmarshal(plaintext.Bytes(), &f); err != nil {
+		return 0, fmt.Errorf("reading the backup: %w", err)
+	}
+
+	if f.Version != Version {
+		return 0, fmt.Errorf("unsupported backup version %d", f.Version)
+	}
+
+	src := store.NewMemory()
+	defer func() {
+		// removing the entries wipes their passphrases
+		entries, _ := src.List()
+		for _, e := range entries {
+			_ = src.Delete(e)
+		}
+	}()
+
+	for _, e := range f.Entries {
+		if e.Keygrip == "" {
+			return 0, errors.New("reading the backup: an entry doesn't have a keygrip")
+		}
+
+		err := src.Put(store.Entry{Label: e.Label, Keygrip: e.Keygrip, Created: e.Created}, e.Passphrase)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	opts.Remove = false
+	return store.Migrate(src, dst, opts)
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/jorgelbg/pinentry-touchid/secret"
+	"github.com/jorgelbg/pinentry-touchid/store"
+)
+
+// reversing is a Cipher that stores the plaintext reversed, so it is not readable as is
+type reversing struct{}
+
+func (reversing) Encrypt(w io.Writer, plaintext []byte) error {
+	out := make([]byte, len(plaintext))
+	for i, b := range plaintext {
+		out[len(out)-1-i] = b
+	}
+
+	_, err := w.Write(out)
+	return err
+}
+
+func (reversing) Decrypt(r io.Reader) (*secret.Buffer, error) {
+	in, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(in)-1; i < j; i, j = i+1, j-1 {
+		in[i], in[j] = in[j], in[i]
+	}
+
+	return secret.New(in), nil
+}
+
+// roundTrip exports the entries of a store with c and imports them into an empty one
+func roundTrip(t *testing.T, c Cipher) {
+	src := store.NewMemory()
+	created := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	for _, e := range []store.Entry{
+		{Label: "label A", Keygrip: "A", Created: created},
+		{Label: "label B", Keygrip: "B"},
+	} {
+		if err := src.Put(e, []byte("secret "+e.Keygrip)); err != nil {
+			t.Fatalf("storing an entry should succeed: %s", err)
+		}
+	}
+
+	var out bytes.Buffer
+	exported, err := Export(&out, src, c)
+	if err != nil || len(exported) != 2 {
+		t.Fatalf("exporting should succeed: %+v (%v)", exported, err)
+	}
+
+	if bytes.Contains(out.Bytes(), []byte("secret")) {
+		t.Fatalf("the backup should be encrypted: %q", out.Bytes())
+	}
+
+	dst := store.NewMemory()
+	n, err := Import(bytes.NewReader(out.Bytes()), dst, c, store.MigrateOptions{DryRun: true})
+	if err != nil || n != 2 {
+		t.Fatalf("a dry run should report every entry: %d (%v)", n, err)
+	}
+	if entries, _ := dst.List(); len(entries) != 0 {
+		t.Fatalf("a dry run should not import entries: %+v", entries)
+	}
+
+	n, err = Import(bytes.NewReader(out.Bytes()), dst, c, store.MigrateOptions{})
+	if err != nil || n != 2 {
+		t.Fatalf("importing should succeed: %d (%v)", n, err)
+	}
+
+	for _, k := range []string{"A", "B"} {
+		pin, err := dst.Get("label "+k, k)
+		if err != nil || string(pin.Bytes()) != "secret "+k {
+			t.Fatalf("%s: unexpected passphrase %q (%v)", k, pin.Bytes(), err)
+		}
+	}
+
+	if e, _, _ := dst.Find("label A", "A"); !e.Created.Equal(created) {
+		t.Fatalf("the creation date should be restored: %+v", e)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	roundTrip(t, reversing{})
+}
+
+func TestEncode(t *testing.T) {
+	f := file{Version: Version, Created: time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC), Entries: []entry{
+		{entryInfo: entryInfo{Label: `label "A"`, Keygrip: "A"}, Passphrase: []byte("secret A")},
+		{entryInfo: entryInfo{Label: "label B", Keygrip: "B"}, Passphrase: []byte{}},
+	}}
+
+	plaintext, err := f.encode()
+	if err != nil {
+		t.Fatalf("encoding should succeed: %s", err)
+	}
+
+	want, _ := json.Marshal(f)
+	if got := plaintext.Bytes(); string(got) != string(want)+"\n" {
+		t.Fatalf("encoding mismatch got: %s want: %s", got, want)
+	}
+
+	// the buffer is never grown, which would leave a copy behind
+	if b := plaintext.Bytes(); len(b) != cap(b) {
+		t.Fatalf("the buffer should be sized for the file, len: %d cap: %d", len(b), cap(b))
+	}
+}
+
+func TestWipingBuffer(t *testing.T) {
+	var w wipingBuffer
+	if _, err := w.Write([]byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	old := w.b[:cap(w.b)]
+
+	if _, err := w.ReadFrom(bytes.NewReader(bytes.Repeat([]byte("x"), 1000))); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(old, make([]byte, len(old))) {
+		t.Fatalf("the memory left behind should be wiped: %q", old)
+	}
+	if len(w.b) != 1006 || string(w.b[:6]) != "secret" {
+		t.Fatalf("unexpected contents: %q", w.b)
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	var out bytes.Buffer
+	if err := (reversing{}).Encrypt(&out, []byte(`{"version": 2}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Import(&out, store.NewMemory(), reversing{}, store.MigrateOptions{}); err == nil {
+		t.Fatalf("an unknown version should be rejected")
+	}
+}
+
+func TestGPG(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+
+	home := t.TempDir()
+	t.Cleanup(func() { _ = exec.Command("gpgconf", "--homedir", home, "--kill", "gpg-agent").Run() })
+	recipient := "backup@example.com"
+	cmd := exec.Command("gpg", "--homedir", home, "--batch", "--passphrase", "",
+		"--quick-generate-key", recipient, "default", "default", "never")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("couldn't generate a key: %s: %s", err, out)
+	}
+
+	roundTrip(t, GPG{Homedir: home, Recipient: recipient})
+}
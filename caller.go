@@ -0,0 +1,98 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+//
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/foxcpp/go-assuan/pinentry"
+	"github.com/jorgelbg/pinentry-touchid/logging"
+	"github.com/jorgelbg/pinentry-touchid/process"
+)
+
+// processes is the process table where the callers are looked up
+var processes process.Table = process.Local()
+
+// requestCaller is the process that asked the gpg-agent for a PIN
+type requestCaller struct {
+	// owner is the zero value if the gpg-agent didn't send it
+	owner process.Owner
+	// tree is the process followed by its ancestors, nil if it couldn't be looked up
+	tree []process.Process
+}
+
+// identifyCaller looks up the owner of the request s in the process table. Identifying the caller
+// is informative, a request from an unknown process is still served.
+func identifyCaller(s pinentry.Settings, logger *logging.Logger) requestCaller {
+	if s.Opts.Owner == "" {
+		return requestCaller{}
+	}
+
+	owner, err := process.ParseOwner(s.Opts.Owner)
+	if err != nil {
+		logger.Warn("Invalid owner sent by the gpg-agent", "owner", s.Opts.Owner, "err", err)
+		return requestCaller{}
+	}
+
+	c := requestCaller{owner: owner}
+	if !owner.Local() {
+		return c
+	}
+
+	if c.tree, err = process.Tree(processes, owner.PID); err != nil {
+		logger.Warn("Error looking up the requesting process", "pid", owner.PID, "err", err)
+	}
+
+	return c
+}
+
+// known reports whether anything is known about the caller
+func (c requestCaller) known() bool {
+	return c.owner.PID != 0
+}
+
+// String describes the caller by its process tree (git commit ← bash ← tmux), or by its PID if
+// the tree is unknown
+func (c requestCaller) String() string {
+	switch {
+	case len(c.tree) > 0:
+		return process.Chain(c.tree)
+	case !c.known():
+		return ""
+	case !c.owner.Local():
+		return fmt.Sprintf("process %d on %s", c.owner.PID, c.owner.Host)
+	}
+
+	return fmt.Sprintf("process %d", c.owner.PID)
+}
+
+// reason adds the caller to the reason shown in the authentication prompt
+func (c requestCaller) reason(reason string) string {
+	if !c.known() {
+		return reason
+	}
+
+	return fmt.Sprintf("%s, requested by %s", reason, c)
+}
+
+// logFields returns the key/value pairs that describe the caller in the log
+func (c requestCaller) logFields() []interface{} {
+	if !c.known() {
+		return nil
+	}
+
+	fields := []interface{}{"pid", c.owner.PID, "caller", c.String()}
+	if len(c.tree) > 0 {
+		fields = append(fields, "exe", c.tree[0].Exe)
+		if c.tree[0].TTY != "" {
+			fields = append(fields, "tty", c.tree[0].TTY)
+		}
+	}
+
+	return fields
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/foxcpp/go-assuan/pinentry"
+	"github.com/jorgelbg/pinentry-touchid/audit"
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/logging"
+	"github.com/jorgelbg/pinentry-touchid/policy"
+	"github.com/jorgelbg/pinentry-touchid/process"
+)
+
+func TestIdentifyCaller(t *testing.T) {
+	defer func(t process.Table) { processes = t }(processes)
+	processes = process.Fake{
+		10: {PPID: 1, Exe: "/usr/local/bin/tmux", Args: []string{"tmux"}},
+		20: {PPID: 10, Exe: "/bin/bash", Args: []string{"-bash"}, TTY: "/dev/ttys001"},
+		30: {PPID: 20, Exe: "/usr/bin/git", Args: []string{"git", "commit", "-S"}, TTY: "/dev/ttys001"},
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	tests := []struct {
+		owner  string
+		caller string
+		reason string
+	}{
+		{"30/501 " + host, "git commit ← bash ← tmux", "access the PIN, requested by git commit ← bash ← tmux"},
+		{"30/501", "git commit ← bash ← tmux", "access the PIN, requested by git commit ← bash ← tmux"},
+		{"40/501 " + host, "process 40", "access the PIN, requested by process 40"},
+		{"30/501 elsewhere.invalid", "process 30 on elsewhere.invalid", "access the PIN, requested by process 30 on elsewhere.invalid"},
+		{"", "", "access the PIN"},
+		{"not an owner", "", "access the PIN"},
+	}
+
+	for _, tt := range tests {
+		var logs bytes.Buffer
+		logger := logging.New(&logs, logging.LevelDebug, logging.FormatText)
+
+		s := pinentry.Settings{}
+		s.Opts.Owner = tt.owner
+
+		c := identifyCaller(s, logger)
+		if got := c.String(); got != tt.caller {
+			t.Fatalf("%q: caller mismatch got: %q want: %q", tt.owner, got, tt.caller)
+		}
+		if got := c.reason("access the PIN"); got != tt.reason {
+			t.Fatalf("%q: reason mismatch got: %q want: %q", tt.owner, got, tt.reason)
+		}
+	}
+
+	s := pinentry.Settings{}
+	s.Opts.Owner = "30/501"
+
+	var logs bytes.Buffer
+	logger := logging.New(&logs, logging.LevelDebug, logging.FormatText)
+	logger.With(identifyCaller(s, logger).logFields()...).Info("PIN requested")
+	for _, field := range []string{"pid=30", `caller="git commit ← bash ← tmux"`, "exe=/usr/bin/git", "tty=/dev/ttys001"} {
+		if !strings.Contains(logs.String(), field) {
+			t.Fatalf("the log should record %s: %s", field, logs.String())
+		}
+	}
+}
+
+func TestGetPINPolicy(t *testing.T) {
+	keychainLabel := `Firstname Lastname <test@email.com> (61AF059BD632F971)`
+	defer func() { _ = cleanKeychain(keychainLabel) }()
+
+	if err := storePasswordInKeychain(keychainLabel, keyInfo, []byte(testPassword)); err != nil {
+		t.Fatalf("failed precreating entry in the Keychain: %s", err)
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	defer func(t process.Table) { processes = t }(processes)
+	processes = process.Fake{
+		20: {PPID: 1, Exe: "/bin/bash", Args: []string{"-bash"}, TTY: "/dev/ttys001"},
+		30: {PPID: 20, Exe: "/usr/bin/git", Args: []string{"git", "commit", "-S"}, TTY: "/dev/ttys001"},
+		40: {PPID: 1, Exe: "/usr/local/bin/backup-job"},
+		50: {PPID: 20, Exe: "/tmp/evil", TTY: "/dev/ttys001"},
+	}
+
+	p, err := policy.Parse([]string{"deny exe=/tmp/*", "allow exe=git parent=bash", "fallback"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := logging.New(ioutil.Discard, logging.LevelDebug, logging.FormatText)
+	trail := audit.New(filepath.Join(t.TempDir(), audit.DefaultFilename))
+	authenticator := policy.Authenticator{Policy: p, Processes: processes, Next: auth.NewScripted(auth.Success)}
+
+	fn := GetPIN(authenticator, dummyPrompt, nil, trail, logger)
+	for _, pid := range []int{30, 40, 50} {
+		params := pinentry.Settings{Desc: keyDesc, KeyInfo: keyInfo}
+		params.Opts.Owner = fmt.Sprintf("%d/501 %s", pid, host)
+
+		pass, _ := fn(params)
+		for j := range pass {
+			pass[j] = 0
+		}
+	}
+
+	records, err := trail.Records()
+	if err != nil {
+		t.Fatalf("reading the audit log should succeed: %s", err)
+	}
+
+	want := []struct {
+		mode    audit.Mode
+		outcome audit.Outcome
+		process string
+	}{
+		{audit.ModeCache, audit.Released, "git commit ← bash"},
+		{audit.ModePrompt, audit.Typed, "backup-job"},
+		{audit.ModeCache, audit.Denied, "evil ← bash"},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("expected %d records, got: %+v", len(want), records)
+	}
+	for i, r := range records {
+		if r.Mode != want[i].mode || r.Outcome != want[i].outcome || r.Process != want[i].process {
+			t.Fatalf("record %d mismatch got: %s %s %q want: %s %s %q", r.Seq, r.Mode, r.Outcome, r.Process,
+				want[i].mode, want[i].outcome, want[i].process)
+		}
+	}
+
+	if !strings.Contains(records[2].Error, "the access policy denies evil ← bash") {
+		t.Fatalf("the denied record should explain the decision: %s", records[2].Error)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+//
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/enescakir/emoji"
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/backup"
+	"github.com/jorgelbg/pinentry-touchid/config"
+	"github.com/jorgelbg/pinentry-touchid/lockout"
+	"github.com/jorgelbg/pinentry-touchid/store"
+)
+
+// runCommand executes one of the maintenance commands and returns the exit code
+func runCommand(name string, args []string) int {
+	cfg, err := config.Load(config.DefaultPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v %s: %s\n", emoji.CrossMark, config.DefaultPath(), err)
+		return -1
+	}
+
+	switch name {
+	case "reset-lockout":
+		err = resetLockout(lockoutFromConfig(cfg), args)
+	case "list":
+		err = listEntries(os.Stdout, entriesFromConfig(cfg))
+	case "show":
+		err = showEntries(os.Stdout, entriesFromConfig(cfg), args)
+	case "reentry":
+		err = setReentry(os.Stdout, entriesFromConfig(cfg), args)
+	case "dedupe":
+		dryRun := len(args) == 1 && (args[0] == "-n" || args[0] == "--dry-run")
+		if len(args) > 0 && !dryRun {
+			err = fmt.Errorf("usage: dedupe [-n]")
+			break
+		}
+		logger, closer := loggerFromConfig(cfg)
+		defer closer.Close()
+
+		var chain auth.Chain
+		if chain, err = authenticatorFromConfig(cfg); err == nil {
+			err = dedupe(os.Stdout, wrapAuthenticator(cfg, chain, logger), entriesFromConfig(cfg),
+				auditFromConfig(cfg), dryRun, logger)
+		}
+	case "import-pinentry-mac":
+		var dryRun, remove bool
+		fs := flag.NewFlagSet(name, flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		fs.BoolVar(&dryRun, "n", false, "")
+		fs.BoolVar(&dryRun, "dry-run", false, "")
+		fs.BoolVar(&remove, "remove", cfg.RemovePinentryMac, "")
+		if fs.Parse(args) != nil || fs.NArg() > 0 {
+			err = fmt.Errorf("usage: import-pinentry-mac [-n] [--remove]")
+			break
+		}
+
+		logger, closer := loggerFromConfig(cfg)
+		defer closer.Close()
+
+		var chain auth.Chain
+		if chain, err = authenticatorFromConfig(cfg); err == nil {
+			err = importPinentryMac(os.Stdout, wrapAuthenticator(cfg, chain, logger), entriesFromConfig(cfg),
+				dryRun, remove)
+		}
+	case "migrate":
+		var (
+			opts     migrateOptions
+			src, dst store.Store
+			chain    auth.Chain
+		)
+		if opts, err = parseMigrateArgs(args); err != nil {
+			break
+		}
+		if src, err = storeFromName(opts.from); err != nil {
+			break
+		}
+		if dst, err = storeFromName(opts.to); err != nil {
+			break
+		}
+
+		logger, closer := loggerFromConfig(cfg)
+		defer closer.Close()
+
+		if chain, err = authenticatorFromConfig(cfg); err == nil {
+			err = migrate(os.Stdout, wrapAuthenticator(cfg, chain, logger), src, dst, opts)
+		}
+	case "export", "import":
+		var (
+			opts  backupOptions
+			s     store.Store
+			chain auth.Chain
+		)
+		if opts, err = parseBackupArgs(name, args); err != nil {
+			break
+		}
+		if s, err = storeFromName(opts.backend); err != nil {
+			break
+		}
+
+		logger, closer := loggerFromConfig(cfg)
+		defer closer.Close()
+
+		cipher := backup.GPG{Recipient: opts.recipient}
+		if name == "import" {
+			err = importEntries(os.Stdout, s, cipher, opts, logger)
+			break
+		}
+		if chain, err = authenticatorFromConfig(cfg); err == nil {
+			err = exportEntries(os.Stdout, wrapAuthenticator(cfg, chain, logger), s, cipher, opts,
+				auditFromConfig(cfg), logger)
+		}
+	case "audit":
+		err = auditCommand(os.Stdout, auditFromConfig(cfg), args)
+	case "stats":
+		err = statsCommand(os.Stdout, auditFromConfig(cfg), args)
+	default:
+		err = fmt.Errorf("unknown command %q", name)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v %s\n", emoji.CrossMark, err)
+		return -1
+	}
+
+	return 0
+}
+
+// resetLockout forgets the failed authentications of the keys given by label or cache ID, or of
+// every key if none is given
+func resetLockout(store *lockout.Store, keys []string) error {
+	if len(keys) == 0 {
+		n, err := store.Reset("")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stdout, "%v Reset the failed authentications of %d key(s)\n", emoji.CheckMarkButton, n)
+		return nil
+	}
+
+	states, err := store.States()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range keys {
+		found := false
+		for key, state := range states {
+			if key != name && state.Label != name {
+				continue
+			}
+
+			if _, err := store.Reset(key); err != nil {
+				return err
+			}
+			found = true
+			fmt.Fprintf(os.Stdout, "%v Reset the failed authentications of %s\n", emoji.CheckMarkButton, state.Label)
+		}
+
+		if !found {
+			fmt.Fprintf(os.Stdout, "%v No failed authentications for %s\n", emoji.Information, name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,225 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package config reads the pinentry-touchid configuration file. The file lives next to the
+// gpg-agent configuration and follows the same syntax: one option per line, the option name is
+// followed by an optional value and lines starting with # are comments.
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultFilename is the name of the configuration file in the GnuPG home directory
+const DefaultFilename = "pinentry-touchid.conf"
+
+// Config holds the options read from the configuration file
+type Config struct {
+	// Authenticators verify the user before a PIN is released from the keychain: touchid
+	// (default), fprintd or polkit. They are tried in order until one of them is available.
+	Authenticators []string
+	// NoLockout disables locking keys after too many failed authentications
+	NoLockout bool
+	// LockoutFailures is the number of failed authentications before a key is locked, the
+	// default is used when it is zero
+	LockoutFailures int
+	// LockoutCooldown is the duration of the first lockout, it doubles with each further failure
+	LockoutCooldown time.Duration
+	// LockoutMaxCooldown caps the duration of a lockout
+	LockoutMaxCooldown time.Duration
+	// ReentryMaxAge is how long a cached passphrase is released before it has to be typed again,
+	// disabled if zero
+	ReentryMaxAge time.Duration
+	// ReentryEvery forces typing a cached passphrase on every Nth use, disabled if zero
+	ReentryEvery int
+	// GenPIN enables generating a passphrase when gpg-agent asks for a new one and allows it
+	GenPIN bool
+	// GenPINCharset are the characters used for generated passphrases
+	GenPINCharset string
+	// GenPINWordlist is a file with the words used for generated passphrases, takes precedence
+	// over GenPINCharset
+	GenPINWordlist string
+	// GenPINLength is the number of characters (or words) of generated passphrases, when it is
+	// zero the length is derived from the minimum entropy
+	GenPINLength int
+	// LogSink is where the log records are written: file (default), stderr, syslog or off
+	LogSink string
+	// LogFile is the location of the log file, a private file in the cache directory of the user
+	// if empty
+	LogFile string
+	// LogLevel is the lowest level of the records written: debug, info (default), warn or error
+	LogLevel string
+	// LogFormat of the records: text (default) or json
+	LogFormat string
+	// LogMaxSize is the size in bytes of the log file before it is rotated, the default is used
+	// when it is zero
+	LogMaxSize int64
+	// LogMaxFiles is the number of rotated log files kept, the default is used when it is zero
+	LogMaxFiles int
+	// Trace logs every line exchanged with the gpg-agent and pinentry-mac at the debug level, the
+	// PINs are redacted
+	Trace bool
+	// Policy are the rules that decide which callers may have a cached PIN released, evaluated in
+	// order, see policy.ParseRule. Every caller is allowed if empty.
+	Policy []string
+	// RemovePinentryMac removes the entries of pinentry-mac once their passphrase is imported, they
+	// are kept by default
+	RemovePinentryMac bool
+}
+
+// DefaultPath returns the location of the configuration file in the GnuPG home directory
+func DefaultPath() string {
+	home := os.Getenv("GNUPGHOME")
+	if home == "" {
+		userHome, err := os.UserHomeDir()
+		if err != nil {
+			return DefaultFilename
+		}
+		home = filepath.Join(userHome, ".gnupg")
+	}
+
+	return filepath.Join(home, DefaultFilename)
+}
+
+// Load reads the configuration file at path. A missing file is not an error, the default
+// configuration is returned instead.
+func Load(path string) (Config, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Config{}, nil
+	}
+	if err != nil {
+		return Config{}, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// Parse reads a configuration from r
+func Parse(r io.Reader) (Config, error) {
+	var c Config
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, " ", 2)
+		name, value := parts[0], ""
+		if len(parts) == 2 {
+			value = strings.TrimSpace(parts[1])
+		}
+
+		if err := c.set(name, value); err != nil {
+			return Config{}, fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+
+	return c, scanner.Err()
+}
+
+func (c *Config) set(name, value string) error {
+	var err error
+
+	switch name {
+	case "authenticator":
+		c.Authenticators = strings.Fields(value)
+	case "no-lockout":
+		c.NoLockout = true
+	case "lockout-failures":
+		c.LockoutFailures, err = strconv.Atoi(value)
+	case "lockout-cooldown":
+		c.LockoutCooldown, err = ParseDuration(value)
+	case "lockout-max-cooldown":
+		c.LockoutMaxCooldown, err = ParseDuration(value)
+	case "reentry-max-age":
+		c.ReentryMaxAge, err = ParseDuration(value)
+	case "reentry-every":
+		c.ReentryEvery, err = strconv.Atoi(value)
+	case "genpin":
+		c.GenPIN = true
+	case "genpin-charset":
+		c.GenPINCharset = value
+	case "genpin-wordlist":
+		c.GenPINWordlist = value
+	case "genpin-length":
+		c.GenPINLength, err = strconv.Atoi(value)
+	case "log-sink":
+		c.LogSink = value
+	case "log-file":
+		c.LogFile = value
+	case "log-level":
+		c.LogLevel = value
+	case "log-format":
+		c.LogFormat = value
+	case "log-max-size":
+		c.LogMaxSize, err = ParseSize(value)
+	case "log-max-files":
+		c.LogMaxFiles, err = strconv.Atoi(value)
+	case "trace":
+		c.Trace = true
+	case "policy":
+		c.Policy = append(c.Policy, value)
+	case "remove-pinentry-mac":
+		c.RemovePinentryMac = true
+	default:
+		return fmt.Errorf("unknown option %q", name)
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid value for %q: %w", name, err)
+	}
+
+	return nil
+}
+
+// ParseDuration parses a duration like time.ParseDuration, whole days can be given with a d
+// suffix (e.g. 30d)
+func ParseDuration(value string) (time.Duration, error) {
+	if days := strings.TrimSuffix(value, "d"); days != value {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// ParseSize parses a size in bytes, a K, M or G suffix multiplies it by 1024, 1024² or 1024³
+// (e.g. 10M)
+func ParseSize(value string) (int64, error) {
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(value, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(value, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(value, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+
+	return n * multiplier, nil
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	c, err := Parse(strings.NewReader(`
+authenticator fprintd polkit
+lockout-failures 3
+lockout-cooldown 30s
+reentry-max-age 30d
+reentry-every 10
+# generate passphrases for new keys
+genpin
+genpin-wordlist /usr/share/dict/eff_large_wordlist.txt
+genpin-length 7
+log-sink syslog
+log-level debug
+log-format json
+log-max-size 5M
+log-max-files 2
+trace
+policy deny parent=/tmp/*
+policy allow exe=gpg,git,ssh parent=tmux,sshd tty=*
+policy fallback
+remove-pinentry-mac
+`))
+	if err != nil {
+		t.Fatalf("parsing a valid configuration should succeed: %s", err)
+	}
+
+	want := Config{
+		Authenticators:  []string{"fprintd", "polkit"},
+		LockoutFailures: 3,
+		LockoutCooldown: 30 * time.Second,
+		ReentryMaxAge:   30 * 24 * time.Hour,
+		ReentryEvery:    10,
+		GenPIN:          true,
+		GenPINWordlist:  "/usr/share/dict/eff_large_wordlist.txt",
+		GenPINLength:    7,
+		LogSink:         "syslog",
+		LogLevel:        "debug",
+		LogFormat:       "json",
+		LogMaxSize:      5 << 20,
+		LogMaxFiles:     2,
+		Trace:           true,
+		Policy: []string{
+			"deny parent=/tmp/*",
+			"allow exe=gpg,git,ssh parent=tmux,sshd tty=*",
+			"fallback",
+		},
+		RemovePinentryMac: true,
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Fatalf("configuration mismatch got: %+v want: %+v", c, want)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, input := range []string{"unknown-option", "genpin-length many", "lockout-cooldown soon", "reentry-max-age monthd", "log-max-size 5MB"} {
+		if _, err := Parse(strings.NewReader(input)); err == nil {
+			t.Fatalf("parsing %q should fail", input)
+		}
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	c, err := Load(filepath.Join(t.TempDir(), DefaultFilename))
+	if err != nil {
+		t.Fatalf("a missing configuration file should not be an error: %s", err)
+	}
+
+	if !reflect.DeepEqual(c, Config{}) {
+		t.Fatalf("the default configuration should be returned, got: %+v", c)
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+//
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/enescakir/emoji"
+	"github.com/foxcpp/go-assuan/pinentry"
+	"github.com/jorgelbg/pinentry-touchid/audit"
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/logging"
+	"github.com/jorgelbg/pinentry-touchid/metadata"
+	"github.com/jorgelbg/pinentry-touchid/secret"
+	"github.com/keybase/go-keychain"
+)
+
+const (
+	// keychainService is the service of the items created by pinentry-mac and pinentry-touchid
+	keychainService = "GnuPG"
+	// keychainDescription marks the items created by pinentry-touchid, it is shown as the kind of
+	// the item in the Keychain Access app
+	keychainDescription = "pinentry-touchid passphrase"
+)
+
+// keychainMatches returns the attributes of every generic password in the keychain with the given
+// label, ranked with rankMatches. The data is not returned, reading it may require the user to
+// authorize it for every item.
+func keychainMatches(label, keygrip string) ([]keychain.QueryResult, error) {
+	query := keychain.NewItem()
+	query.SetSecClass(keychain.SecClassGenericPassword)
+	query.SetLabel(label)
+	query.SetMatchLimit(keychain.MatchLimitAll)
+	query.SetReturnAttributes(true)
+
+	results, err := keychain.QueryItem(query)
+	if err == keychain.ErrorItemNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rankMatches(results, keygrip)
+
+	return results, nil
+}
+
+// matchScore rates how likely it is that item holds the passphrase of keygrip
+func matchScore(item keychain.QueryResult, keygrip string) int {
+	score := 0
+	if keygrip != "" && keygripFromAccount(item.Account) == keygrip {
+		score += 4
+	}
+	if item.Service == keychainService {
+		score += 2
+	}
+	// items created by pinentry-touchid can be read without asking the user to allow it
+	if item.Description == keychainDescription {
+		score++
+	}
+
+	return score
+}
+
+// rankMatches sorts the items that share a label, the most likely to hold the passphrase of keygrip
+// first: the account is the keygrip, the service is the one used by GnuPG and the item was created
+// by pinentry-touchid. Ties are broken by the most recently modified item and the order is
+// deterministic even if all attributes match.
+func rankMatches(items []keychain.QueryResult, keygrip string) {
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if sa, sb := matchScore(a, keygrip), matchScore(b, keygrip); sa != sb {
+			return sa > sb
+		}
+		if !a.ModificationDate.Equal(b.ModificationDate) {
+			return a.ModificationDate.After(b.ModificationDate)
+		}
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		return a.Account < b.Account
+	})
+}
+
+// keychainData reads the password of item, the service and account identify a single item
+func keychainData(item keychain.QueryResult) (*secret.Buffer, error) {
+	query := keychain.NewItem()
+	query.SetSecClass(keychain.SecClassGenericPassword)
+	query.SetService(item.Service)
+	query.SetAccount(item.Account)
+	query.SetLabel(item.Label)
+	query.SetMatchLimit(keychain.MatchLimitOne)
+	query.SetReturnData(true)
+
+	results, err := keychain.QueryItem(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(results) == 0 {
+		return nil, errEmptyResults
+	}
+
+	return secret.New(results[0].Data), nil
+}
+
+// deleteKeychainItem removes item from the keychain
+func deleteKeychainItem(item keychain.QueryResult) error {
+	query := keychain.NewItem()
+	query.SetSecClass(keychain.SecClassGenericPassword)
+	query.SetService(item.Service)
+	query.SetAccount(item.Account)
+	query.SetLabel(item.Label)
+
+	return keychain.DeleteItem(query)
+}
+
+// dedupe merges the items of the GnuPG service that share a label. The best ranked item is kept,
+// it takes the passphrase of the most recently modified item, and the others are removed. The
+// keygrip of a label is taken from its metadata record, if any. The user authenticates once before
+// any passphrase is read and every merged label is recorded in trail. With dryRun the changes are
+// only printed.
+func dedupe(w io.Writer, authenticator auth.Authenticator, entries *metadata.Store, trail *audit.Log,
+	dryRun bool, logger *logging.Logger) error {
+	query := keychain.NewItem()
+	query.SetSecClass(keychain.SecClassGenericPassword)
+	query.SetService(keychainService)
+	query.SetMatchLimit(keychain.MatchLimitAll)
+	query.SetReturnAttributes(true)
+
+	results, err := keychain.QueryItem(query)
+	if err != nil && err != keychain.ErrorItemNotFound {
+		return err
+	}
+
+	groups := make(map[string][]keychain.QueryResult)
+	var labels []string
+	for _, item := range results {
+		if len(groups[item.Label]) == 0 {
+			labels = append(labels, item.Label)
+		}
+		groups[item.Label] = append(groups[item.Label], item)
+	}
+	sort.Strings(labels)
+
+	var duplicated []string
+	for _, label := range labels {
+		if len(groups[label]) > 1 {
+			duplicated = append(duplicated, label)
+		}
+	}
+
+	if len(duplicated) == 0 {
+		fmt.Fprintf(w, "%v No duplicated entries found\n", emoji.CheckMarkButton)
+		return nil
+	}
+
+	s := pinentry.Settings{}
+	s.Opts.Owner = commandOwner()
+	event := auditRecord(s, identifyCaller(s, logger), exportedLabel, audit.ModeDedupe)
+	if !dryRun {
+		result, err := authenticateEvent(authenticator, s,
+			fmt.Sprintf("merge the duplicated entries of %d key(s)", len(duplicated)), "", &event)
+		if result != auth.Success {
+			logger.Warn("Merging duplicated entries refused", "result", result, "err", err)
+			_ = recordAudit(trail, event, audit.Denied, authError{result, err}, logger)
+			return authError{result, err}
+		}
+	}
+
+	for _, label := range duplicated {
+		items := groups[label]
+
+		keygrip := ""
+		if r, err := findEntry(entries, label); err == nil {
+			keygrip = r.Keygrip
+		}
+
+		rankMatches(items, keygrip)
+
+		if !dryRun {
+			// the passphrases are only read once the merge is recorded
+			event.Label, event.Keygrip = label, keygrip
+			if err := recordAudit(trail, event, audit.Released, nil, logger); err != nil {
+				return fmt.Errorf("%s: %w", label, err)
+			}
+		}
+
+		if err := mergeItems(w, items, dryRun); err != nil {
+			return fmt.Errorf("%s: %w", label, err)
+		}
+	}
+
+	return nil
+}
+
+// mergeItems keeps the first of items and removes the others
+func mergeItems(w io.Writer, items []keychain.QueryResult, dryRun bool) error {
+	keep := items[0]
+	fmt.Fprintf(w, "%v %s: keeping the entry of account %s\n", emoji.Information, keep.Label, keep.Account)
+
+	// the newest passphrase is most likely the current one
+	newest := 0
+	for i, item := range items {
+		if item.ModificationDate.After(items[newest].ModificationDate) {
+			newest = i
+		}
+	}
+
+	if newest != 0 {
+		fmt.Fprintf(w, "   using the newer passphrase of account %s\n", items[newest].Account)
+		if !dryRun {
+			if err := copyKeychainData(items[newest], keep); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, item := range items[1:] {
+		fmt.Fprintf(w, "   removing the entry of account %s (service %s)\n", item.Account, item.Service)
+		if dryRun {
+			continue
+		}
+
+		if err := deleteKeychainItem(item); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyKeychainData replaces the password of dst with the one of src if they are different
+func copyKeychainData(src, dst keychain.QueryResult) error {
+	data, err := keychainData(src)
+	if err != nil {
+		return err
+	}
+	defer data.Wipe()
+
+	current, err := keychainData(dst)
+	if err != nil {
+		return err
+	}
+	defer current.Wipe()
+
+	if subtle.ConstantTimeCompare(data.Bytes(), current.Bytes()) == 1 {
+		return nil
+	}
+
+	query := keychain.NewItem()
+	query.SetSecClass(keychain.SecClassGenericPassword)
+	query.SetService(dst.Service)
+	query.SetAccount(dst.Account)
+	query.SetLabel(dst.Label)
+
+	update := keychain.NewItem()
+	update.SetData(data.Bytes())
+
+	return keychain.UpdateItem(query, update)
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/foxcpp/go-assuan/pinentry"
+	"github.com/jorgelbg/pinentry-touchid/audit"
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/logging"
+	"github.com/jorgelbg/pinentry-touchid/metadata"
+	"github.com/keybase/go-keychain"
+)
+
+// addKeychainItem creates a generic password without the attributes set by pinentry-touchid
+func addKeychainItem(t *testing.T, service, account, label, description, data string) {
+	t.Helper()
+
+	item := keychain.NewItem()
+	item.SetSecClass(keychain.SecClassGenericPassword)
+	item.SetService(service)
+	item.SetAccount(account)
+	item.SetLabel(label)
+	item.SetDescription(description)
+	item.SetData([]byte(data))
+
+	if err := keychain.AddItem(item); err != nil {
+		t.Fatalf("failed precreating entry in the Keychain: %s", err)
+	}
+}
+
+func TestRankMatches(t *testing.T) {
+	now := time.Now()
+	items := []keychain.QueryResult{
+		{Service: "other", Account: "KEYGRIP", ModificationDate: now},
+		{Service: keychainService, Account: "b", ModificationDate: now},
+		{Service: keychainService, Account: "a", ModificationDate: now},
+		{Service: keychainService, Account: "c", ModificationDate: now.Add(time.Hour)},
+		{Service: keychainService, Account: "d", Description: keychainDescription},
+		{Service: keychainService, Account: "KEYGRIP"},
+	}
+
+	rankMatches(items, "KEYGRIP")
+
+	var got []string
+	for _, item := range items {
+		got = append(got, item.Service+"/"+item.Account)
+	}
+
+	want := []string{"GnuPG/KEYGRIP", "other/KEYGRIP", "GnuPG/d", "GnuPG/c", "GnuPG/a", "GnuPG/b"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("ranking mismatch got: %v want: %v", got, want)
+		}
+	}
+}
+
+func TestGetPINMultipleMatches(t *testing.T) {
+	keychainLabel := `Firstname Lastname <test@email.com> (61AF059BD632F971)`
+	defer func() { _ = cleanKeychain(keychainLabel) }()
+
+	// an item created by pinentry-touchid for a different key and the one of pinentry-mac
+	addKeychainItem(t, keychainService, "OTHERKEYGRIP", keychainLabel, keychainDescription, "wrong")
+	addKeychainItem(t, keychainService, keygripFromKeyInfo(keyInfo), keychainLabel, "", testPassword)
+
+	logger := logging.New(ioutil.Discard, logging.LevelDebug, logging.FormatText)
+	params := pinentry.Settings{
+		Desc:    keyDesc,
+		KeyInfo: keyInfo,
+	}
+
+	pass, pinErr := GetPIN(auth.NewScripted(auth.Success), dummyPrompt, nil, nil, logger)(params)
+	if pinErr != nil {
+		t.Fatalf("call to GetPIN should succeed: %s", pinErr)
+	}
+
+	if string(pass) != testPassword {
+		t.Fatalf("the entry of the keygrip should be used, got: %s", pass)
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	keychainLabel := `Firstname Lastname <test@email.com> (61AF059BD632F971)`
+	defer func() { _ = cleanKeychain(keychainLabel) }()
+
+	entries := metadata.NewStore(filepath.Join(t.TempDir(), metadata.DefaultFilename), metadata.Reentry{})
+
+	addKeychainItem(t, keychainService, "KEYGRIP", keychainLabel, keychainDescription, "old")
+	time.Sleep(time.Millisecond)
+	addKeychainItem(t, keychainService, "OTHERKEYGRIP", keychainLabel, "", "new")
+
+	logger := logging.New(ioutil.Discard, logging.LevelDebug, logging.FormatText)
+	trail := audit.New(filepath.Join(t.TempDir(), audit.DefaultFilename))
+
+	// nothing is changed in a dry run, which doesn't need authentication
+	authenticator := auth.NewScripted(auth.Declined)
+	if err := dedupe(ioutil.Discard, authenticator, entries, trail, true, logger); err != nil {
+		t.Fatalf("dedupe should succeed: %s", err)
+	}
+	if matches, _ := keychainMatches(keychainLabel, ""); len(matches) != 2 || len(authenticator.Requests()) != 0 {
+		t.Fatalf("a dry run should not remove entries, found %d", len(matches))
+	}
+
+	// nothing is read without authentication
+	if err := dedupe(ioutil.Discard, authenticator, entries, trail, false, logger); err == nil {
+		t.Fatalf("dedupe should fail without authentication")
+	}
+	if matches, _ := keychainMatches(keychainLabel, ""); len(matches) != 2 {
+		t.Fatalf("entries should not be removed without authentication, found %d", len(matches))
+	}
+
+	authenticator = auth.NewScripted(auth.Success)
+	if err := dedupe(ioutil.Discard, authenticator, entries, trail, false, logger); err != nil {
+		t.Fatalf("dedupe should succeed: %s", err)
+	}
+	if len(authenticator.Requests()) != 1 {
+		t.Fatalf("the user should authenticate once, got %d requests", len(authenticator.Requests()))
+	}
+
+	records, err := trail.Records()
+	if err != nil || len(records) != 2 || records[0].Outcome != audit.Denied ||
+		records[1].Outcome != audit.Released || records[1].Label != keychainLabel || records[1].Mode != audit.ModeDedupe {
+		t.Fatalf("the refused and the merged entries should be recorded: %+v (%v)", records, err)
+	}
+
+	matches, err := keychainMatches(keychainLabel, "")
+	if err != nil || len(matches) != 1 {
+		t.Fatalf("a single entry should be left, found %d: %v", len(matches), err)
+	}
+
+	// the entry created by pinentry-touchid is kept with the newest passphrase
+	if matches[0].Account != "KEYGRIP" {
+		t.Fatalf("unexpected entry kept: %+v", matches[0])
+	}
+
+	pass, err := passwordFromKeychain(keychainLabel, "")
+	if err != nil || string(pass.Bytes()) != "new" {
+		t.Fatalf("the newest passphrase should be kept, got: %q %v", pass.Bytes(), err)
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+//
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/foxcpp/go-assuan/pinentry"
+	"github.com/jorgelbg/pinentry-touchid/config"
+	"github.com/jorgelbg/pinentry-touchid/logging"
+	"github.com/jorgelbg/pinentry-touchid/metadata"
+)
+
+// entriesFromConfig returns the store of metadata records, it lives next to the configuration
+// file
+func entriesFromConfig(cfg config.Config) *metadata.Store {
+	path := filepath.Join(filepath.Dir(config.DefaultPath()), metadata.DefaultFilename)
+
+	return metadata.NewStore(path, reentryFromConfig(cfg))
+}
+
+// keygripFromKeyInfo returns the cache ID from the x/cacheId form sent by the gpg-agent
+func keygripFromKeyInfo(keyInfo string) string {
+	if parts := strings.SplitN(keyInfo, "/", 2); len(parts) == 2 {
+		return parts[1]
+	}
+
+	return keyInfo
+}
+
+// entryRecord describes the keychain entry with the given label requested with s
+func entryRecord(s pinentry.Settings, label string) metadata.Record {
+	r := metadata.Record{
+		Keygrip:     keygripFromKeyInfo(s.KeyInfo),
+		Label:       label,
+		Description: s.Desc,
+		CreatedBy:   "pinentry-touchid " + version,
+	}
+	if r.Keygrip == "" {
+		r.Keygrip = label
+	}
+
+	// labels built by keychainLabelFromDesc end with the key ID (or SSH fingerprint)
+	if i := strings.LastIndex(label, " ("); i >= 0 && strings.HasSuffix(label, ")") {
+		r.Fingerprint = label[i+2 : len(label)-1]
+	}
+
+	return r
+}
+
+// recordCreated stores the metadata of a new entry. The metadata is informative, failing to
+// store it doesn't fail the request.
+func recordCreated(entries *metadata.Store, r metadata.Record, logger *logging.Logger) {
+	if entries == nil {
+		return
+	}
+
+	if _, err := entries.Created(r); err != nil {
+		logger.Warn("Error recording the metadata", "label", r.Label, "err", err)
+	}
+}
+
+// recordUsed updates the metadata of an entry after its PIN was released
+func recordUsed(entries *metadata.Store, r metadata.Record, logger *logging.Logger) {
+	if entries == nil {
+		return
+	}
+
+	if _, err := entries.Used(r); err != nil {
+		logger.Warn("Error recording the metadata", "label", r.Label, "err", err)
+	}
+}
+
+// recordVerified updates the metadata of an entry after its passphrase was typed again
+func recordVerified(entries *metadata.Store, r metadata.Record, logger *logging.Logger) {
+	if entries == nil {
+		return
+	}
+
+	if _, err := entries.Verified(r); err != nil {
+		logger.Warn("Error recording the metadata", "label", r.Label, "err", err)
+	}
+}
+
+// findEntry returns the record of the entry given by label or keygrip
+func findEntry(entries *metadata.Store, name string) (metadata.Record, error) {
+	records, err := entries.List()
+	if err != nil {
+		return metadata.Record{}, err
+	}
+
+	var found []metadata.Record
+	for _, r := range records {
+		if r.Keygrip == name {
+			return r, nil
+		}
+		if r.Label == name {
+			found = append(found, r)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return metadata.Record{}, fmt.Errorf("no entry found for %s", name)
+	case 1:
+		return found[0], nil
+	}
+
+	return metadata.Record{}, fmt.Errorf("%d entries are labeled %s, use the keygrip instead", len(found), name)
+}
+
+// formatTime shows t in the local time zone, zero times are unknown
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+// listEntries prints a line for every entry with metadata
+func listEntries(w io.Writer, entries *metadata.Store) error {
+	records, err := entries.List()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "LABEL\tKEYGRIP\tLAST USED\tUSES")
+	for _, r := range records {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\n", r.Label, r.Keygrip, formatTime(r.LastUsed), r.UseCount)
+	}
+
+	return tw.Flush()
+}
+
+// showEntries prints the metadata of the entries given by label or keygrip
+func showEntries(w io.Writer, entries *metadata.Store, keys []string) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("show requires the label or keygrip of an entry")
+	}
+
+	records, err := entries.List()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range keys {
+		found := false
+		for _, r := range records {
+			if r.Keygrip != name && r.Label != name {
+				continue
+			}
+
+			if found {
+				fmt.Fprintln(w)
+			}
+			found = true
+
+			tw := tabwriter.NewWriter(w, 0, 4, 1, ' ', 0)
+			fmt.Fprintf(tw, "Label:\t%s\n", r.Label)
+			fmt.Fprintf(tw, "Keygrip:\t%s\n", r.Keygrip)
+			fmt.Fprintf(tw, "Fingerprint:\t%s\n", r.Fingerprint)
+			fmt.Fprintf(tw, "Created:\t%s\n", formatTime(r.Created))
+			fmt.Fprintf(tw, "Created by:\t%s\n", r.CreatedBy)
+			fmt.Fprintf(tw, "Last used:\t%s\n", formatTime(r.LastUsed))
+			fmt.Fprintf(tw, "Uses:\t%d\n", r.UseCount)
+			fmt.Fprintf(tw, "Last typed:\t%s\n", formatTime(r.LastVerified))
+			fmt.Fprintf(tw, "Max age:\t%s\n", formatMaxAge(r.Reentry.MaxAge))
+			fmt.Fprintf(tw, "Re-enter every:\t%s\n", formatEvery(r.Reentry.Every))
+			fmt.Fprintf(tw, "Description:\t%s\n", strings.ReplaceAll(r.Description, "\n", " "))
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("no entry found for %s", name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/foxcpp/go-assuan/pinentry"
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/logging"
+	"github.com/jorgelbg/pinentry-touchid/metadata"
+)
+
+func TestGetPINRecordsMetadata(t *testing.T) {
+	keychainLabel := `Firstname Lastname <test@email.com> (61AF059BD632F971)`
+	defer func() { _ = cleanKeychain(keychainLabel) }()
+
+	logger := logging.New(ioutil.Discard, logging.LevelDebug, logging.FormatText)
+	entries := metadata.NewStore(filepath.Join(t.TempDir(), metadata.DefaultFilename), metadata.Reentry{})
+	params := pinentry.Settings{
+		Desc:    keyDesc,
+		KeyInfo: keyInfo,
+	}
+
+	typedPrompt := func(s pinentry.Settings) ([]byte, error) { return []byte(testPassword), nil }
+	fn := GetPIN(auth.NewScripted(auth.Success), typedPrompt, entries, nil, logger)
+
+	// the first call creates the entry, the second one reads it from the keychain
+	for i := 0; i < 2; i++ {
+		if _, pinErr := fn(params); pinErr != nil {
+			t.Fatalf("call to GetPIN should succeed: %s", pinErr)
+		}
+	}
+
+	r, ok, err := entries.Get("8043823CBC5C5A0C66866520F333076D")
+	if err != nil || !ok {
+		t.Fatalf("the entry should have a metadata record: %v", err)
+	}
+
+	if r.Label != keychainLabel || r.Fingerprint != "61AF059BD632F971" || r.Description != keyDesc {
+		t.Fatalf("unexpected record: %+v", r)
+	}
+
+	if r.Created.IsZero() || r.CreatedBy != "pinentry-touchid "+version || r.UseCount != 2 {
+		t.Fatalf("the creation and use of the entry should be recorded: %+v", r)
+	}
+
+	var out bytes.Buffer
+	if err := showEntries(&out, entries, []string{keychainLabel}); err != nil {
+		t.Fatalf("showing the entry should succeed: %s", err)
+	}
+
+	if !regexp.MustCompile(`Uses:\s+2\n`).MatchString(out.String()) {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+
+	if err := showEntries(&out, entries, []string{"unknown"}); err == nil {
+		t.Fatalf("showing an unknown entry should fail")
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+//
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/foxcpp/go-assuan/common"
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/tty"
+)
+
+var (
+	// errCanceled is returned when the user didn't provide a PIN
+	errCanceled = errors.New("operation canceled")
+	// errNoPinentry is returned when neither a pinentry program nor a terminal can be used to ask
+	// for the PIN
+	errNoPinentry = errors.New("no pinentry program available")
+)
+
+// authError is returned when the user couldn't be authenticated
+type authError struct {
+	result auth.Result
+	err    error
+}
+
+func (e authError) Error() string {
+	if e.err != nil {
+		return fmt.Sprintf("authentication %s: %s", e.result, e.err)
+	}
+
+	return fmt.Sprintf("authentication %s", e.result)
+}
+
+func (e authError) Unwrap() error {
+	return e.err
+}
+
+// storeError is returned when the keychain can't be read or written
+type storeError struct {
+	err error
+}
+
+func (e storeError) Error() string {
+	return fmt.Sprintf("keychain error: %s", e.err)
+}
+
+func (e storeError) Unwrap() error {
+	return e.err
+}
+
+// errorCode returns the gpg error code that describes err
+func errorCode(err error) common.ErrorCode {
+	var (
+		authErr     authError
+		storeErr    storeError
+		pinentryErr *common.Error
+	)
+
+	switch {
+	case errors.As(err, &authErr):
+		switch authErr.result {
+		case auth.Timeout:
+			return common.ErrTimeout
+		case auth.Declined, auth.Canceled:
+			return common.ErrCanceled
+		case auth.Forbidden:
+			return common.ErrForbidden
+		}
+		return common.ErrGeneral
+	case errors.As(err, &storeErr):
+		return common.ErrGeneral
+	case errors.As(err, &pinentryErr):
+		// the error returned by pinentry-mac is forwarded as is
+		return pinentryErr.Code
+	case errors.Is(err, errNoPinentry):
+		return common.ErrNoPinEntry
+	case errors.Is(err, tty.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return common.ErrTimeout
+	case errors.Is(err, errCanceled), errors.Is(err, tty.ErrCanceled), errors.Is(err, context.Canceled):
+		return common.ErrCanceled
+	}
+
+	return common.ErrGeneral
+}
+
+// assuanError converts err into the error reported to the gpg-agent
+func assuanError(err error) *common.Error {
+	return &common.Error{
+		Src:     common.ErrSrcPinentry,
+		SrcName: "pinentry",
+		Code:    errorCode(err),
+		Message: err.Error(),
+	}
+}
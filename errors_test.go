@@ -0,0 +1,84 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/foxcpp/go-assuan/common"
+	"github.com/foxcpp/go-assuan/pinentry"
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/logging"
+	"github.com/jorgelbg/pinentry-touchid/tty"
+	"github.com/keybase/go-keychain"
+)
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		code common.ErrorCode
+	}{
+		{errCanceled, common.ErrCanceled},
+		{tty.ErrCanceled, common.ErrCanceled},
+		{context.Canceled, common.ErrCanceled},
+		{authError{auth.Declined, nil}, common.ErrCanceled},
+		{authError{auth.Canceled, context.Canceled}, common.ErrCanceled},
+		{authError{auth.Forbidden, nil}, common.ErrForbidden},
+		{tty.ErrTimeout, common.ErrTimeout},
+		{context.DeadlineExceeded, common.ErrTimeout},
+		{authError{auth.Timeout, context.DeadlineExceeded}, common.ErrTimeout},
+		{fmt.Errorf("%w: pinentry-mac not found", errNoPinentry), common.ErrNoPinEntry},
+		{storeError{keychain.ErrorDuplicateItem}, common.ErrGeneral},
+		{authError{auth.Failed, errors.New("no biometrics")}, common.ErrGeneral},
+		{&common.Error{Code: common.ErrNotConfirmed}, common.ErrNotConfirmed},
+		{errors.New("unexpected"), common.ErrGeneral},
+	}
+
+	for _, tt := range tests {
+		if got := errorCode(tt.err); got != tt.code {
+			t.Fatalf("%v: code mismatch got: %d want: %d", tt.err, got, tt.code)
+		}
+	}
+
+	err := assuanError(storeError{errEmptyResults})
+	if err.Code != common.ErrGeneral || err.Message != "keychain error: no matching entry was found" {
+		t.Fatalf("store errors should be reported with a message, got: %+v", err)
+	}
+}
+
+func TestGetPINPromptErrors(t *testing.T) {
+	logger := logging.New(ioutil.Discard, logging.LevelDebug, logging.FormatText)
+	params := pinentry.Settings{
+		Desc:    keyDesc,
+		KeyInfo: keyInfo,
+	}
+
+	tests := []struct {
+		err  error
+		code common.ErrorCode
+	}{
+		{fmt.Errorf("%w: pinentry-mac not found", errNoPinentry), common.ErrNoPinEntry},
+		{tty.ErrTimeout, common.ErrTimeout},
+		{tty.ErrCanceled, common.ErrCanceled},
+		{nil, common.ErrCanceled}, // no error but an empty PIN
+	}
+
+	for _, tt := range tests {
+		promptFn := func(s pinentry.Settings) ([]byte, error) { return nil, tt.err }
+
+		pass, pinErr := GetPIN(auth.NewScripted(auth.Success), promptFn, nil, nil, logger)(params)
+		if pinErr == nil || pinErr.Code != tt.code {
+			t.Fatalf("%v: unexpected error got: %v want code: %d", tt.err, pinErr, tt.code)
+		}
+
+		if string(pass) != emptyPassword {
+			t.Fatalf("no password should be returned on error, got: %s", pass)
+		}
+	}
+}
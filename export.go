@@ -0,0 +1,158 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+//
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/enescakir/emoji"
+	"github.com/foxcpp/go-assuan/pinentry"
+	"github.com/jorgelbg/pinentry-touchid/audit"
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/backup"
+	"github.com/jorgelbg/pinentry-touchid/logging"
+	"github.com/jorgelbg/pinentry-touchid/store"
+)
+
+// backupOptions are the arguments of the export and import commands
+type backupOptions struct {
+	// backend is the source of an export or the destination of an import
+	backend   string
+	recipient string
+	dryRun    bool
+	path      string
+}
+
+// exportedLabel stands for every entry in the audit records of an export, or a merge of duplicated
+// entries, that was refused
+const exportedLabel = "*"
+
+// commandOwner describes the process running a command like the gpg-agent describes the owner of
+// a request: <pid>/<uid> <host>
+func commandOwner() string {
+	host, _ := os.Hostname()
+
+	return fmt.Sprintf("%d/%d %s", os.Getpid(), os.Getuid(), host)
+}
+
+// parseBackupArgs parses the arguments of the command name, export or import
+func parseBackupArgs(name string, args []string) (backupOptions, error) {
+	opts := backupOptions{backend: keychainBackend}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	usage := fmt.Errorf("usage: export [--from <backend>] [--recipient <key>] <file>")
+	if name == "import" {
+		fs.StringVar(&opts.backend, "to", keychainBackend, "")
+		fs.BoolVar(&opts.dryRun, "n", false, "")
+		fs.BoolVar(&opts.dryRun, "dry-run", false, "")
+		usage = fmt.Errorf("usage: import [--to <backend>] [-n] <file>")
+	} else {
+		fs.StringVar(&opts.backend, "from", keychainBackend, "")
+		fs.StringVar(&opts.recipient, "recipient", "", "")
+	}
+
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return opts, usage
+	}
+	opts.path = fs.Arg(0)
+
+	return opts, nil
+}
+
+// exportEntries saves every entry of src to an encrypted file after the user authenticates. The
+// file is encrypted to opts.recipient with gpg, or with a passphrase asked by gpg if there is no
+// recipient. Every export is recorded in the log and every exported entry in trail.
+func exportEntries(w io.Writer, authenticator auth.Authenticator, src store.Store, c backup.Cipher,
+	opts backupOptions, trail *audit.Log, logger *logging.Logger) error {
+	s := pinentry.Settings{}
+	s.Opts.Owner = commandOwner()
+
+	event := auditRecord(s, identifyCaller(s, logger), exportedLabel, audit.ModeExport)
+	result, err := authenticateEvent(authenticator, s,
+		fmt.Sprintf("export the cached passphrases to %s", opts.path), "", &event)
+	if result != auth.Success {
+		logger.Warn("Export refused", "path", opts.path, "result", result, "err", err)
+		_ = recordAudit(trail, event, audit.Denied, authError{result, err}, logger)
+		return authError{result, err}
+	}
+
+	// nothing is written until the backup is complete
+	var out bytes.Buffer
+	entries, err := backup.Export(&out, src, c)
+	if err != nil {
+		logger.Error("Export failed", "path", opts.path, "err", err)
+		_ = recordAudit(trail, event, audit.Failed, err, logger)
+		return err
+	}
+
+	// the backup is only written once every release is recorded
+	for _, e := range entries {
+		event.Label, event.Keygrip = e.Label, e.Keygrip
+		if err := recordAudit(trail, event, audit.Released, nil, logger); err != nil {
+			return err
+		}
+	}
+
+	if err := os.WriteFile(opts.path, out.Bytes(), 0600); err != nil {
+		return err
+	}
+
+	encryption := "a passphrase"
+	if opts.recipient != "" {
+		encryption = opts.recipient
+	}
+	logger.Info("Exported the cached passphrases", "entries", len(entries), "backend", opts.backend,
+		"path", opts.path, "encryption", encryption)
+
+	for _, e := range entries {
+		fmt.Fprintf(w, "%v %s\n", emoji.Information, e.Label)
+	}
+	fmt.Fprintf(w, "%v Exported %d entries to %s\n", emoji.CheckMarkButton, len(entries), opts.path)
+
+	return nil
+}
+
+// importEntries restores the entries of an encrypted file into dst, with opts.dryRun they are only
+// printed
+func importEntries(w io.Writer, dst store.Store, c backup.Cipher, opts backupOptions, logger *logging.Logger) error {
+	in, err := os.Open(opts.path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	progress := func(e store.Entry, action store.Action, err error) {
+		if err != nil {
+			fmt.Fprintf(w, "%v %s: %s\n", emoji.CrossMark, e.Label, err)
+			return
+		}
+
+		verb := "importing"
+		if action == store.Replace {
+			verb = "replacing"
+		}
+		fmt.Fprintf(w, "%v %s: %s in %s\n", emoji.Information, e.Label, verb, opts.backend)
+	}
+
+	n, err := backup.Import(in, dst, c, store.MigrateOptions{DryRun: opts.dryRun, Progress: progress})
+	if err != nil {
+		return err
+	}
+
+	if !opts.dryRun {
+		logger.Info("Imported the cached passphrases", "entries", n, "path", opts.path, "backend", opts.backend)
+		fmt.Fprintf(w, "%v Imported %d entries from %s\n", emoji.CheckMarkButton, n, opts.path)
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jorgelbg/pinentry-touchid/audit"
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/logging"
+	"github.com/jorgelbg/pinentry-touchid/secret"
+	"github.com/jorgelbg/pinentry-touchid/store"
+)
+
+// plainCipher is a backup.Cipher that doesn't encrypt anything
+type plainCipher struct{}
+
+func (plainCipher) Encrypt(w io.Writer, plaintext []byte) error {
+	_, err := w.Write(plaintext)
+	return err
+}
+
+func (plainCipher) Decrypt(r io.Reader) (*secret.Buffer, error) {
+	b, err := ioutil.ReadAll(r)
+	return secret.New(b), err
+}
+
+func TestExportImport(t *testing.T) {
+	var logs, out bytes.Buffer
+	logger := logging.New(&logs, logging.LevelDebug, logging.FormatText)
+
+	src := store.NewMemory()
+	if err := src.Put(store.Entry{Label: "label", Keygrip: "KEYGRIP"}, []byte(testPassword)); err != nil {
+		t.Fatalf("storing an entry should succeed: %s", err)
+	}
+
+	opts := backupOptions{backend: "src", path: filepath.Join(t.TempDir(), "backup.gpg")}
+	trail := audit.New(filepath.Join(t.TempDir(), audit.DefaultFilename))
+	if err := exportEntries(&out, auth.NewScripted(auth.Declined), src, plainCipher{}, opts, trail, logger); err == nil {
+		t.Fatalf("the export should fail if the user doesn't authenticate")
+	}
+	if _, err := os.Stat(opts.path); !os.IsNotExist(err) {
+		t.Fatalf("nothing should be written without authentication: %v", err)
+	}
+
+	if err := exportEntries(&out, auth.NewScripted(auth.Success), src, plainCipher{}, opts, trail, logger); err != nil {
+		t.Fatalf("the export should succeed: %s", err)
+	}
+
+	info, err := os.Stat(opts.path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("the backup should only be readable by the user: %v (%v)", info, err)
+	}
+
+	dst := store.NewMemory()
+	if err := importEntries(&out, dst, plainCipher{}, opts, logger); err != nil {
+		t.Fatalf("the import should succeed: %s", err)
+	}
+
+	pin, err := dst.Get("label", "KEYGRIP")
+	if err != nil || string(pin.Bytes()) != testPassword {
+		t.Fatalf("the entry should be restored: %q (%v)", pin.Bytes(), err)
+	}
+
+	// the refused and the successful export are recorded
+	for _, msg := range []string{"Export refused", "Exported the cached passphrases"} {
+		if n := bytes.Count(logs.Bytes(), []byte(msg)); n != 1 {
+			t.Fatalf("%q should be logged once:\n%s", msg, logs.String())
+		}
+	}
+
+	records, err := trail.Records()
+	if err != nil || len(records) != 2 {
+		t.Fatalf("the refused export and the exported entry should be audited: %+v (%v)", records, err)
+	}
+	if records[0].Outcome != audit.Denied || records[1].Outcome != audit.Released || records[1].Keygrip != "KEYGRIP" ||
+		records[1].Mode != audit.ModeExport {
+		t.Fatalf("unexpected audit records: %+v", records)
+	}
+}
+
+func TestParseBackupArgs(t *testing.T) {
+	opts, err := parseBackupArgs("export", []string{"--recipient", "me@example.com", "backup.gpg"})
+	if err != nil || opts.backend != keychainBackend || opts.recipient != "me@example.com" || opts.path != "backup.gpg" {
+		t.Fatalf("unexpected options: %+v (%v)", opts, err)
+	}
+
+	opts, err = parseBackupArgs("import", []string{"--to", "secret-service", "-n", "backup.gpg"})
+	if err != nil || opts.backend != "secret-service" || !opts.dryRun {
+		t.Fatalf("unexpected options: %+v (%v)", opts, err)
+	}
+
+	if _, err := parseBackupArgs("import", []string{"--recipient", "me", "backup.gpg"}); err == nil {
+		t.Fatalf("import doesn't take a recipient")
+	}
+}
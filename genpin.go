@@ -0,0 +1,153 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+//
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"github.com/foxcpp/go-assuan/common"
+	"github.com/foxcpp/go-assuan/pinentry"
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/config"
+	"github.com/jorgelbg/pinentry-touchid/logging"
+	"github.com/jorgelbg/pinentry-touchid/metadata"
+	"github.com/jorgelbg/pinentry-touchid/passgen"
+	"github.com/jorgelbg/pinentry-touchid/secret"
+)
+
+// generatorFromConfig returns the passphrase generator configured by the user
+func generatorFromConfig(cfg config.Config) (passgen.Generator, error) {
+	gen := passgen.Generator{
+		Charset: cfg.GenPINCharset,
+		Length:  cfg.GenPINLength,
+	}
+
+	if cfg.GenPINWordlist != "" {
+		words, err := passgen.LoadWordlist(cfg.GenPINWordlist)
+		if err != nil {
+			return passgen.Generator{}, fmt.Errorf("couldn't read wordlist: %w", err)
+		}
+
+		gen.Words = words
+	}
+
+	return gen, nil
+}
+
+// GeneratePIN answers a request for a new passphrase with a randomly generated one. After the user
+// authorizes it with Touch ID the passphrase is shown, the user types it back to confirm that it
+// was written down and it is then stored in the keychain, so it never has to be typed again. If the
+// user doesn't authorize it the passphrase is requested with promptFn. Keys that have no ID yet to
+// label the keychain entry with get a generated passphrase that isn't stored.
+func GeneratePIN(authenticator auth.Authenticator, promptFn PromptFunc, gen passgen.Generator, entries *metadata.Store, logger *logging.Logger) GetPinFunc {
+	return func(s pinentry.Settings) ([]byte, *common.Error) {
+		keychainLabel, err := keychainLabelFromDesc(s.Desc)
+		if err != nil {
+			// new keys don't have an ID yet, GetPIN couldn't find an entry stored now
+			logger.Info("The key has no ID yet, the generated passphrase won't be stored", "err", err)
+			return generatedPIN(promptFn, s, "the new key", gen, logger)
+		}
+
+		result, err := authenticate(authenticator, s,
+			fmt.Sprintf("store a generated passphrase for %s", keychainLabel), keychainLabel)
+		switch result {
+		case auth.Success:
+		case auth.Timeout, auth.Canceled, auth.Failed:
+			logger.Error("Failed to authenticate", "label", keychainLabel, "result", result, "err", err)
+			return nil, assuanError(authError{result, err})
+		default:
+			logger.Info("Passphrase generation was not authorized, asking for a passphrase", "label", keychainLabel,
+				"result", result)
+			return typedPIN(promptFn, s)
+		}
+
+		pin, pinErr := generatedPIN(promptFn, s, keychainLabel, gen, logger)
+		if pinErr != nil {
+			return nil, pinErr
+		}
+
+		// s.KeyInfo is in the form of x/cacheId when the key is known to the gpg-agent
+		keyInfo := keychainLabel
+		if parts := strings.SplitN(s.KeyInfo, "/", 2); len(parts) == 2 {
+			keyInfo = parts[1]
+		}
+
+		if err := updatePasswordInKeychain(keychainLabel, keyInfo, pin); err != nil {
+			logger.Error("Error storing the generated passphrase in the keychain", "label", keychainLabel, "err", err)
+			secret.Wipe(pin)
+			return nil, assuanError(storeError{err})
+		}
+
+		logger.Info("Generated passphrase stored in the keychain", "label", keychainLabel)
+
+		recordCreated(entries, entryRecord(s, keychainLabel), logger)
+
+		return pin, nil
+	}
+}
+
+// generatedPIN generates a passphrase for label and returns it once the user confirmed it
+func generatedPIN(promptFn PromptFunc, s pinentry.Settings, label string, gen passgen.Generator,
+	logger *logging.Logger) ([]byte, *common.Error) {
+	pin, err := gen.Generate()
+	if err != nil {
+		logger.Error("Error generating a passphrase", "label", label, "err", err)
+		return nil, assuanError(err)
+	}
+
+	if err := confirmGeneratedPIN(promptFn, s, label, pin); err != nil {
+		logger.Info("The generated passphrase wasn't confirmed", "label", label, "err", err)
+		secret.Wipe(pin)
+		return nil, assuanError(err)
+	}
+
+	logger.Info("Generated passphrase confirmed", "label", label, "bits", int(gen.Entropy()))
+
+	return pin, nil
+}
+
+// typedPIN asks the passphrase with promptFn
+func typedPIN(promptFn PromptFunc, s pinentry.Settings) ([]byte, *common.Error) {
+	pin, err := promptFn(s)
+	if err != nil {
+		return nil, assuanError(err)
+	}
+
+	return pin, nil
+}
+
+// confirmGeneratedPIN shows the generated passphrase pin of label and asks the user to type it
+// back, so it isn't used before the user wrote it down. A typo can be corrected once. The passphrase
+// is shown in the description, which is redacted from the traces.
+func confirmGeneratedPIN(promptFn PromptFunc, s pinentry.Settings, label string, pin []byte) error {
+	prompt := s
+	prompt.Desc = fmt.Sprintf("The generated passphrase of %s is:\n\n%s\n\nWrite it down and type it "+
+		"below to confirm.", label, pin)
+	prompt.SecretDesc = true
+	// a single entry is enough, the passphrase is compared with the generated one
+	prompt.RepeatPrompt, prompt.RepeatError, prompt.GenPIN = "", "", ""
+
+	for i := 0; i < 2; i++ {
+		typed, err := promptFn(prompt)
+		if err != nil {
+			return err
+		}
+
+		match := subtle.ConstantTimeCompare(typed, pin) == 1
+		secret.Wipe(typed)
+		if match {
+			return nil
+		}
+
+		prompt.Error = "The passphrase doesn't match the generated one"
+	}
+
+	return fmt.Errorf("%w: the generated passphrase wasn't typed back", errCanceled)
+}
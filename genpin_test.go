@@ -0,0 +1,159 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/foxcpp/go-assuan/pinentry"
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/logging"
+	"github.com/jorgelbg/pinentry-touchid/passgen"
+)
+
+func TestGeneratePIN(t *testing.T) {
+	keychainLabel := `Firstname Lastname <test@email.com> (61AF059BD632F971)`
+	defer func() { _ = cleanKeychain(keychainLabel) }()
+
+	logger := logging.New(ioutil.Discard, logging.LevelDebug, logging.FormatText)
+	params := pinentry.Settings{
+		Desc:         keyDesc,
+		KeyInfo:      keyInfo,
+		RepeatPrompt: "Repeat:",
+		GenPIN:       "Suggest",
+	}
+
+	// the user types back the passphrase shown in the prompt, after a typo
+	var prompts []pinentry.Settings
+	typeBack := func(s pinentry.Settings) ([]byte, error) {
+		prompts = append(prompts, s)
+		if len(prompts) == 1 {
+			return []byte("typo"), nil
+		}
+		return []byte(shownPassphrase(t, s.Desc)), nil
+	}
+
+	fn := GeneratePIN(auth.NewScripted(auth.Success), typeBack, passgen.Generator{}, nil, logger)
+	pass, pinErr := fn(params)
+	if pinErr != nil {
+		t.Fatalf("call to GeneratePIN should succeed: %s", pinErr)
+	}
+
+	if len(pass) == 0 || string(pass) != shownPassphrase(t, prompts[0].Desc) {
+		t.Fatalf("the generated passphrase should have been shown")
+	}
+
+	if len(prompts) != 2 || prompts[1].Error == "" || prompts[0].RepeatPrompt != "" {
+		t.Fatalf("the passphrase should be typed back once more after a typo: %+v", prompts)
+	}
+
+	stored, err := passwordFromKeychain(keychainLabel, "")
+	if err != nil {
+		t.Fatalf("the generated passphrase should be stored in the keychain: %s", err)
+	}
+
+	if string(stored.Bytes()) != string(pass) {
+		t.Fatalf("password mismatch got: %s want: %s", stored.Bytes(), pass)
+	}
+}
+
+func TestGeneratePINNotAuthorized(t *testing.T) {
+	keychainLabel := `Firstname Lastname <test@email.com> (61AF059BD632F971)`
+	defer func() { _ = cleanKeychain(keychainLabel) }()
+
+	logger := logging.New(ioutil.Discard, logging.LevelDebug, logging.FormatText)
+	params := pinentry.Settings{
+		Desc:         keyDesc,
+		KeyInfo:      keyInfo,
+		RepeatPrompt: "Repeat:",
+		GenPIN:       "Suggest",
+	}
+
+	fallBack := false
+	validPinFn := func(s pinentry.Settings) ([]byte, error) {
+		fallBack = true
+		return []byte(testPassword), nil
+	}
+
+	fn := GeneratePIN(auth.NewScripted(auth.Declined), validPinFn, passgen.Generator{}, nil, logger)
+	pass, pinErr := fn(params)
+	if pinErr != nil {
+		t.Fatalf("call to GeneratePIN should succeed: %s", pinErr)
+	}
+
+	if !fallBack || string(pass) != testPassword {
+		t.Fatalf("the fallback password prompt should have been used")
+	}
+
+	if exists, _ := checkEntryInKeychain(keychainLabel); exists {
+		t.Fatalf("nothing should be stored in the keychain without authorization")
+	}
+}
+
+// shownPassphrase returns the generated passphrase in the description of the confirmation prompt
+func shownPassphrase(t *testing.T, desc string) string {
+	t.Helper()
+
+	parts := strings.Split(desc, "\n\n")
+	if len(parts) != 3 {
+		t.Fatalf("the prompt should show the generated passphrase: %q", desc)
+	}
+
+	return parts[1]
+}
+
+func TestGeneratePINNotConfirmed(t *testing.T) {
+	keychainLabel := `Firstname Lastname <test@email.com> (61AF059BD632F971)`
+	defer func() { _ = cleanKeychain(keychainLabel) }()
+
+	logger := logging.New(ioutil.Discard, logging.LevelDebug, logging.FormatText)
+	params := pinentry.Settings{
+		Desc:         keyDesc,
+		KeyInfo:      keyInfo,
+		RepeatPrompt: "Repeat:",
+		GenPIN:       "Suggest",
+	}
+
+	fn := GeneratePIN(auth.NewScripted(auth.Success), dummyPrompt, passgen.Generator{}, nil, logger)
+	if _, pinErr := fn(params); pinErr == nil {
+		t.Fatalf("a passphrase that isn't typed back should not be used")
+	}
+
+	if exists, _ := checkEntryInKeychain(keychainLabel); exists {
+		t.Fatalf("nothing should be stored in the keychain without confirmation")
+	}
+}
+
+func TestGeneratePINWithoutKeyID(t *testing.T) {
+	logger := logging.New(ioutil.Discard, logging.LevelDebug, logging.FormatText)
+	params := pinentry.Settings{
+		Desc:         "Please enter a passphrase to protect the new key",
+		KeyInfo:      keyInfo,
+		RepeatPrompt: "Repeat:",
+		GenPIN:       "Suggest",
+	}
+
+	var shown string
+	authenticator := auth.NewScripted(auth.Success)
+	fn := GeneratePIN(authenticator, func(s pinentry.Settings) ([]byte, error) {
+		shown = shownPassphrase(t, s.Desc)
+		return []byte(shown), nil
+	}, passgen.Generator{}, nil, logger)
+
+	pass, pinErr := fn(params)
+	if pinErr != nil {
+		t.Fatalf("call to GeneratePIN should succeed: %s", pinErr)
+	}
+
+	if len(pass) == 0 || string(pass) != shown {
+		t.Fatalf("the generated passphrase should have been shown and confirmed")
+	}
+
+	if len(authenticator.Requests()) != 0 {
+		t.Fatalf("nothing is stored for a key without an ID, no authentication is needed")
+	}
+}
@@ -19,6 +19,12 @@ import (
 type Session struct {
 	Pipe    io.ReadWriteCloser
 	Scanner *bufio.Scanner
+	// Greeting sent by server together with first OK.
+	Greeting string
+	// Status, if not nil, is called with status information (S lines) sent
+	// by server while command is processed, e.g. PROGRESS or
+	// PASSWORD_FROM_CACHE. Status information is discarded otherwise.
+	Status func(keyword string, args string)
 }
 
 // ReadWriteCloser - a bit of glue between io.ReadCloser and io.WriteCloser.
@@ -48,11 +54,12 @@ func (clsr nopCloser) Close() error {
 
 // InitNopClose initiates session using passed Reader/Writer and NOP closer.
 func InitNopClose(pipe io.ReadWriter) (*Session, error) {
-	ses := &Session{nopCloser{pipe}, bufio.NewScanner(pipe)}
+	ses := &Session{Pipe: nopCloser{pipe}, Scanner: bufio.NewScanner(pipe)}
 	ses.Scanner.Buffer(make([]byte, common.MaxLineLen), common.MaxLineLen)
 
 	// Take server's OK from pipe.
-	_, _, err := common.ReadLine(ses.Scanner)
+	var err error
+	_, ses.Greeting, err = common.ReadLine(ses.Scanner)
 	if err != nil {
 		Logger.Println("... I/O error:", err)
 		return nil, err
@@ -64,11 +71,12 @@ func InitNopClose(pipe io.ReadWriter) (*Session, error) {
 // Init initiates session using passed Reader/Writer.
 func Init(pipe io.ReadWriteCloser) (*Session, error) {
 	Logger.Println("Starting session...")
-	ses := &Session{pipe, bufio.NewScanner(pipe)}
+	ses := &Session{Pipe: pipe, Scanner: bufio.NewScanner(pipe)}
 	ses.Scanner.Buffer(make([]byte, common.MaxLineLen), common.MaxLineLen)
 
 	// Take server's OK from pipe.
-	_, _, err := common.ReadLine(ses.Scanner)
+	var err error
+	_, ses.Greeting, err = common.ReadLine(ses.Scanner)
 	if err != nil {
 		Logger.Println("... I/O error:", err)
 		return nil, err
@@ -80,6 +88,12 @@ func Init(pipe io.ReadWriteCloser) (*Session, error) {
 // InitCmd initiates session using command's stdin and stdout as a I/O channel.
 // cmd.Start() will be done by this function and should not be done before.
 func InitCmd(cmd *exec.Cmd) (*Session, error) {
+	return InitCmdTraced(cmd, nil)
+}
+
+// InitCmdTraced is same as InitCmd but passes every line exchanged with
+// command to tracer (see common.TracePipe), tracer may be nil.
+func InitCmdTraced(cmd *exec.Cmd, tracer common.Tracer) (*Session, error) {
 	// Errors generally should not happen here but let's be pedantic because we are library.
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -95,7 +109,19 @@ func InitCmd(cmd *exec.Cmd) (*Session, error) {
 		return nil, err
 	}
 
-	return Init(ReadWriteCloser{stdout, stdin})
+	pipe := common.TracePipe(ReadWriteCloser{stdout, stdin}, tracer)
+	return Init(pipe.(io.ReadWriteCloser))
+}
+
+// readLine reads server's response, passing status information to Status.
+func (ses *Session) readLine() (cmd string, params string, err error) {
+	return common.ReadLineStatus(ses.Scanner, ses.Status)
+}
+
+// readLineData is readLine that reads data (e.g. a PIN) as bytes, see
+// common.ReadLineData.
+func (ses *Session) readLineData() (cmd string, params string, data []byte, err error) {
+	return common.ReadLineData(ses.Scanner, ses.Status)
 }
 
 // Close sends BYE and closes underlying pipe.
@@ -119,7 +145,7 @@ func (ses *Session) Reset() error {
 		return err
 	}
 	// Take server's OK from pipe.
-	ok, params, err := common.ReadLine(ses.Scanner)
+	ok, params, err := ses.readLine()
 	if err != nil {
 		Logger.Println("... I/O error:", err)
 		return err
@@ -135,6 +161,9 @@ func (ses *Session) Reset() error {
 }
 
 // SimpleCmd sends command with specified parameters and reads data sent by server if any.
+//
+// Data is read without intermediate copies (see common.AppendData), so the
+// caller can wipe it if it's a password.
 func (ses *Session) SimpleCmd(cmd string, params string) (data []byte, err error) {
 	Logger.Println("Sending command:", cmd, params)
 	err = common.WriteLine(ses.Pipe, cmd, params)
@@ -144,9 +173,10 @@ func (ses *Session) SimpleCmd(cmd string, params string) (data []byte, err error
 	}
 
 	for {
-		scmd, sparams, err := common.ReadLine(ses.Scanner)
+		scmd, sparams, chunk, err := ses.readLineData()
 		if err != nil {
 			Logger.Println("... I/O error:", err)
+			common.Wipe(data)
 			return []byte{}, err
 		}
 
@@ -155,10 +185,11 @@ func (ses *Session) SimpleCmd(cmd string, params string) (data []byte, err error
 		}
 		if scmd == "ERR" {
 			Logger.Println("... Received ERR: ", sparams)
+			common.Wipe(data)
 			return []byte{}, common.DecodeErrCmd(sparams)
 		}
 		if scmd == "D" {
-			data = append(data, []byte(sparams)...)
+			data = common.AppendData(data, chunk)
 		}
 	}
 }
@@ -174,12 +205,15 @@ func (ses *Session) Transact(cmd string, params string, data map[string]interfac
 	}
 
 	for {
-		scmd, sparams, err := common.ReadLine(ses.Scanner)
+		scmd, sparams, chunk, err := ses.readLineData()
 		if err != nil {
+			common.Wipe(rdata)
 			return []byte{}, err
 		}
 
 		if scmd == "INQUIRE" {
+			// INQUIRE QUALITY carries a password, only its keyword is looked up.
+			common.Wipe(chunk)
 			inquireResp, prs := data[sparams]
 			if !prs {
 				Logger.Println("... unknown request:", sparams)
@@ -218,11 +252,12 @@ func (ses *Session) Transact(cmd string, params string, data map[string]interfac
 		}
 		if scmd == "ERR" {
 			Logger.Println("... Received ERR: ", sparams)
+			common.Wipe(rdata)
 			return []byte{}, common.DecodeErrCmd(sparams)
 		}
 		if scmd == "D" {
 			Logger.Println("... Received data chunk")
-			rdata = append(rdata, []byte(sparams)...)
+			rdata = common.AppendData(rdata, chunk)
 		}
 	}
 }
@@ -236,7 +271,7 @@ func (ses *Session) Option(name string, value string) error {
 		return err
 	}
 
-	cmd, sparams, err := common.ReadLine(ses.Scanner)
+	cmd, sparams, err := ses.readLine()
 	if err != nil {
 		Logger.Println("... I/O error: ", err)
 		return err
@@ -1,10 +1,14 @@
 package client_test
 
 import (
+	"bytes"
 	"fmt"
 	"net"
+	"strings"
+	"testing"
 
 	assuan "github.com/foxcpp/go-assuan/client"
+	"github.com/foxcpp/go-assuan/common"
 )
 
 func ExampleSession() {
@@ -19,8 +23,54 @@ func ExampleSession() {
 	// data []byte = "info:1:1%0Apub:2499BEB8B47B0235009A5F0AEE8384B0561A25AF:..."
 
 	// More complex transaction: send key to keyserver.
-	ses.Transact("KS_PUT", "", map[string][]byte{
-		"KEYBLOCK":      {},
-		"KEYBLOCK_INFO": {},
+	ses.Transact("KS_PUT", "", map[string]interface{}{
+		"KEYBLOCK":      []byte{},
+		"KEYBLOCK_INFO": []byte{},
 	})
 }
+
+func TestSessionStatus(t *testing.T) {
+	// Responses of server to greeting, SimpleCmd and Transact.
+	input := "OK hello\n" +
+		"S PROGRESS tick ? 1 2\nD data\nS PROGRESS tick ? 2 2\nOK\n" +
+		"S NEED_PASSPHRASE\nINQUIRE PASSPHRASE\nS PINENTRY_LAUNCHED 123\nOK\n"
+
+	var out bytes.Buffer
+	ses, err := assuan.InitNopClose(common.ReadWriter{Reader: strings.NewReader(input), Writer: &out})
+	if err != nil {
+		t.Fatal("Init failed:", err)
+	}
+
+	var statuses []string
+	ses.Status = func(keyword string, args string) {
+		statuses = append(statuses, keyword+"|"+args)
+	}
+
+	data, err := ses.SimpleCmd("GETINFO", "progress")
+	if err != nil || string(data) != "data" {
+		t.Fatalf("Unexpected SimpleCmd result: %q (%v)", data, err)
+	}
+	if _, err := ses.Transact("GET_PASSPHRASE", "", map[string]interface{}{"PASSPHRASE": []byte("x")}); err != nil {
+		t.Fatal("Transact failed:", err)
+	}
+
+	want := []string{"PROGRESS|tick ? 1 2", "PROGRESS|tick ? 2 2", "NEED_PASSPHRASE|", "PINENTRY_LAUNCHED|123"}
+	if strings.Join(statuses, ",") != strings.Join(want, ",") {
+		t.Fatalf("Unexpected statuses: %q", statuses)
+	}
+}
+
+func TestSimpleCmdData(t *testing.T) {
+	input := "OK hello\nD pass%25\nD word\nOK\n"
+
+	var out bytes.Buffer
+	ses, err := assuan.InitNopClose(common.ReadWriter{Reader: strings.NewReader(input), Writer: &out})
+	if err != nil {
+		t.Fatal("Init failed:", err)
+	}
+
+	data, err := ses.SimpleCmd("GETPIN", "")
+	if err != nil || string(data) != "pass%word" {
+		t.Fatalf("Unexpected SimpleCmd result: %q (%v)", data, err)
+	}
+}
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"errors"
 	"net/url"
 	"strings"
 )
@@ -18,6 +19,18 @@ func escapeParameters(raw string) string {
 	return escaper.Replace(raw)
 }
 
+const hexDigits = "0123456789ABCDEF"
+
+// appendEscaped appends c to dst, percent-encoded if needed. It is the
+// []byte counterpart of escapeParameters.
+func appendEscaped(dst []byte, c byte) []byte {
+	switch c {
+	case '\r', '\n', '%', '\\':
+		return append(dst, '%', hexDigits[c>>4], hexDigits[c&0xF])
+	}
+	return append(dst, c)
+}
+
 /*
 Reverse of escapeParameters function.
 
@@ -31,3 +44,40 @@ func unescapeParameters(encoded string) (string, error) {
 	// path part of URL.
 	return url.PathUnescape(encoded)
 }
+
+// unescapeData is the []byte counterpart of unescapeParameters. The result is
+// allocated with its final capacity, so no partial copies of data are left
+// behind, and wiped if encoded is invalid.
+func unescapeData(encoded []byte) ([]byte, error) {
+	data := make([]byte, 0, len(encoded))
+	for i := 0; i < len(encoded); i++ {
+		c := encoded[i]
+		if c == '%' {
+			hi, lo := -1, -1
+			if i+2 < len(encoded) {
+				hi, lo = unhex(encoded[i+1]), unhex(encoded[i+2])
+			}
+			if hi < 0 || lo < 0 {
+				Wipe(data[:cap(data)])
+				return nil, errors.New("invalid escape sequence")
+			}
+			c = byte(hi<<4 | lo)
+			i += 2
+		}
+		data = append(data, c)
+	}
+	return data, nil
+}
+
+// unhex returns the value of hexadecimal digit c, -1 if c is not one.
+func unhex(c byte) int {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c - '0')
+	case 'a' <= c && c <= 'f':
+		return int(c - 'a' + 10)
+	case 'A' <= c && c <= 'F':
+		return int(c - 'A' + 10)
+	}
+	return -1
+}
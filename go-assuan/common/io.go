@@ -22,28 +22,109 @@ type ReadWriter struct {
 	io.Writer
 }
 
+// ReadRawLine reads a single line exactly as it was sent by the peer. Comments, status
+// information and empty lines are returned too and the contents are not unescaped.
+func ReadRawLine(scanner *bufio.Scanner) (string, error) {
+	if ok := scanner.Scan(); !ok {
+		err := scanner.Err()
+		if err == nil {
+			err = io.EOF
+		}
+		return "", err
+	}
+	return scanner.Text(), nil
+}
+
 // ReadLine reads raw request/response in following format: command <parameters>
 //
 // Empty lines and lines starting with # are ignored as specified by protocol.
-// Additionally, status information is silently discarded for now.
+// Additionally, status information is silently discarded, use ReadLineStatus
+// to receive it.
 func ReadLine(scanner *bufio.Scanner) (cmd string, params string, err error) {
+	return ReadLineStatus(scanner, nil)
+}
+
+// ReadLineStatus is same as ReadLine but passes status information (S lines)
+// to status instead of discarding it. status may be nil.
+func ReadLineStatus(scanner *bufio.Scanner, status func(keyword string, args string)) (cmd string, params string, err error) {
+	cmd, params, _, err = readLine(scanner, status, false)
+	return cmd, params, err
+}
+
+// ReadLineData is same as ReadLineStatus but the lines that may carry a
+// password, D lines and INQUIRE QUALITY, are unescaped into data instead of
+// params, without an intermediate string: params of INQUIRE QUALITY is just
+// "QUALITY". The line is wiped from the buffer of scanner, so data is the
+// only copy left and can be wiped by the caller.
+func ReadLineData(scanner *bufio.Scanner, status func(keyword string, args string)) (cmd string, params string, data []byte, err error) {
+	return readLine(scanner, status, true)
+}
+
+// inquireQuality starts the line of an inquiry that carries a password
+const inquireQuality = "INQUIRE QUALITY "
+
+func readLine(scanner *bufio.Scanner, status func(keyword string, args string), rawData bool) (cmd string, params string, data []byte, err error) {
 	var line string
 	for {
 		if ok := scanner.Scan(); !ok {
-			err := scanner.Err()
+			err = scanner.Err()
 			if err == nil {
 				err = io.EOF
 			}
-			return "", "", err
+			return "", "", nil, err
+		}
+
+		raw := scanner.Bytes()
+		if rawData && len(raw) >= 2 && (raw[0] == 'D' || raw[0] == 'd') && raw[1] == ' ' {
+			Logger.Println("<", "D")
+			data, err = unescapeData(raw[2:])
+			Wipe(raw)
+			return "D", "", data, err
+		}
+		if rawData && len(raw) > len(inquireQuality) && bytes.EqualFold(raw[:len(inquireQuality)], []byte(inquireQuality)) {
+			Logger.Println("<", "INQUIRE")
+			data, err = unescapeData(raw[len(inquireQuality):])
+			Wipe(raw)
+			return "INQUIRE", "QUALITY", data, err
+		}
+		line = string(raw)
+
+		if strings.HasPrefix(line, "S ") {
+			if status == nil {
+				continue
+			}
+
+			_, params, err := ParseLine(line)
+			if err != nil {
+				return "", "", nil, err
+			}
+			status(ParseStatus(params))
+			continue
 		}
-		line = scanner.Text()
 
 		// We got something that looks like a message. Let's parse it.
-		if !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, "S ") && len(strings.TrimSpace(line)) != 0 {
+		if !strings.HasPrefix(line, "#") && len(strings.TrimSpace(line)) != 0 {
 			break
 		}
 	}
 
+	cmd, params, err = ParseLine(line)
+	return cmd, params, nil, err
+}
+
+// ParseStatus splits unescaped parameters of S line into keyword and
+// arguments, e.g. "PROGRESS tick ? 1 10" is split into "PROGRESS" and
+// "tick ? 1 10".
+func ParseStatus(params string) (keyword string, args string) {
+	parts := strings.SplitN(params, " ", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// ParseLine splits raw line into command and unescaped parameters.
+func ParseLine(line string) (cmd string, params string, err error) {
 	// Part before first whitespace is a command. Everything after first whitespace is parameters.
 	parts := strings.SplitN(line, " ", 2)
 
@@ -87,25 +168,64 @@ func WriteLine(pipe io.Writer, cmd string, params string) error {
 	return err
 }
 
-func min(a, b int) int {
-	if a <= b {
-		return a
+// WriteLineData is same as WriteLine but data is appended to params after
+// a space. Data is escaped directly into a line buffer which is zeroed
+// before returning, so no copies of data (e.g. a password) are left behind.
+func WriteLineData(pipe io.Writer, cmd string, params string, data []byte) error {
+	line := make([]byte, 0, MaxLineLen)
+	defer Wipe(line[:cap(line)])
+
+	line = append(line, strings.ToUpper(cmd)...)
+	line = append(line, ' ')
+	line = append(line, escapeParameters(params)...)
+	line = append(line, ' ')
+	for _, c := range data {
+		// Escaped byte takes up to 3 bytes, 1 is for line feed.
+		if len(line)+3+1 > MaxLineLen {
+			Logger.Println("Refusing to send too long command")
+			return errors.New("too long command or parameters")
+		}
+		line = appendEscaped(line, c)
+	}
+	line = append(line, '\n')
+
+	Logger.Println(">", cmd)
+
+	_, err := pipe.Write(line)
+	return err
+}
+
+// WriteRawLine writes line to underlying pipe as is, without any escaping.
+func WriteRawLine(pipe io.Writer, line string) error {
+	if len(line)+1 > MaxLineLen {
+		Logger.Println("Refusing to send too long line")
+		// 1 is for LF
+		return errors.New("too long line")
 	}
-	return b
+
+	_, err := io.WriteString(pipe, line+"\n")
+	return err
 }
 
 // WriteData sends passed byte slice using one or more D commands.
 // Note: Error may occur even after some data is written so it's better
 // to just CAN transaction after WriteData error.
+//
+// Data is escaped directly into a line buffer which is zeroed before
+// returning, so no copies of input (e.g. a password) are left behind.
 func WriteData(pipe io.Writer, input []byte) error {
-	encoded := []byte(escapeParameters(string(input)))
-	chunkLen := MaxLineLen - 3 // 3 is for 'D ' and line feed.
-	for i := 0; i < len(encoded); i += chunkLen {
-		chunk := encoded[i:min(i+chunkLen, len(encoded))]
-		chunk = append([]byte{'D', ' '}, chunk...)
-		chunk = append(chunk, '\n')
-
-		if _, err := pipe.Write(chunk); err != nil {
+	line := make([]byte, 0, MaxLineLen)
+	defer Wipe(line[:cap(line)])
+
+	for i := 0; i < len(input); {
+		line = append(line[:0], 'D', ' ')
+		// Escaped byte takes up to 3 bytes, 1 is for line feed.
+		for ; i < len(input) && len(line)+3+1 <= MaxLineLen; i++ {
+			line = appendEscaped(line, input[i])
+		}
+		line = append(line, '\n')
+
+		if _, err := pipe.Write(line); err != nil {
 			return err
 		}
 	}
@@ -115,8 +235,8 @@ func WriteData(pipe io.Writer, input []byte) error {
 // WriteDataReader is similar to WriteData but sends data from input Reader
 // until EOF.
 func WriteDataReader(pipe io.Writer, input io.Reader) error {
-	chunkLen := MaxLineLen - 3 // 3 is for 'D ' and line feed.
-	buf := make([]byte, chunkLen)
+	buf := make([]byte, MaxLineLen)
+	defer Wipe(buf)
 
 	for {
 		n, err := input.Read(buf)
@@ -127,20 +247,27 @@ func WriteDataReader(pipe io.Writer, input io.Reader) error {
 			return err
 		}
 
-		chunk := []byte(escapeParameters(string(buf[:n])))
-		chunk = append([]byte{'D', ' '}, chunk...)
-		chunk = append(chunk, '\n')
-		if _, err := pipe.Write(chunk); err != nil {
+		if err := WriteData(pipe, buf[:n]); err != nil {
 			return err
 		}
 	}
 }
 
-// ReadData reads sequence of D commands and joins data together.
+// Wipe overwrites b with zeros, use it to clear passwords once they
+// are not needed anymore.
+func Wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// ReadData reads sequence of D commands and joins data together, see
+// AppendData.
 func ReadData(scanner *bufio.Scanner) (data []byte, err error) {
 	for {
-		cmd, chunk, err := ReadLine(scanner)
+		cmd, _, chunk, err := ReadLineData(scanner, nil)
 		if err != nil {
+			Wipe(data)
 			return nil, err
 		}
 
@@ -149,20 +276,35 @@ func ReadData(scanner *bufio.Scanner) (data []byte, err error) {
 		}
 
 		if cmd == "CAN" {
+			Wipe(data)
 			return nil, Error{ErrSrcAssuan, ErrUnexpected, "assuan", "IPC call has been cancelled"}
 		}
 
 		if cmd != "D" {
+			Wipe(data)
+			Wipe(chunk)
 			return nil, Error{ErrSrcAssuan, ErrUnexpected, "assuan", "unexpected IPC command"}
 		}
 
-		unescaped, err := unescapeParameters(chunk)
-		if err != nil {
-			return nil, err
-		}
+		// ReadLineData already unescaped the chunk.
+		data = AppendData(data, chunk)
+	}
+}
 
-		data = append(data, []byte(unescaped)...)
+// AppendData appends chunk to data and wipes chunk. If data has to grow, it
+// is copied to a new array and the old one is wiped, so no partial copies of
+// data (e.g. a password) are left behind.
+func AppendData(data []byte, chunk []byte) []byte {
+	if len(data)+len(chunk) > cap(data) {
+		grown := make([]byte, len(data), 2*len(data)+len(chunk))
+		copy(grown, data)
+		Wipe(data)
+		data = grown
 	}
+
+	data = append(data, chunk...)
+	Wipe(chunk)
+	return data
 }
 
 // WriteComment is special case of WriteLine. "Command" is # and text is parameter.
@@ -170,6 +312,23 @@ func WriteComment(pipe io.Writer, text string) error {
 	return WriteLine(pipe, "#", text)
 }
 
+// WriteStatus sends status information to the peer, e.g.
+//
+//	S PASSWORD_FROM_CACHE
+//
+// Keyword must not be empty or contain whitespace, args are escaped like
+// parameters of WriteLine.
+func WriteStatus(pipe io.Writer, keyword string, args string) error {
+	if len(keyword) == 0 || strings.ContainsAny(keyword, " \t\r\n") {
+		return errors.New("invalid status keyword")
+	}
+
+	if len(args) != 0 {
+		return WriteLine(pipe, "S", keyword+" "+args)
+	}
+	return WriteLine(pipe, "S", keyword)
+}
+
 func WriteError(pipe io.Writer, err Error) error {
 	return WriteLine(pipe, "ERR", fmt.Sprintf("%d %s <%s>", MakeErrCode(err.Src, err.Code), err.Message, err.SrcName))
 }
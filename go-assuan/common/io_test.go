@@ -0,0 +1,173 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteData(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteData(&out, []byte("a%b\\c\r\nd")); err != nil {
+		t.Fatal("WriteData failed:", err)
+	}
+
+	if out.String() != "D a%25b%5Cc%0D%0Ad\n" {
+		t.Fatalf("Unexpected output: %q", out.String())
+	}
+}
+
+func TestWriteDataLongInput(t *testing.T) {
+	// Escaped bytes should never be split between two lines.
+	input := []byte(strings.Repeat("x%", MaxLineLen))
+
+	var out bytes.Buffer
+	if err := WriteData(&out, input); err != nil {
+		t.Fatal("WriteData failed:", err)
+	}
+	out.WriteString("END\n")
+
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		if len(line)+1 > MaxLineLen {
+			t.Fatalf("Line is too long: %d", len(line))
+		}
+	}
+
+	data, err := ReadData(bufio.NewScanner(&out))
+	if err != nil {
+		t.Fatal("ReadData failed:", err)
+	}
+
+	if !bytes.Equal(data, input) {
+		t.Fatal("Data doesn't survive round trip")
+	}
+}
+
+func TestWipe(t *testing.T) {
+	b := []byte("secret")
+	Wipe(b)
+
+	if !bytes.Equal(b, make([]byte, 6)) {
+		t.Fatalf("Wipe left data behind: %q", b)
+	}
+}
+
+func TestReadLineStatus(t *testing.T) {
+	input := "S PROGRESS tick ? 1 10\n# comment\nS PASSWORD_FROM_CACHE\nS INFO 100%25\nD data\n"
+
+	var statuses []string
+	status := func(keyword string, args string) {
+		statuses = append(statuses, keyword+"|"+args)
+	}
+
+	cmd, params, err := ReadLineStatus(bufio.NewScanner(strings.NewReader(input)), status)
+	if err != nil {
+		t.Fatal("ReadLineStatus failed:", err)
+	}
+	if cmd != "D" || params != "data" {
+		t.Fatalf("Unexpected line: %q %q", cmd, params)
+	}
+
+	want := []string{"PROGRESS|tick ? 1 10", "PASSWORD_FROM_CACHE|", "INFO|100%"}
+	if strings.Join(statuses, ",") != strings.Join(want, ",") {
+		t.Fatalf("Unexpected statuses: %q", statuses)
+	}
+
+	// Without callback status information is discarded.
+	cmd, _, err = ReadLine(bufio.NewScanner(strings.NewReader(input)))
+	if err != nil || cmd != "D" {
+		t.Fatalf("Unexpected line: %q (%v)", cmd, err)
+	}
+}
+
+func TestReadLineData(t *testing.T) {
+	input := "S PROGRESS tick\nD pass%25word%0A\nOK\ninquire QUALITY pass%25\nD bad%2\n"
+	scanner := bufio.NewScanner(strings.NewReader(input))
+
+	var statuses []string
+	status := func(keyword string, args string) {
+		statuses = append(statuses, keyword)
+	}
+
+	cmd, params, data, err := ReadLineData(scanner, status)
+	if err != nil {
+		t.Fatal("ReadLineData failed:", err)
+	}
+	if cmd != "D" || params != "" || string(data) != "pass%word\n" || len(statuses) != 1 {
+		t.Fatalf("Unexpected line: %q %q %q %q", cmd, params, data, statuses)
+	}
+
+	// The line is not left in the buffer of the scanner.
+	if bytes.Contains(scanner.Bytes(), []byte("pass")) {
+		t.Fatalf("The line should be wiped: %q", scanner.Bytes())
+	}
+
+	if cmd, _, data, err = ReadLineData(scanner, status); err != nil || cmd != "OK" || data != nil {
+		t.Fatalf("Unexpected line: %q %q (%v)", cmd, data, err)
+	}
+
+	cmd, params, data, err = ReadLineData(scanner, status)
+	if err != nil || cmd != "INQUIRE" || params != "QUALITY" || string(data) != "pass%" {
+		t.Fatalf("Unexpected line: %q %q %q (%v)", cmd, params, data, err)
+	}
+	if bytes.Contains(scanner.Bytes(), []byte("pass")) {
+		t.Fatalf("The line should be wiped: %q", scanner.Bytes())
+	}
+
+	if _, _, _, err = ReadLineData(scanner, status); err == nil {
+		t.Fatal("An invalid escape sequence should fail")
+	}
+}
+
+func TestWriteLineData(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteLineData(&out, "inquire", "QUALITY", []byte("pass%word\n")); err != nil {
+		t.Fatal("WriteLineData failed:", err)
+	}
+
+	if out.String() != "INQUIRE QUALITY pass%25word%0A\n" {
+		t.Fatalf("Unexpected output: %q", out.String())
+	}
+
+	if err := WriteLineData(&out, "INQUIRE", "QUALITY", bytes.Repeat([]byte("x"), MaxLineLen)); err == nil {
+		t.Fatal("A too long line should be refused")
+	}
+}
+
+func TestAppendData(t *testing.T) {
+	data := make([]byte, 0, 4)
+	data = AppendData(data, []byte("pa"))
+	old := data[:cap(data)]
+
+	chunk := []byte("ssword")
+	data = AppendData(data, chunk)
+	if string(data) != "password" {
+		t.Fatalf("Unexpected data: %q", data)
+	}
+
+	// The chunk and the outgrown array are wiped.
+	if !bytes.Equal(chunk, make([]byte, len(chunk))) || !bytes.Equal(old, make([]byte, len(old))) {
+		t.Fatalf("Copies should be wiped: %q %q", chunk, old)
+	}
+}
+
+func TestWriteStatus(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteStatus(&out, "PROGRESS", "tick ? 1 10"); err != nil {
+		t.Fatal("WriteStatus failed:", err)
+	}
+	if err := WriteStatus(&out, "PASSWORD_FROM_CACHE", ""); err != nil {
+		t.Fatal("WriteStatus failed:", err)
+	}
+
+	if out.String() != "S PROGRESS tick ? 1 10\nS PASSWORD_FROM_CACHE\n" {
+		t.Fatalf("Unexpected output: %q", out.String())
+	}
+
+	for _, keyword := range []string{"", "TWO WORDS", "LINE\nFEED"} {
+		if err := WriteStatus(&out, keyword, ""); err == nil {
+			t.Fatalf("Keyword %q should be rejected", keyword)
+		}
+	}
+}
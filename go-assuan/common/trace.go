@@ -0,0 +1,163 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Direction of a traced line.
+type Direction string
+
+const (
+	// Received lines are read from the peer.
+	Received Direction = "<"
+	// Sent lines are written to the peer.
+	Sent Direction = ">"
+)
+
+// Tracer receives every line exchanged in a session, see TracePipe.
+// Lines are already redacted (see Redact) and have no trailing LF.
+type Tracer interface {
+	TraceLine(dir Direction, line string)
+}
+
+// writerTracer writes timestamped lines tagged with session ID.
+type writerTracer struct {
+	mu      sync.Mutex
+	w       io.Writer
+	session string
+	now     func() time.Time
+}
+
+// NewWriterTracer returns Tracer that writes each line to w as
+//
+//	2006-01-02T15:04:05.000Z07:00 <session> < LINE
+func NewWriterTracer(w io.Writer, session string) Tracer {
+	return &writerTracer{w: w, session: session, now: time.Now}
+}
+
+func (t *writerTracer) TraceLine(dir Direction, line string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.w, "%s %s %s %s\n", t.now().Format("2006-01-02T15:04:05.000Z07:00"), t.session, dir, line)
+}
+
+// redactedParams lists commands whose parameters may carry secrets:
+// SETERROR may quote the passphrase and INQUIRE QUALITY sends the
+// passphrase being rated.
+var redactedParams = map[string]string{
+	"SETERROR": "",
+	"INQUIRE":  "QUALITY",
+}
+
+// Redact returns line with secrets replaced: D lines (which carry PINs)
+// are reduced to their length, parameters of SETERROR and INQUIRE QUALITY
+// are removed.
+func Redact(line []byte) string {
+	parts := bytes.SplitN(line, []byte(" "), 2)
+	cmd := strings.ToUpper(string(parts[0]))
+	if len(parts) == 1 {
+		return string(line)
+	}
+
+	if cmd == "D" {
+		return fmt.Sprintf("D [%d bytes redacted]", len(parts[1]))
+	}
+
+	keyword, ok := redactedParams[cmd]
+	if !ok {
+		return string(line)
+	}
+	if keyword == "" {
+		return string(parts[0]) + " [redacted]"
+	}
+
+	params := bytes.SplitN(parts[1], []byte(" "), 2)
+	if len(params) == 2 && strings.EqualFold(string(params[0]), keyword) {
+		return string(parts[0]) + " " + string(params[0]) + " [redacted]"
+	}
+	return string(line)
+}
+
+// lineTracer splits a byte stream into lines and passes them to Tracer.
+type lineTracer struct {
+	tracer Tracer
+	dir    Direction
+	buf    []byte
+}
+
+func (l *lineTracer) feed(p []byte) {
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			l.buf = append(l.buf, p...)
+			return
+		}
+
+		l.buf = append(l.buf, p[:i]...)
+		l.tracer.TraceLine(l.dir, Redact(l.buf))
+		// Line may be a D line with PIN.
+		Wipe(l.buf)
+		l.buf = l.buf[:0]
+		p = p[i+1:]
+	}
+}
+
+// tracedPipe passes everything read and written through lineTracers.
+type tracedPipe struct {
+	pipe   io.ReadWriter
+	closer io.Closer
+	mu     sync.Mutex
+	in     lineTracer
+	out    lineTracer
+}
+
+func (t *tracedPipe) Read(p []byte) (int, error) {
+	n, err := t.pipe.Read(p)
+	t.mu.Lock()
+	t.in.feed(p[:n])
+	t.mu.Unlock()
+	return n, err
+}
+
+func (t *tracedPipe) Write(p []byte) (int, error) {
+	n, err := t.pipe.Write(p)
+	t.mu.Lock()
+	t.out.feed(p[:n])
+	t.mu.Unlock()
+	return n, err
+}
+
+func (t *tracedPipe) Close() error {
+	t.mu.Lock()
+	Wipe(t.in.buf)
+	Wipe(t.out.buf)
+	t.mu.Unlock()
+
+	if t.closer == nil {
+		return nil
+	}
+	return t.closer.Close()
+}
+
+// TracePipe returns pipe that passes every line read from and written to
+// pipe to tracer. If tracer is nil, pipe is returned as is.
+//
+// Returned pipe implements io.Closer, it closes pipe if it is io.Closer too.
+func TracePipe(pipe io.ReadWriter, tracer Tracer) io.ReadWriter {
+	if tracer == nil {
+		return pipe
+	}
+
+	t := &tracedPipe{
+		pipe: pipe,
+		in:   lineTracer{tracer: tracer, dir: Received},
+		out:  lineTracer{tracer: tracer, dir: Sent},
+	}
+	t.closer, _ = pipe.(io.Closer)
+	return t
+}
@@ -0,0 +1,77 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+type recorder struct {
+	lines []string
+}
+
+func (r *recorder) TraceLine(dir Direction, line string) {
+	r.lines = append(r.lines, string(dir)+" "+line)
+}
+
+func TestRedact(t *testing.T) {
+	cases := map[string]string{
+		"D secret%25":                    "D [9 bytes redacted]",
+		"GETPIN":                         "GETPIN",
+		"SETDESC Enter the passphrase":   "SETDESC Enter the passphrase",
+		"SETERROR Bad passphrase secret": "SETERROR [redacted]",
+		"INQUIRE QUALITY secret":         "INQUIRE QUALITY [redacted]",
+		"inquire quality secret":         "inquire quality [redacted]",
+		"INQUIRE PINENTRY_LAUNCHED 1":    "INQUIRE PINENTRY_LAUNCHED 1",
+	}
+	for line, want := range cases {
+		if got := Redact([]byte(line)); got != want {
+			t.Errorf("Redact(%q) = %q, want %q", line, got, want)
+		}
+	}
+}
+
+func TestTracePipe(t *testing.T) {
+	var out bytes.Buffer
+	in := strings.NewReader("SETDESC Hello\nGETPIN\n")
+	rec := &recorder{}
+	pipe := TracePipe(ReadWriter{Reader: in, Writer: &out}, rec)
+
+	scanner := bufio.NewScanner(pipe)
+	for i := 0; i < 2; i++ {
+		if _, _, err := ReadLine(scanner); err != nil {
+			t.Fatal("ReadLine failed:", err)
+		}
+	}
+	if err := WriteData(pipe, []byte("secret")); err != nil {
+		t.Fatal("WriteData failed:", err)
+	}
+	if err := WriteLine(pipe, "OK", ""); err != nil {
+		t.Fatal("WriteLine failed:", err)
+	}
+
+	want := []string{"< SETDESC Hello", "< GETPIN", "> D [6 bytes redacted]", "> OK"}
+	if strings.Join(rec.lines, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("Unexpected trace: %q", rec.lines)
+	}
+	if out.String() != "D secret\nOK\n" {
+		t.Fatalf("Output should not be changed: %q", out.String())
+	}
+}
+
+func TestTracePipeNil(t *testing.T) {
+	pipe := ReadWriter{Reader: strings.NewReader(""), Writer: ioutil.Discard}
+	if TracePipe(pipe, nil) != pipe {
+		t.Fatal("Pipe should not be wrapped without tracer")
+	}
+}
+
+func TestWriterTracer(t *testing.T) {
+	var out bytes.Buffer
+	NewWriterTracer(&out, "1234").TraceLine(Sent, "OK")
+	if !strings.HasSuffix(out.String(), " 1234 > OK\n") {
+		t.Fatalf("Unexpected trace: %q", out.String())
+	}
+}
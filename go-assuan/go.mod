@@ -1 +1,3 @@
 module github.com/foxcpp/go-assuan
+
+go 1.27.1
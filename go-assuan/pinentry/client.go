@@ -1,6 +1,7 @@
 package pinentry
 
 import (
+	"errors"
 	"os/exec"
 	"strconv"
 	"time"
@@ -29,11 +30,17 @@ func Launch() (*Client, error) {
 }
 
 func LaunchCustom(path string) (Client, error) {
+	return LaunchTraced(path, nil)
+}
+
+// LaunchTraced is same as LaunchCustom but passes every line exchanged with
+// pinentry to tracer (see common.TracePipe), tracer may be nil.
+func LaunchTraced(path string, tracer common.Tracer) (Client, error) {
 	cmd := exec.Command(path)
 
 	c := Client{}
 	var err error
-	c.Session, err = assuan.InitCmd(cmd)
+	c.Session, err = assuan.InitCmdTraced(cmd, tracer)
 	if err != nil {
 		return Client{}, err
 	}
@@ -104,7 +111,7 @@ func (c *Client) SetQualityBar(text string) {
 	c.qualityBar = true
 }
 
-func (c *Client) SetPasswdQualityCallback(callback func(string) int) {
+func (c *Client) SetPasswdQualityCallback(callback func([]byte) int) {
 	c.current.PasswordQuality = callback
 }
 
@@ -127,35 +134,41 @@ func (c *Client) Apply(s Settings) {
 	c.current.PasswordQuality = s.PasswordQuality
 }
 
+// pinentryError returns the error sent by pinentry (e.g. a timeout) as is,
+// other errors (e.g. I/O errors) are reported as canceled operation.
+func pinentryError(err error) *common.Error {
+	var e common.Error
+	if errors.As(err, &e) {
+		return &e
+	}
+
+	return &common.Error{
+		Src:     common.ErrSrcPinentry,
+		SrcName: "pinentry",
+		Code:    common.ErrCanceled,
+		Message: err.Error(),
+	}
+}
+
 // GetPIN shows window with password textbox, Cancel and Ok buttons.
-// Error is returned if Cancel is pressed.
-func (c *Client) GetPIN(s Settings) (string, *common.Error) {
+// Error is returned if Cancel is pressed, see pinentryError.
+func (c *Client) GetPIN(s Settings) ([]byte, *common.Error) {
 	if c.qualityBar {
 		pin, err := c.getPINWithQualBar()
 		if err != nil {
-			return "", &common.Error{
-				Src:     common.ErrSrcPinentry,
-				SrcName: "pinentry",
-				Code:    common.ErrCanceled,
-				Message: err.Error(),
-			}
+			return nil, pinentryError(err)
 		}
 		return pin, nil
 	}
 
 	dat, err := c.Session.SimpleCmd("GETPIN", "")
 	if err != nil {
-		return "", &common.Error{
-			Src:     common.ErrSrcPinentry,
-			SrcName: "pinentry",
-			Code:    common.ErrCanceled,
-			Message: err.Error(),
-		}
+		return nil, pinentryError(err)
 	}
-	return string(dat), nil
+	return dat, nil
 }
 
-func (c *Client) getPINWithQualBar() (string, error) {
+func (c *Client) getPINWithQualBar() ([]byte, error) {
 	// We will get requests in following form:
 	//  INQUIRE QUALITY password-here
 	// and we should respond with quality percentage,
@@ -168,39 +181,45 @@ func (c *Client) getPINWithQualBar() (string, error) {
 
 	scnr := c.Session.Scanner
 	for {
-		cmd, params, err := common.ReadLine(scnr)
+		// The password is read as bytes, never as a string, so that the
+		// caller can wipe it.
+		cmd, params, data, err := common.ReadLineData(scnr, c.Session.Status)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
 		if cmd == "D" {
 			// We got password.
 
 			// Take OK from pipe.
-			common.ReadLine(scnr)
+			common.ReadLineStatus(scnr, c.Session.Status)
 
-			return params, nil
+			return data, nil
 		}
 
-		if cmd == "INQUIRE" {
-			// params[8:] is
-			//  QUALITY password-here
-			//          ^~~~~~~~~~~~~
-			passwd := params[8:]
+		if cmd == "OK" {
+			// Empty password.
+			return nil, nil
+		}
 
+		if cmd == "INQUIRE" {
+			// data is the password of
+			//  INQUIRE QUALITY password-here
 			if c.current.PasswordQuality == nil {
+				common.Wipe(data)
 				common.WriteLine(c.Session.Pipe, "D", "0")
 				common.WriteLine(c.Session.Pipe, "END", "")
 				continue
 			}
 
-			quality := c.current.PasswordQuality(passwd)
+			quality := c.current.PasswordQuality(data)
+			common.Wipe(data)
 			common.WriteLine(c.Session.Pipe, "D", strconv.Itoa(quality))
 			common.WriteLine(c.Session.Pipe, "END", "")
 		}
 
 		if cmd == "ERR" {
-			return "", common.DecodeErrCmd(params)
+			return nil, common.DecodeErrCmd(params)
 		}
 	}
 }
@@ -210,12 +229,7 @@ func (c *Client) getPINWithQualBar() (string, error) {
 func (c *Client) Confirm(s Settings) (bool, *common.Error) {
 	_, err := c.Session.SimpleCmd("CONFIRM", "")
 	if err != nil {
-		return false, &common.Error{
-			Src:     common.ErrSrcPinentry,
-			SrcName: "pinentry",
-			Code:    common.ErrCanceled,
-			Message: err.Error(),
-		}
+		return false, pinentryError(err)
 	}
 	return true, nil
 }
@@ -0,0 +1,38 @@
+package pinentry
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	assuan "github.com/foxcpp/go-assuan/client"
+	"github.com/foxcpp/go-assuan/common"
+)
+
+// fakeClient returns Client whose pinentry answers with input.
+func fakeClient(t *testing.T, input string) *Client {
+	ses, err := assuan.InitNopClose(common.ReadWriter{Reader: strings.NewReader("OK\n" + input), Writer: &strings.Builder{}})
+	if err != nil {
+		t.Fatal("Init failed:", err)
+	}
+	return &Client{Session: ses}
+}
+
+func TestGetPINError(t *testing.T) {
+	timeout := "ERR " + strconv.Itoa(common.MakeErrCode(common.ErrSrcPinentry, common.ErrTimeout)) + " Timeout <Pinentry>\n"
+
+	for _, qualityBar := range []bool{false, true} {
+		c := fakeClient(t, timeout)
+		c.qualityBar = qualityBar
+
+		// Error sent by pinentry is returned as is.
+		if _, err := c.GetPIN(Settings{}); err == nil || err.Code != common.ErrTimeout {
+			t.Fatalf("Unexpected error (quality bar: %v): %#v", qualityBar, err)
+		}
+
+		// I/O errors cancel the operation.
+		if _, err := c.GetPIN(Settings{}); err == nil || err.Code != common.ErrCanceled {
+			t.Fatalf("Unexpected error (quality bar: %v): %#v", qualityBar, err)
+		}
+	}
+}
@@ -2,16 +2,22 @@ package pinentry
 
 import (
 	"io"
+	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	assuan "github.com/foxcpp/go-assuan/client"
 	"github.com/foxcpp/go-assuan/common"
 	"github.com/foxcpp/go-assuan/server"
 )
 
+// Callbacks implement the pinentry operations.
+//
+// The PIN returned by GetPIN is owned by the server: it is wiped once it
+// is sent to the client.
 type Callbacks struct {
-	GetPIN  func(Settings) (string, *common.Error)
+	GetPIN  func(Settings) ([]byte, *common.Error)
 	Confirm func(Settings) (bool, *common.Error)
 	Msg     func(Settings) *common.Error
 }
@@ -49,9 +55,29 @@ func setCancel(_ io.ReadWriter, state interface{}, params string) *common.Error
 	return nil
 }
 func setQualityBar(_ io.ReadWriter, state interface{}, params string) *common.Error {
+	if len(params) == 0 {
+		// Text is optional but quality bar is still requested.
+		params = "Quality:"
+	}
 	state.(*Settings).QualityBar = params
 	return nil
 }
+func setQualityBarTT(_ io.ReadWriter, state interface{}, params string) *common.Error {
+	state.(*Settings).QualityBarTT = params
+	return nil
+}
+func setGenPIN(_ io.ReadWriter, state interface{}, params string) *common.Error {
+	if len(params) == 0 {
+		// Text is optional but generation is still allowed.
+		params = "Suggest"
+	}
+	state.(*Settings).GenPIN = params
+	return nil
+}
+func setGenPINTT(_ io.ReadWriter, state interface{}, params string) *common.Error {
+	state.(*Settings).GenPINTT = params
+	return nil
+}
 func setTitle(_ io.ReadWriter, state interface{}, params string) *common.Error {
 	state.(*Settings).Title = params
 	return nil
@@ -64,7 +90,7 @@ func setTimeout(_ io.ReadWriter, state interface{}, params string) *common.Error
 			"invalid timeout value", "pinentry",
 		}
 	}
-	state.(*Settings).Timeout = time.Duration(i)
+	state.(*Settings).Timeout = time.Duration(i) * time.Second
 	return nil
 }
 func setOpt(state interface{}, key string, val string) *common.Error {
@@ -146,19 +172,22 @@ func setKeyInfo(_ io.ReadWriter, state interface{}, params string) *common.Error
 var ProtoInfo = server.ProtoInfo{
 	Greeting: "go-assuan pinentry",
 	Handlers: map[string]server.CommandHandler{
-		"SETDESC":        setDesc,
-		"SETPROMPT":      setPrompt,
-		"SETREPEAT":      setRepeat,
-		"SETREPEATERROR": setRepeatError,
-		"SETERROR":       setError,
-		"SETOK":          setOk,
-		"SETNOTOK":       setNotOk,
-		"SETCANCEL":      setCancel,
-		"SETQUALITYBAR":  setQualityBar,
-		"SETTITLE":       setTitle,
-		"SETTIMEOUT":     setTimeout,
-		"RESET":          resetState,
-		"SETKEYINFO":     setKeyInfo,
+		"SETDESC":          setDesc,
+		"SETPROMPT":        setPrompt,
+		"SETREPEAT":        setRepeat,
+		"SETREPEATERROR":   setRepeatError,
+		"SETERROR":         setError,
+		"SETOK":            setOk,
+		"SETNOTOK":         setNotOk,
+		"SETCANCEL":        setCancel,
+		"SETQUALITYBAR":    setQualityBar,
+		"SETQUALITYBAR_TT": setQualityBarTT,
+		"SETGENPIN":        setGenPIN,
+		"SETGENPIN_TT":     setGenPINTT,
+		"SETTITLE":         setTitle,
+		"SETTIMEOUT":       setTimeout,
+		"RESET":            resetState,
+		"SETKEYINFO":       setKeyInfo,
 	},
 	Help: map[string][]string{}, // TODO
 	GetDefaultState: func() interface{} {
@@ -167,14 +196,27 @@ var ProtoInfo = server.ProtoInfo{
 	SetOption: setOpt,
 }
 
-func Serve(callbacks Callbacks, customGreeting string) error {
-	info := ProtoInfo
+// inquireQuality returns password quality callback that asks client (i.e.
+// gpg-agent) to rate password using INQUIRE QUALITY.
+func inquireQuality(pipe io.ReadWriter) func([]byte) int {
+	return func(passwd []byte) int {
+		data, err := server.InquireParams(pipe, "QUALITY", passwd)
+		if err != nil {
+			Logger.Println("QUALITY inquire failed:", err)
+			return 0
+		}
 
-	if len(customGreeting) != 0 {
-		info.Greeting = customGreeting
+		quality, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			Logger.Println("Malformed QUALITY response:", err)
+			return 0
+		}
+		return quality
 	}
+}
 
-	info.Handlers["GETPIN"] = func(pipe io.ReadWriter, state interface{}, _ string) *common.Error {
+func getPINHandler(callbacks Callbacks) server.CommandHandler {
+	return func(pipe io.ReadWriter, state interface{}, _ string) *common.Error {
 		if callbacks.GetPIN == nil {
 			Logger.Println("GETPIN requested but not supported")
 			return &common.Error{
@@ -183,15 +225,25 @@ func Serve(callbacks Callbacks, customGreeting string) error {
 			}
 		}
 
-		pass, err := callbacks.GetPIN(*state.(*Settings))
+		settings := *state.(*Settings)
+		if len(settings.QualityBar) != 0 {
+			settings.PasswordQuality = inquireQuality(pipe)
+		}
+
+		pass, err := callbacks.GetPIN(settings)
 		if err != nil {
 			return err
 		}
 
-		common.WriteData(pipe, []byte(pass)) // Server code will take care of I/O errors.
+		defer common.Wipe(pass)
+
+		common.WriteData(pipe, pass) // Server code will take care of I/O errors.
 		return nil
 	}
-	info.Handlers["CONFIRM"] = func(pipe io.ReadWriter, state interface{}, _ string) *common.Error {
+}
+
+func confirmHandler(callbacks Callbacks) server.CommandHandler {
+	return func(pipe io.ReadWriter, state interface{}, _ string) *common.Error {
 		if callbacks.Confirm == nil {
 			Logger.Println("CONFIRM requested but not supported")
 			return &common.Error{
@@ -210,7 +262,10 @@ func Serve(callbacks Callbacks, customGreeting string) error {
 		}
 		return nil
 	}
-	info.Handlers["MESSAGE"] = func(pipe io.ReadWriter, state interface{}, _ string) *common.Error {
+}
+
+func messageHandler(callbacks Callbacks) server.CommandHandler {
+	return func(pipe io.ReadWriter, state interface{}, _ string) *common.Error {
 		if callbacks.Msg == nil {
 			Logger.Println("MESSAGE requested but not supported")
 			return &common.Error{
@@ -221,7 +276,66 @@ func Serve(callbacks Callbacks, customGreeting string) error {
 
 		return callbacks.Msg(*state.(*Settings))
 	}
+}
+
+// protoInfo returns copy of ProtoInfo with handlers for callbacks.
+func protoInfo(callbacks Callbacks, customGreeting string) server.ProtoInfo {
+	info := ProtoInfo
+
+	if len(customGreeting) != 0 {
+		info.Greeting = customGreeting
+	}
+
+	// Copy handlers so ProtoInfo is not modified.
+	info.Handlers = make(map[string]server.CommandHandler, len(ProtoInfo.Handlers)+3)
+	for cmd, hndlr := range ProtoInfo.Handlers {
+		info.Handlers[cmd] = hndlr
+	}
+	info.Handlers["GETPIN"] = getPINHandler(callbacks)
+	info.Handlers["CONFIRM"] = confirmHandler(callbacks)
+	info.Handlers["MESSAGE"] = messageHandler(callbacks)
+
+	return info
+}
+
+func Serve(callbacks Callbacks, customGreeting string) error {
+	return ServeTraced(callbacks, customGreeting, nil)
+}
+
+// ServeTraced is same as Serve but passes every line exchanged with client
+// to tracer (see common.TracePipe), tracer may be nil.
+func ServeTraced(callbacks Callbacks, customGreeting string, tracer common.Tracer) error {
+	pipe := common.TracePipe(common.ReadWriter{Reader: os.Stdin, Writer: os.Stdout}, tracer)
+	return server.Serve(pipe, protoInfo(callbacks, customGreeting))
+}
+
+// ServeProxy relays pinentry session on stdin and stdout to upstream pinentry
+// (see server.Proxy), so every command, option, status and inquiry reaches it
+// unchanged. Non-nil callbacks intercept the corresponding command (GETPIN,
+// CONFIRM or MESSAGE), they get settings sent so far just like with Serve.
+//
+// Upstream pipe is closed when session ends.
+func ServeProxy(upstream *assuan.Session, callbacks Callbacks) error {
+	return ServeProxyTraced(upstream, callbacks, nil)
+}
+
+// ServeProxyTraced is same as ServeProxy but passes every line exchanged
+// with client to tracer (see common.TracePipe), tracer may be nil. Lines
+// exchanged with upstream are traced by its session, see LaunchTraced.
+func ServeProxyTraced(upstream *assuan.Session, callbacks Callbacks, tracer common.Tracer) error {
+	defer upstream.Pipe.Close()
+
+	intercept := map[string]server.CommandHandler{}
+	if callbacks.GetPIN != nil {
+		intercept["GETPIN"] = getPINHandler(callbacks)
+	}
+	if callbacks.Confirm != nil {
+		intercept["CONFIRM"] = confirmHandler(callbacks)
+	}
+	if callbacks.Msg != nil {
+		intercept["MESSAGE"] = messageHandler(callbacks)
+	}
 
-	err := server.ServeStdin(info)
-	return err
+	pipe := common.TracePipe(common.ReadWriter{Reader: os.Stdin, Writer: os.Stdout}, tracer)
+	return server.Proxy(pipe, upstream, ProtoInfo, intercept)
 }
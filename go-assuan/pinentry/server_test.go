@@ -0,0 +1,57 @@
+package pinentry
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/foxcpp/go-assuan/common"
+	"github.com/foxcpp/go-assuan/server"
+)
+
+func TestGetPINQualityInquire(t *testing.T) {
+	callbacks := Callbacks{
+		GetPIN: func(s Settings) ([]byte, *common.Error) {
+			if s.PasswordQuality == nil {
+				return nil, &common.Error{
+					Src: common.ErrSrcPinentry, Code: common.ErrGeneral,
+					SrcName: "pinentry", Message: "missing quality callback",
+				}
+			}
+			return []byte(strconv.Itoa(s.PasswordQuality([]byte("secret")))), nil
+		},
+	}
+
+	srv, cli := net.Pipe()
+	defer cli.Close()
+	go server.Serve(srv, protoInfo(callbacks, ""))
+
+	scnr := bufio.NewScanner(cli)
+	expect := func(want string) {
+		line, err := common.ReadRawLine(scnr)
+		if err != nil {
+			t.Fatalf("failed to read from server: %s", err)
+		}
+		if line != want {
+			t.Fatalf("unexpected line got: %q want: %q", line, want)
+		}
+	}
+	send := func(lines ...string) {
+		go func() {
+			for _, line := range lines {
+				io.WriteString(cli, line+"\n")
+			}
+		}()
+	}
+
+	expect("OK go-assuan pinentry")
+	send("SETQUALITYBAR")
+	expect("OK")
+	send("GETPIN")
+	expect("INQUIRE QUALITY secret")
+	send("D 42", "END")
+	expect("D 42")
+	expect("OK")
+}
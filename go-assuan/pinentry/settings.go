@@ -21,6 +21,9 @@ type Options struct {
 type Settings struct {
 	// Detailed description of request.
 	Desc string
+	// Desc shows a secret (e.g. a generated passphrase) and should be
+	// redacted from traces like PINs.
+	SecretDesc bool
 	// Text right before textbox.
 	Prompt string
 	// Error to show. Reset after GetPin.
@@ -43,8 +46,17 @@ type Settings struct {
 	RepeatError string
 	// Text before password quality bar.
 	QualityBar string
-	// Password quality callback.
-	PasswordQuality func(string) int
+	// Tooltip for password quality bar.
+	QualityBarTT string
+	// Password quality callback. Set by server when quality bar is requested,
+	// it asks client (i.e. gpg-agent) to rate password. The password is
+	// wiped once the callback returns.
+	PasswordQuality func([]byte) int
+	// Text of the button offering to generate a passphrase, set if client
+	// (i.e. gpg-agent) allows to generate one.
+	GenPIN string
+	// Tooltip for passphrase generation button.
+	GenPINTT string
 	// Information from the key
 	KeyInfo string
 
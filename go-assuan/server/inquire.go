@@ -2,11 +2,20 @@ package server
 
 import (
 	"bufio"
+	"errors"
 	"io"
 
 	"github.com/foxcpp/go-assuan/common"
 )
 
+// Conn is passed as pipe to command handlers by Serve and Proxy. It carries
+// scanner used by server so handlers can read client's responses to
+// inquiries sent in the middle of command, see InquireParams.
+type Conn struct {
+	io.ReadWriter
+	Scanner *bufio.Scanner
+}
+
 // Inquire requests data with specified keywords from client.
 //
 // It's better to explain by example:
@@ -34,6 +43,7 @@ import (
 //	 }
 func Inquire(scnr *bufio.Scanner, pipe io.Writer, keywords []string) (res map[string][]byte, err error) {
 	Logger.Println("Sending inquire group:", keywords)
+	res = make(map[string][]byte, len(keywords))
 	for _, keyword := range keywords {
 		if err := common.WriteLine(pipe, "INQUIRE", keyword); err != nil {
 			Logger.Println("... I/O error:", err)
@@ -50,3 +60,35 @@ func Inquire(scnr *bufio.Scanner, pipe io.Writer, keywords []string) (res map[st
 	}
 	return res, nil
 }
+
+// InquireParams sends single inquiry with parameters, e.g.
+//  INQUIRE QUALITY password-here
+// and returns data sent by client in response. params is sent without
+// intermediate copies (see common.WriteLineData), it may be a password.
+//
+// pipe must be the one passed to command handler by Serve or Proxy.
+func InquireParams(pipe io.ReadWriter, keyword string, params []byte) ([]byte, error) {
+	conn, ok := pipe.(*Conn)
+	if !ok {
+		return nil, errors.New("inquiries are not supported on this pipe")
+	}
+
+	Logger.Println("Sending inquire:", keyword)
+	var err error
+	if len(params) != 0 {
+		err = common.WriteLineData(conn, "INQUIRE", keyword, params)
+	} else {
+		err = common.WriteLine(conn, "INQUIRE", keyword)
+	}
+	if err != nil {
+		Logger.Println("... I/O error:", err)
+		return nil, err
+	}
+
+	data, err := common.ReadData(conn.Scanner)
+	if err != nil {
+		Logger.Println("... I/O error:", err)
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	assuan "github.com/foxcpp/go-assuan/client"
+	"github.com/foxcpp/go-assuan/common"
+)
+
+// discardPipe is passed to handlers invoked only to keep track of state.
+var discardPipe = common.ReadWriter{Reader: strings.NewReader(""), Writer: ioutil.Discard}
+
+// Proxy serves connection by relaying it to upstream server.
+//
+// Every line sent by client is written to upstream as is and every line
+// sent by upstream (including data lines, status information and inquiries)
+// is written back to client until upstream completes the command with OK or
+// ERR. Data sent by client in response to inquiries is relayed the same way.
+// Upstream's greeting is used for first OK.
+//
+// Relayed commands are also passed to handlers from proto (and OPTION to
+// proto.SetOption) so state object mirrors upstream's state, output and
+// errors of these handlers are discarded, upstream's response is what client
+// gets. Commands with handler in intercept are not relayed at all, they are
+// served locally the same way as Serve does.
+func Proxy(pipe io.ReadWriter, upstream *assuan.Session, proto ProtoInfo, intercept map[string]CommandHandler) error {
+	Logger.Println("Accepted proxied session")
+	state := proto.GetDefaultState()
+	if err := common.WriteLine(pipe, "OK", upstream.Greeting); err != nil {
+		Logger.Println("I/O error, dropping session:", err)
+		return err
+	}
+
+	scanner := bufio.NewScanner(pipe)
+	scanner.Buffer(make([]byte, common.MaxLineLen), common.MaxLineLen)
+	conn := &Conn{pipe, scanner}
+
+	for {
+		line, err := common.ReadRawLine(scanner)
+		if err != nil {
+			Logger.Println("I/O error, dropping session:", err)
+			return err
+		}
+
+		// Comments and empty lines are ignored by upstream, there is no response to wait for.
+		if strings.HasPrefix(line, "#") || len(strings.TrimSpace(line)) == 0 {
+			if err := common.WriteRawLine(upstream.Pipe, line); err != nil {
+				Logger.Println("I/O error, dropping session:", err)
+				return err
+			}
+			continue
+		}
+
+		cmd, params, err := common.ParseLine(line)
+		if err != nil {
+			Logger.Println("I/O error, dropping session:", err)
+			return err
+		}
+
+		if hndlr, prs := intercept[cmd]; prs {
+			Logger.Println("Intercepted command received:", cmd)
+			if err := hndlr(conn, state, params); err != nil {
+				Logger.Println("... handler error:", err)
+				common.WriteError(pipe, *err)
+			} else {
+				common.WriteLine(pipe, "OK", "")
+			}
+			continue
+		}
+
+		Logger.Println("Relaying command:", cmd)
+		trackState(state, proto, cmd, params)
+
+		if err := common.WriteRawLine(upstream.Pipe, line); err != nil {
+			Logger.Println("I/O error, dropping session:", err)
+			return err
+		}
+
+		if err := relayResponse(scanner, pipe, upstream); err != nil {
+			Logger.Println("I/O error, dropping session:", err)
+			return err
+		}
+
+		if cmd == "BYE" {
+			Logger.Println("Session finished")
+			return nil
+		}
+	}
+}
+
+// trackState passes relayed command to protocol handlers so state object
+// reflects what was sent to upstream.
+func trackState(state interface{}, proto ProtoInfo, cmd string, params string) {
+	if cmd == "OPTION" {
+		if proto.SetOption == nil {
+			return
+		}
+		if key, value, err := splitOption(params); err == nil {
+			proto.SetOption(state, key, value)
+		}
+		return
+	}
+
+	if hndlr, prs := proto.Handlers[cmd]; prs {
+		hndlr(discardPipe, state, params)
+	}
+}
+
+// relayResponse copies lines from upstream to client until command is
+// completed. Inquiries are answered by client through relayInquiry.
+func relayResponse(scanner *bufio.Scanner, pipe io.Writer, upstream *assuan.Session) error {
+	for {
+		cmd, err := relayLine(upstream.Scanner, pipe)
+		if err != nil {
+			return err
+		}
+
+		switch cmd {
+		case "OK", "ERR":
+			return nil
+		case "INQUIRE":
+			if err := relayInquiry(scanner, upstream.Pipe); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// relayInquiry copies client's response to inquiry (D lines terminated by
+// END or CAN) to upstream.
+func relayInquiry(scanner *bufio.Scanner, upstream io.Writer) error {
+	for {
+		cmd, err := relayLine(scanner, upstream)
+		if err != nil {
+			return err
+		}
+
+		switch strings.ToUpper(cmd) {
+		case "END", "CAN":
+			return nil
+		}
+	}
+}
+
+// relayLine copies next line read by scanner to pipe as is and returns its
+// command. The line may carry a PIN (D lines, INQUIRE QUALITY), so it's
+// never copied to a string and it's wiped from the buffer of scanner.
+func relayLine(scanner *bufio.Scanner, pipe io.Writer) (cmd string, err error) {
+	if ok := scanner.Scan(); !ok {
+		err = scanner.Err()
+		if err == nil {
+			err = io.EOF
+		}
+		return "", err
+	}
+
+	line := scanner.Bytes()
+	defer common.Wipe(line)
+
+	cmd = string(bytes.SplitN(line, []byte(" "), 2)[0])
+	if _, err := pipe.Write(line); err != nil {
+		return "", err
+	}
+	if _, err := pipe.Write([]byte{'\n'}); err != nil {
+		return "", err
+	}
+	return cmd, nil
+}
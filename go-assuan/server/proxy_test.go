@@ -0,0 +1,149 @@
+package server_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	assuan "github.com/foxcpp/go-assuan/client"
+	"github.com/foxcpp/go-assuan/common"
+	"github.com/foxcpp/go-assuan/server"
+)
+
+// upstreamProto is served by fake upstream server. GETINFO sends status and
+// data, NEEDDATA inquires client and echoes received data back.
+var upstreamProto = server.ProtoInfo{
+	Greeting: "Pleased to meet you",
+	Handlers: map[string]server.CommandHandler{
+		"SETDESC": setdesc,
+		"GETINFO": func(pipe io.ReadWriter, _ interface{}, params string) *common.Error {
+			common.WriteLine(pipe, "S", "PROGRESS "+params)
+			common.WriteData(pipe, []byte("info%"))
+			return nil
+		},
+		"NEEDDATA": func(pipe io.ReadWriter, _ interface{}, _ string) *common.Error {
+			// Inquiry data is read by hand since handlers have no access to
+			// server's scanner.
+			common.WriteLine(pipe, "INQUIRE", "QUALITY secret")
+			scnr := bufio.NewScanner(pipe)
+			data, err := common.ReadData(scnr)
+			if err != nil {
+				return &common.Error{
+					Src: common.ErrSrcAssuan, Code: common.ErrAssCanceled,
+					SrcName: "assuan", Message: "inquire canceled",
+				}
+			}
+			common.WriteData(pipe, data)
+			return nil
+		},
+	},
+	GetDefaultState: func() interface{} {
+		return &State{}
+	},
+	SetOption: func(state interface{}, key string, val string) *common.Error {
+		return nil
+	},
+}
+
+func startProxy(t *testing.T, intercept map[string]server.CommandHandler) (*bufio.Scanner, io.Writer, *State) {
+	upServer, upClient := net.Pipe()
+	go server.Serve(upServer, upstreamProto)
+
+	upstream, err := assuan.Init(upClient)
+	if err != nil {
+		t.Fatalf("failed to connect to upstream: %s", err)
+	}
+
+	state := &State{}
+	proto := server.ProtoInfo{
+		Handlers:        map[string]server.CommandHandler{"SETDESC": setdesc},
+		GetDefaultState: func() interface{} { return state },
+	}
+
+	down, proxied := net.Pipe()
+	go server.Proxy(proxied, upstream, proto, intercept)
+	t.Cleanup(func() {
+		down.Close()
+		upClient.Close()
+	})
+
+	scnr := bufio.NewScanner(down)
+	return scnr, down, state
+}
+
+// transact sends lines to proxy and returns everything it sent back until the
+// number of expected lines is received.
+func transact(t *testing.T, scnr *bufio.Scanner, pipe io.Writer, lines []string, expected int) []string {
+	go func() {
+		for _, line := range lines {
+			io.WriteString(pipe, line+"\n")
+		}
+	}()
+
+	var res []string
+	for len(res) < expected {
+		line, err := common.ReadRawLine(scnr)
+		if err != nil {
+			t.Fatalf("failed to read from proxy: %s", err)
+		}
+		res = append(res, line)
+	}
+	return res
+}
+
+func TestProxyRelaysSession(t *testing.T) {
+	scnr, pipe, state := startProxy(t, nil)
+
+	greeting := transact(t, scnr, pipe, nil, 1)
+	if greeting[0] != "OK Pleased to meet you" {
+		t.Fatalf("upstream greeting should be relayed, got: %q", greeting[0])
+	}
+
+	res := transact(t, scnr, pipe, []string{"SETDESC Hello%0AWorld"}, 1)
+	if res[0] != "OK" {
+		t.Fatalf("unexpected response: %q", res)
+	}
+	if state.desc != "Hello\nWorld" {
+		t.Fatalf("proxy state should track relayed commands, got: %q", state.desc)
+	}
+
+	res = transact(t, scnr, pipe, []string{"GETINFO 42"}, 3)
+	want := []string{"S PROGRESS 42", "D info%25", "OK"}
+	if strings.Join(res, "|") != strings.Join(want, "|") {
+		t.Fatalf("status and data lines should be relayed as is, got: %q want: %q", res, want)
+	}
+
+	res = transact(t, scnr, pipe, []string{"NEEDDATA"}, 1)
+	if res[0] != "INQUIRE QUALITY secret" {
+		t.Fatalf("inquiry should be relayed, got: %q", res)
+	}
+	res = transact(t, scnr, pipe, []string{"D 73", "END"}, 2)
+	want = []string{"D 73", "OK"}
+	if strings.Join(res, "|") != strings.Join(want, "|") {
+		t.Fatalf("inquiry response should reach upstream, got: %q want: %q", res, want)
+	}
+
+	res = transact(t, scnr, pipe, []string{"FOOBAR"}, 1)
+	if !strings.HasPrefix(res[0], "ERR ") {
+		t.Fatalf("upstream error for unknown command should be relayed, got: %q", res)
+	}
+}
+
+func TestProxyIntercept(t *testing.T) {
+	intercept := map[string]server.CommandHandler{
+		"GETPIN": func(pipe io.ReadWriter, state interface{}, _ string) *common.Error {
+			common.WriteData(pipe, []byte(state.(*State).desc))
+			return nil
+		},
+	}
+	scnr, pipe, _ := startProxy(t, intercept)
+	transact(t, scnr, pipe, nil, 1)
+
+	res := transact(t, scnr, pipe, []string{"SETDESC secret", "GETPIN"}, 3)
+	want := []string{"OK", "D secret", "OK"}
+	if strings.Join(res, "|") != strings.Join(want, "|") {
+		t.Fatalf("GETPIN should be served locally, got: %q want: %q", res, want)
+	}
+}
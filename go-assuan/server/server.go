@@ -56,6 +56,7 @@ func Serve(pipe io.ReadWriter, proto ProtoInfo) error {
 
 	scanner := bufio.NewScanner(pipe)
 	scanner.Buffer(make([]byte, common.MaxLineLen), common.MaxLineLen)
+	conn := &Conn{pipe, scanner}
 
 	for {
 		cmd, params, err := common.ReadLine(scanner)
@@ -88,7 +89,7 @@ func Serve(pipe io.ReadWriter, proto ProtoInfo) error {
 				continue
 			}
 
-			if err := hndlr(pipe, state, params); err != nil {
+			if err := hndlr(conn, state, params); err != nil {
 				Logger.Println("... handler error:", err)
 				common.WriteError(pipe, *err)
 			} else {
@@ -1,13 +1,29 @@
 module github.com/jorgelbg/pinentry-touchid
 
-go 1.16
+go 1.27.1
 
 replace github.com/foxcpp/go-assuan => ./go-assuan
 
 require (
-	github.com/enescakir/emoji v1.0.0 // indirect
+	github.com/enescakir/emoji v1.0.0
 	github.com/foxcpp/go-assuan v1.0.0
 	github.com/gopasspw/pinentry v0.0.2
 	github.com/keybase/go-keychain v0.0.0-20201121013009-976c83ec27a6
+	github.com/keybase/go.dbus v0.0.0-20200324223359-a94be52c0b03
 	github.com/lox/go-touchid v0.0.0-20170712105233-619cc8e578d0
+	golang.org/x/sys v0.0.0-20201119102817-f84b799fce68
+	golang.org/x/term v0.0.0-20210220032956-6a3ed077a48d
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/stretchr/objx v0.1.0 // indirect
+	github.com/stretchr/testify v1.5.1 // indirect
+	golang.org/x/crypto v0.0.0-20200323165209-0ec3e9974c59 // indirect
+	golang.org/x/net v0.0.0-20190404232315-eb5bcb51f2a3 // indirect
+	golang.org/x/text v0.3.0 // indirect
+	gopkg.in/check.v1 v0.0.0-20161208181325-20d25e280405 // indirect
+	gopkg.in/yaml.v2 v2.2.2 // indirect
 )
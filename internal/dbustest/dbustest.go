@@ -0,0 +1,100 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package dbustest starts a private D-Bus daemon so stand-ins of system services can be tested
+// without touching the real system bus.
+package dbustest
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jorgelbg/pinentry-touchid/internal/dbusutil"
+	dbus "github.com/keybase/go.dbus"
+)
+
+const configTemplate = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:path=%s</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`
+
+// Start runs a dbus-daemon for the duration of the test and returns its address. The test is
+// skipped when dbus-daemon is not installed.
+func Start(t *testing.T) string {
+	t.Helper()
+
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon is not available")
+	}
+
+	dir := t.TempDir()
+	config := filepath.Join(dir, "bus.conf")
+	socket := filepath.Join(dir, "bus")
+	if err := os.WriteFile(config, []byte(fmt.Sprintf(configTemplate, socket)), 0600); err != nil {
+		t.Fatalf("failed to write the bus configuration: %s", err)
+	}
+
+	cmd := exec.Command(daemon, "--config-file="+config, "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("failed to start dbus-daemon: %s", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start dbus-daemon: %s", err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	// the address is printed once the daemon is listening
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("failed to read the bus address: %s", err)
+	}
+
+	return strings.TrimSpace(address)
+}
+
+// Serve connects to the bus at address, exports each object under its path and interface and
+// takes ownership of name. The connection is closed at the end of the test.
+func Serve(t *testing.T, address, name string, objects map[dbus.ObjectPath]map[string]interface{}) *dbusutil.Conn {
+	t.Helper()
+
+	conn, err := dbusutil.Connect(address)
+	if err != nil {
+		t.Fatalf("failed to connect to the bus: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	for path, ifaces := range objects {
+		for iface, v := range ifaces {
+			if err := conn.Export(v, path, iface); err != nil {
+				t.Fatalf("failed to export %s: %s", path, err)
+			}
+		}
+	}
+
+	reply, err := conn.RequestName(name, dbus.NameFlagDoNotQueue)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("failed to own %s: %v", name, err)
+	}
+
+	return conn
+}
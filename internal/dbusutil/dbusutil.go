@@ -0,0 +1,137 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package dbusutil contains helpers shared by the authenticators that talk to system services
+// over D-Bus.
+package dbusutil
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	dbus "github.com/keybase/go.dbus"
+)
+
+// DefaultSystemBusAddress is used when DBUS_SYSTEM_BUS_ADDRESS is not set
+const DefaultSystemBusAddress = "unix:path=/var/run/dbus/system_bus_socket"
+
+// Unavailable lists the errors returned by the bus when the destination service isn't running
+var Unavailable = []string{
+	"org.freedesktop.DBus.Error.ServiceUnknown",
+	"org.freedesktop.DBus.Error.NameHasNoOwner",
+}
+
+// Conn is a private connection to a bus
+type Conn struct {
+	*dbus.Conn
+	raw net.Conn
+}
+
+// Close closes the connection. dbus.Conn.Close panics if it is called twice, which happens when
+// the connection is closed by the caller and then by the reader goroutine. Closing the socket
+// lets the reader goroutine close the dbus.Conn once.
+func (c *Conn) Close() error {
+	return c.raw.Close()
+}
+
+// dial opens the socket of the first unix address in address
+func dial(address string) (net.Conn, error) {
+	for _, addr := range strings.Split(address, ";") {
+		parts := strings.SplitN(addr, ":", 2)
+		if len(parts) != 2 || parts[0] != "unix" {
+			continue
+		}
+
+		for _, kv := range strings.Split(parts[1], ",") {
+			switch {
+			case strings.HasPrefix(kv, "path="):
+				return net.Dial("unix", strings.TrimPrefix(kv, "path="))
+			case strings.HasPrefix(kv, "abstract="):
+				return net.Dial("unix", "@"+strings.TrimPrefix(kv, "abstract="))
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported bus address: %s", address)
+}
+
+// Connect opens a private connection to the bus at address, or to the system bus when address is
+// empty. Private connections can be closed safely once a request is done.
+func Connect(address string) (*Conn, error) {
+	if address == "" {
+		address = os.Getenv("DBUS_SYSTEM_BUS_ADDRESS")
+	}
+	if address == "" {
+		address = DefaultSystemBusAddress
+	}
+
+	return connect(address)
+}
+
+// ConnectSession opens a private connection to the bus at address, or to the session bus of the
+// user when address is empty
+func ConnectSession(address string) (*Conn, error) {
+	if address == "" {
+		address = os.Getenv("DBUS_SESSION_BUS_ADDRESS")
+	}
+	if address == "" {
+		return nil, errors.New("DBUS_SESSION_BUS_ADDRESS is not set")
+	}
+
+	return connect(address)
+}
+
+// connect opens a private connection to the bus at address
+func connect(address string) (*Conn, error) {
+	raw, err := dial(address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := dbus.NewConn(raw)
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+
+	// dbus-daemon expects the numeric uid for EXTERNAL, go.dbus sends the user name by default
+	if err = conn.Auth([]dbus.Auth{dbus.AuthExternal(strconv.Itoa(os.Getuid()))}); err != nil {
+		raw.Close()
+		return nil, err
+	}
+
+	c := &Conn{Conn: conn, raw: raw}
+	if err = conn.Hello(); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// ErrorName returns the D-Bus error name of err, or an empty string if err is not a D-Bus error
+func ErrorName(err error) string {
+	var dbusErr dbus.Error
+	if errors.As(err, &dbusErr) {
+		return dbusErr.Name
+	}
+
+	return ""
+}
+
+// IsUnavailable reports whether err means that the destination service isn't running
+func IsUnavailable(err error) bool {
+	name := ErrorName(err)
+	for _, n := range Unavailable {
+		if name == n {
+			return true
+		}
+	}
+
+	return false
+}
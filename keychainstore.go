@@ -0,0 +1,120 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+//
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/jorgelbg/pinentry-touchid/secret"
+	"github.com/jorgelbg/pinentry-touchid/store"
+	"github.com/jorgelbg/pinentry-touchid/store/secretservice"
+	"github.com/keybase/go-keychain"
+)
+
+const (
+	// keychainBackend is the name of the macOS keychain in the migrate command
+	keychainBackend = "keychain"
+	// secretServiceBackend is the name of the freedesktop.org Secret Service in the migrate command
+	secretServiceBackend = "secret-service"
+)
+
+var _ store.Store = keychainStore{}
+
+// keychainStore keeps the passphrases in the login keychain as generic passwords of the GnuPG
+// service, the account of an item is the keygrip of its key (see keptAccountSuffix)
+type keychainStore struct{}
+
+// entryFromItem returns the entry described by the attributes of item
+func entryFromItem(item keychain.QueryResult) store.Entry {
+	return store.Entry{
+		Label:    item.Label,
+		Keygrip:  keygripFromAccount(item.Account),
+		Created:  item.CreationDate,
+		Modified: item.ModificationDate,
+	}
+}
+
+// Find returns the best ranked item with label
+func (keychainStore) Find(label, keygrip string) (store.Entry, bool, error) {
+	matches, err := keychainMatches(label, keygrip)
+	if err != nil || len(matches) == 0 {
+		return store.Entry{}, false, err
+	}
+
+	return entryFromItem(matches[0]), true, nil
+}
+
+// Get reads the passphrase of the best ranked item with label
+func (keychainStore) Get(label, keygrip string) (*secret.Buffer, error) {
+	return passwordFromKeychain(label, keygrip)
+}
+
+// Put stores pin in the item of e.Keygrip, the keychain sets the dates of the item
+func (keychainStore) Put(e store.Entry, pin []byte) error {
+	return updatePasswordInKeychain(e.Label, e.Keygrip, pin)
+}
+
+// Delete removes the item of e.Keygrip, the one with the label of e if the entry imported from
+// pinentry-mac and the original are both kept
+func (keychainStore) Delete(e store.Entry) error {
+	// an empty account would match every item of the service
+	if e.Keygrip == "" {
+		return store.ErrNotFound
+	}
+
+	for _, account := range []string{e.Keygrip + keptAccountSuffix, e.Keygrip} {
+		query := keychain.NewItem()
+		query.SetSecClass(keychain.SecClassGenericPassword)
+		query.SetService(keychainService)
+		query.SetAccount(account)
+		if e.Label != "" {
+			query.SetLabel(e.Label)
+		}
+
+		err := keychain.DeleteItem(query)
+		if err != keychain.ErrorItemNotFound {
+			return err
+		}
+	}
+
+	return store.ErrNotFound
+}
+
+// List returns every item of the GnuPG service, including the ones saved by pinentry-mac
+func (keychainStore) List() ([]store.Entry, error) {
+	query := keychain.NewItem()
+	query.SetSecClass(keychain.SecClassGenericPassword)
+	query.SetService(keychainService)
+	query.SetMatchLimit(keychain.MatchLimitAll)
+	query.SetReturnAttributes(true)
+
+	results, err := keychain.QueryItem(query)
+	if err != nil && err != keychain.ErrorItemNotFound {
+		return nil, err
+	}
+
+	entries := make([]store.Entry, 0, len(results))
+	for _, item := range results {
+		entries = append(entries, entryFromItem(item))
+	}
+	store.Sort(entries)
+
+	return entries, nil
+}
+
+// storeFromName returns the backend with the given name
+func storeFromName(name string) (store.Store, error) {
+	switch name {
+	case keychainBackend:
+		return keychainStore{}, nil
+	case secretServiceBackend:
+		return secretservice.Store{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown backend %q, expected %s or %s", name, keychainBackend, secretServiceBackend)
+}
@@ -0,0 +1,18 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+//
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package lockout
+
+import "os"
+
+// lockFile is a no-op, updates are only serialized within the process
+func lockFile(*os.File) error {
+	return nil
+}
+
+// unlockFile is a no-op
+func unlockFile(*os.File) {}
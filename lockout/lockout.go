@@ -0,0 +1,258 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package lockout limits repeated failed authentications. Failures are counted per key and
+// persisted, after too many of them the key is locked for a cool-down that doubles with every
+// further failure. While a key is locked its PIN is not released from the keychain.
+package lockout
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/internal/jsonfile"
+)
+
+const (
+	// DefaultFilename is the name of the file where the failures are persisted
+	DefaultFilename = "pinentry-touchid-lockout.json"
+	// DefaultMaxFailures is the number of failures allowed before a key is locked
+	DefaultMaxFailures = 5
+	// DefaultCooldown is the duration of the first lockout
+	DefaultCooldown = time.Minute
+	// DefaultMaxCooldown caps the duration of a lockout
+	DefaultMaxCooldown = 24 * time.Hour
+)
+
+// Policy configures when and for how long keys are locked
+type Policy struct {
+	// MaxFailures before a key is locked, lockout is disabled if zero or negative
+	MaxFailures int
+	// Cooldown is the duration of the first lockout, it doubles with each further failure
+	Cooldown time.Duration
+	// MaxCooldown caps the duration of a lockout
+	MaxCooldown time.Duration
+}
+
+// DefaultPolicy returns the policy used when nothing is configured
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxFailures: DefaultMaxFailures,
+		Cooldown:    DefaultCooldown,
+		MaxCooldown: DefaultMaxCooldown,
+	}
+}
+
+// cooldown returns the lockout duration after the given number of failures
+func (p Policy) cooldown(failures int) time.Duration {
+	d := p.Cooldown
+	for i := p.MaxFailures; i < failures && d < p.MaxCooldown; i++ {
+		d *= 2
+	}
+
+	if p.MaxCooldown > 0 && d > p.MaxCooldown {
+		return p.MaxCooldown
+	}
+
+	return d
+}
+
+// State holds the failures of a key
+type State struct {
+	// Label of the key, kept to show which keys are locked
+	Label       string    `json:"label,omitempty"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until,omitempty"`
+}
+
+// Store persists the state of every key in a JSON file
+type Store struct {
+	path   string
+	policy Policy
+	now    func() time.Time
+	mu     sync.Mutex
+}
+
+// NewStore returns a store that keeps its state in the file at path
+func NewStore(path string, policy Policy) *Store {
+	return &Store{path: path, policy: policy, now: time.Now}
+}
+
+// lock serializes the updates of the state, also with other pinentry processes. The lock is taken
+// on a file next to the state, which is replaced on every save.
+func (s *Store) lock() (unlock func(), err error) {
+	s.mu.Lock()
+
+	f, err := os.OpenFile(s.path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+
+	if err := lockFile(f); err != nil {
+		f.Close()
+		s.mu.Unlock()
+		return nil, err
+	}
+
+	return func() {
+		unlockFile(f)
+		f.Close()
+		s.mu.Unlock()
+	}, nil
+}
+
+func (s *Store) load() (map[string]State, error) {
+	states := make(map[string]State)
+	if err := jsonfile.Load(s.path, &states); err != nil {
+		return nil, err
+	}
+
+	return states, nil
+}
+
+func (s *Store) save(states map[string]State) error {
+	return jsonfile.Save(s.path, states)
+}
+
+// Locked returns the remaining cool-down of key, zero if the key is not locked
+func (s *Store) Locked(key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states, err := s.load()
+	if err != nil {
+		return 0, err
+	}
+
+	if remaining := states[key].LockedUntil.Sub(s.now()); remaining > 0 {
+		return remaining, nil
+	}
+
+	return 0, nil
+}
+
+// Failure records a failed authentication for key and locks it once the policy allows no more
+// failures
+func (s *Store) Failure(key, label string) (State, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return State{}, err
+	}
+	defer unlock()
+
+	states, err := s.load()
+	if err != nil {
+		return State{}, err
+	}
+
+	state := states[key]
+	state.Label = label
+	state.Failures++
+	state.LastFailure = s.now()
+	if s.policy.MaxFailures > 0 && state.Failures >= s.policy.MaxFailures {
+		state.LockedUntil = state.LastFailure.Add(s.policy.cooldown(state.Failures))
+	}
+	states[key] = state
+
+	return state, s.save(states)
+}
+
+// Reset forgets the failures of key, or of every key if key is empty. It returns the number of
+// keys that were reset.
+func (s *Store) Reset(key string) (int, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	states, err := s.load()
+	if err != nil {
+		return 0, err
+	}
+
+	n := len(states)
+	if key == "" {
+		states = map[string]State{}
+	} else if _, ok := states[key]; ok {
+		delete(states, key)
+		n = 1
+	} else {
+		return 0, nil
+	}
+
+	if n == 0 {
+		return 0, nil
+	}
+
+	return n, s.save(states)
+}
+
+// States returns the state of every key with failures
+func (s *Store) States() (map[string]State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.load()
+}
+
+// Authenticator enforces the lockout policy around another authenticator. A locked key is
+// reported as auth.LockedOut without prompting the user, so the PIN has to be typed.
+type Authenticator struct {
+	Store *Store
+	Next  auth.Authenticator
+}
+
+// failed reports whether result counts as a failed authentication
+func failed(result auth.Result) bool {
+	switch result {
+	case auth.Declined, auth.Mismatch, auth.Timeout:
+		return true
+	}
+
+	return false
+}
+
+// Authenticate checks the lockout of the key before calling the next authenticator and records
+// its result
+func (a Authenticator) Authenticate(ctx context.Context, req auth.Request) (auth.Result, error) {
+	key := req.Key.KeyInfo
+	if key == "" {
+		key = req.Key.Label
+	}
+
+	remaining, err := a.Store.Locked(key)
+	if err != nil {
+		return auth.Failed, err
+	}
+	if remaining > 0 {
+		return auth.LockedOut, lockedError(remaining)
+	}
+
+	result, err := a.Next.Authenticate(ctx, req)
+	switch {
+	case result == auth.Success:
+		if _, storeErr := a.Store.Reset(key); storeErr != nil {
+			return auth.Failed, storeErr
+		}
+	case failed(result):
+		if _, storeErr := a.Store.Failure(key, req.Key.Label); storeErr != nil {
+			return auth.Failed, storeErr
+		}
+	}
+
+	return result, err
+}
+
+// lockedError describes the remaining cool-down of a key
+type lockedError time.Duration
+
+func (e lockedError) Error() string {
+	return "too many failed attempts, locked for " + time.Duration(e).Round(time.Second).String()
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package lockout
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jorgelbg/pinentry-touchid/auth"
+)
+
+// testStore returns a store with a clock that can be moved forward with the returned function
+func testStore(t *testing.T, policy Policy) (*Store, func(time.Duration)) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewStore(filepath.Join(t.TempDir(), DefaultFilename), policy)
+	s.now = func() time.Time { return now }
+
+	return s, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestCooldown(t *testing.T) {
+	p := Policy{MaxFailures: 3, Cooldown: time.Minute, MaxCooldown: 10 * time.Minute}
+
+	for failures, want := range map[int]time.Duration{
+		3: time.Minute,
+		4: 2 * time.Minute,
+		5: 4 * time.Minute,
+		6: 8 * time.Minute,
+		7: 10 * time.Minute,
+		9: 10 * time.Minute,
+	} {
+		if got := p.cooldown(failures); got != want {
+			t.Fatalf("cooldown after %d failures got: %s want: %s", failures, got, want)
+		}
+	}
+}
+
+func TestLockout(t *testing.T) {
+	s, advance := testStore(t, Policy{MaxFailures: 2, Cooldown: time.Minute, MaxCooldown: time.Hour})
+	scripted := auth.NewScripted(auth.Declined, auth.Mismatch, auth.Success)
+	a := Authenticator{Store: s, Next: scripted}
+	req := auth.Request{Key: auth.Key{Label: "test", KeyInfo: "n/KEY"}}
+
+	for _, want := range []auth.Result{auth.Declined, auth.Mismatch, auth.LockedOut} {
+		if got, _ := a.Authenticate(context.Background(), req); got != want {
+			t.Fatalf("result mismatch got: %s want: %s", got, want)
+		}
+	}
+
+	if len(scripted.Requests()) != 2 {
+		t.Fatalf("a locked key should not prompt the user")
+	}
+
+	// the state survives a new store using the same file
+	reopened := NewStore(s.path, s.policy)
+	reopened.now = s.now
+	if remaining, _ := reopened.Locked("n/KEY"); remaining != time.Minute {
+		t.Fatalf("the lockout should be persisted, remaining: %s", remaining)
+	}
+
+	advance(time.Minute)
+	if got, _ := a.Authenticate(context.Background(), req); got != auth.Success {
+		t.Fatalf("the key should be usable after the cool-down, got: %s", got)
+	}
+
+	if states, _ := s.States(); len(states) != 0 {
+		t.Fatalf("a successful authentication should reset the failures: %+v", states)
+	}
+}
+
+func TestConcurrentFailures(t *testing.T) {
+	s, _ := testStore(t, Policy{})
+
+	// every pinentry process has a store of its own
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			other := NewStore(s.path, s.policy)
+			for j := 0; j < 10; j++ {
+				if _, err := other.Failure("n/KEY", "test"); err != nil {
+					t.Errorf("recording a failure should succeed: %s", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if states, err := s.States(); err != nil || states["n/KEY"].Failures != 40 {
+		t.Fatalf("no failure should be lost: %+v (%v)", states, err)
+	}
+}
+
+func TestReset(t *testing.T) {
+	s, _ := testStore(t, Policy{MaxFailures: 1, Cooldown: time.Hour})
+
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err := s.Failure(key, key); err != nil {
+			t.Fatalf("recording a failure should succeed: %s", err)
+		}
+	}
+
+	if n, err := s.Reset("a"); n != 1 || err != nil {
+		t.Fatalf("resetting a key should succeed, got: %d %v", n, err)
+	}
+
+	if remaining, _ := s.Locked("a"); remaining != 0 {
+		t.Fatalf("the key should be unlocked after a reset")
+	}
+
+	if n, err := s.Reset(""); n != 2 || err != nil {
+		t.Fatalf("resetting every key should succeed, got: %d %v", n, err)
+	}
+}
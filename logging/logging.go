@@ -0,0 +1,245 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package logging writes levelled log records with key/value fields, as text or JSON, to a file
+// that is rotated by size, the standard error, syslog or nowhere at all.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a record, records below the level of a Logger are dropped
+type Level int
+
+const (
+	// LevelDebug records details only useful while debugging
+	LevelDebug Level = iota
+	// LevelInfo records the normal operation, it is the default level
+	LevelInfo
+	// LevelWarn records unexpected conditions that don't fail a request
+	LevelWarn
+	// LevelError records failed requests
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l >= LevelDebug && int(l) < len(levelNames) {
+		return levelNames[l]
+	}
+
+	return strconv.Itoa(int(l))
+}
+
+// ParseLevel returns the level with the given name
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return Level(i), nil
+		}
+	}
+
+	return LevelInfo, fmt.Errorf("unknown log level %q, expected one of %s", name,
+		strings.Join(levelNames, ", "))
+}
+
+// Format is how records are written
+type Format int
+
+const (
+	// FormatText writes a line with the time, level, message and key=value fields
+	FormatText Format = iota
+	// FormatJSON writes a JSON object per line
+	FormatJSON
+)
+
+// ParseFormat returns the format with the given name: text or json
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "text":
+		return FormatText, nil
+	case "json":
+		return FormatJSON, nil
+	}
+
+	return FormatText, fmt.Errorf("unknown log format %q, expected text or json", name)
+}
+
+// output is the destination shared by a Logger and the loggers derived from it with With
+type output struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Logger writes records to an io.Writer, it is safe for concurrent use. A nil Logger discards every
+// record.
+type Logger struct {
+	out    *output
+	level  Level
+	format Format
+	fields []interface{}
+	now    func() time.Time
+}
+
+// New returns a Logger that writes the records of level and above to w
+func New(w io.Writer, level Level, format Format) *Logger {
+	return &Logger{
+		out:    &output{w: w},
+		level:  level,
+		format: format,
+		now:    time.Now,
+	}
+}
+
+// Stderr returns a Logger that writes text records to the standard error, it is used when the
+// configured sink can't be opened
+func Stderr() *Logger {
+	return New(os.Stderr, LevelInfo, FormatText)
+}
+
+// With returns a Logger that adds the key/value pairs in kv to every record
+func (l *Logger) With(kv ...interface{}) *Logger {
+	if l == nil {
+		return nil
+	}
+
+	c := *l
+	c.fields = append(append([]interface{}(nil), l.fields...), kv...)
+	return &c
+}
+
+// Enabled reports whether records of level are written
+func (l *Logger) Enabled(level Level) bool {
+	return l != nil && level >= l.level
+}
+
+// Debug writes a record with LevelDebug
+func (l *Logger) Debug(msg string, kv ...interface{}) {
+	l.log(LevelDebug, msg, kv)
+}
+
+// Info writes a record with LevelInfo
+func (l *Logger) Info(msg string, kv ...interface{}) {
+	l.log(LevelInfo, msg, kv)
+}
+
+// Warn writes a record with LevelWarn
+func (l *Logger) Warn(msg string, kv ...interface{}) {
+	l.log(LevelWarn, msg, kv)
+}
+
+// Error writes a record with LevelError
+func (l *Logger) Error(msg string, kv ...interface{}) {
+	l.log(LevelError, msg, kv)
+}
+
+// log formats and writes a record, failing to write it is ignored
+func (l *Logger) log(level Level, msg string, kv []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	fields := append(append([]interface{}(nil), l.fields...), kv...)
+	// a key without a value is kept rather than dropped silently
+	if len(fields)%2 != 0 {
+		fields = append(fields, "(missing)")
+	}
+
+	var line []byte
+	if l.format == FormatJSON {
+		line = l.json(level, msg, fields)
+	} else {
+		line = l.text(level, msg, fields)
+	}
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	_, _ = l.out.w.Write(line)
+}
+
+// value returns how v is written, errors and Stringers are written with their text
+func value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case error:
+		if v == nil {
+			return nil
+		}
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	}
+
+	return v
+}
+
+// text formats a record as a single line
+func (l *Logger) text(level Level, msg string, fields []interface{}) []byte {
+	var b strings.Builder
+	b.WriteString(l.now().Format(time.RFC3339))
+	b.WriteByte(' ')
+	b.WriteString(strings.ToUpper(level.String()))
+	b.WriteByte(' ')
+	b.WriteString(quote(msg))
+
+	for i := 0; i < len(fields); i += 2 {
+		b.WriteByte(' ')
+		b.WriteString(fmt.Sprint(fields[i]))
+		b.WriteByte('=')
+		b.WriteString(quote(fmt.Sprint(value(fields[i+1]))))
+	}
+	b.WriteByte('\n')
+
+	return []byte(b.String())
+}
+
+// quote quotes s if it contains spaces, quotes or control characters so every record stays on one
+// line
+func quote(s string) string {
+	if s == "" {
+		return `""`
+	}
+
+	for _, r := range s {
+		if r <= ' ' || r == '"' || r == '=' || r == 0x7f {
+			return strconv.Quote(s)
+		}
+	}
+
+	return s
+}
+
+// json formats a record as a JSON object
+func (l *Logger) json(level Level, msg string, fields []interface{}) []byte {
+	record := map[string]interface{}{
+		"time":  l.now().Format(time.RFC3339),
+		"level": level.String(),
+		"msg":   msg,
+	}
+	for i := 0; i < len(fields); i += 2 {
+		record[fmt.Sprint(fields[i])] = value(fields[i+1])
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		line, _ = json.Marshal(map[string]interface{}{
+			"time":  record["time"],
+			"level": record["level"],
+			"msg":   msg,
+			"error": "the fields of the record can't be encoded: " + err.Error(),
+		})
+	}
+
+	return append(line, '\n')
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fixed returns a logger that writes to b at a fixed time
+func fixed(b *bytes.Buffer, level Level, format Format) *Logger {
+	l := New(b, level, format)
+	l.now = func() time.Time { return time.Date(2021, 4, 1, 10, 0, 0, 0, time.UTC) }
+	return l
+}
+
+func TestText(t *testing.T) {
+	var b bytes.Buffer
+	l := fixed(&b, LevelInfo, FormatText).With("session", 1)
+
+	l.Debug("dropped")
+	l.Info("Authenticated", "label", "Name <email> (KEYID)", "result", "success")
+	l.Error("Failed", "err", errors.New("no\nway"), "odd")
+
+	want := `2021-04-01T10:00:00Z INFO Authenticated session=1 label="Name <email> (KEYID)" result=success
+2021-04-01T10:00:00Z ERROR Failed session=1 err="no\nway" odd=(missing)
+`
+	if b.String() != want {
+		t.Fatalf("unexpected records:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var b bytes.Buffer
+	fixed(&b, LevelDebug, FormatJSON).Debug("Reading", "timeout", time.Minute, "count", 2)
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &record); err != nil {
+		t.Fatalf("the record should be valid JSON: %s: %q", err, b.String())
+	}
+
+	if record["level"] != "debug" || record["msg"] != "Reading" || record["timeout"] != "1m0s" || record["count"] != 2.0 {
+		t.Fatalf("unexpected record: %v", record)
+	}
+}
+
+func TestNil(t *testing.T) {
+	var l *Logger
+	// a nil logger discards the records
+	l.With("key", "value").Info("discarded")
+	if l.Enabled(LevelError) {
+		t.Fatalf("a nil logger should not be enabled")
+	}
+}
+
+func TestParse(t *testing.T) {
+	if level, err := ParseLevel("WARN"); err != nil || level != LevelWarn {
+		t.Fatalf("unexpected level: %s (%v)", level, err)
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Fatalf("an unknown level should be rejected")
+	}
+	if format, err := ParseFormat("json"); err != nil || format != FormatJSON {
+		t.Fatalf("unexpected format: %d (%v)", format, err)
+	}
+	if _, err := ParseSink("journal"); err == nil {
+		t.Fatalf("an unknown sink should be rejected")
+	}
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", DefaultFilename)
+
+	// a file created by an older version is made private
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l, closer, err := Open(Options{Path: path, MaxSize: 20, MaxBackups: 2})
+	if err != nil {
+		t.Fatalf("opening the log file should succeed: %s", err)
+	}
+	defer closer.Close()
+
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("the log file should only be accessible by the user: %v (%v)", info, err)
+	}
+
+	for i := 0; i < 4; i++ {
+		l.Info("record")
+	}
+
+	// every record is bigger than the maximum size: one file per record, the oldest are removed
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		b, err := ioutil.ReadFile(name)
+		if err != nil || strings.Count(string(b), "record") != 1 {
+			t.Fatalf("%s: unexpected contents %q (%v)", name, b, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("only two rotated files should be kept")
+	}
+}
+
+func TestOpenOff(t *testing.T) {
+	l, closer, err := Open(Options{Sink: SinkOff})
+	if err != nil || l != nil || closer == nil {
+		t.Fatalf("the off sink should return a nil logger: %v (%v)", l, err)
+	}
+}
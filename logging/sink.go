@@ -0,0 +1,222 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// DefaultFilename is the name of the log file
+	DefaultFilename = "pinentry-touchid.log"
+	// DefaultMaxSize is the size (in bytes) of the log file before it is rotated
+	DefaultMaxSize = 10 << 20
+	// DefaultMaxBackups is the number of rotated log files that are kept
+	DefaultMaxBackups = 3
+)
+
+// Sink is where the records are written
+type Sink string
+
+const (
+	// SinkFile writes to a file that is rotated by size, it is the default
+	SinkFile Sink = "file"
+	// SinkStderr writes to the standard error, the gpg-agent forwards it to its own log
+	SinkStderr Sink = "stderr"
+	// SinkSyslog writes to the system log
+	SinkSyslog Sink = "syslog"
+	// SinkOff discards every record
+	SinkOff Sink = "off"
+)
+
+// ParseSink returns the sink with the given name
+func ParseSink(name string) (Sink, error) {
+	switch s := Sink(name); s {
+	case SinkFile, SinkStderr, SinkSyslog, SinkOff:
+		return s, nil
+	}
+
+	return "", fmt.Errorf("unknown log sink %q, expected file, stderr, syslog or off", name)
+}
+
+// Options configure the Logger returned by Open, the zero value writes text records of LevelInfo
+// to the file at DefaultPath
+type Options struct {
+	Sink   Sink
+	Level  Level
+	Format Format
+	// Path of the log file, DefaultPath if empty
+	Path string
+	// MaxSize of the log file in bytes, DefaultMaxSize if zero
+	MaxSize int64
+	// MaxBackups is the number of rotated files kept, DefaultMaxBackups if zero
+	MaxBackups int
+}
+
+// DefaultPath returns the location of the log file in the cache directory of the user
+func DefaultPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "pinentry-touchid", DefaultFilename), nil
+}
+
+// Open returns a Logger that writes to the configured sink. The returned io.Closer releases the
+// sink, it is never nil.
+func Open(opts Options) (*Logger, io.Closer, error) {
+	var (
+		w   io.WriteCloser
+		err error
+	)
+
+	switch opts.Sink {
+	case SinkFile, "":
+		path := opts.Path
+		if path == "" {
+			if path, err = DefaultPath(); err != nil {
+				return nil, nopCloser{}, err
+			}
+		}
+		w, err = OpenFile(path, opts.MaxSize, opts.MaxBackups)
+	case SinkStderr:
+		return New(os.Stderr, opts.Level, opts.Format), nopCloser{}, nil
+	case SinkSyslog:
+		w, err = openSyslog()
+	case SinkOff:
+		return nil, nopCloser{}, nil
+	default:
+		err = fmt.Errorf("unknown log sink %q", opts.Sink)
+	}
+
+	if err != nil {
+		return nil, nopCloser{}, err
+	}
+
+	return New(w, opts.Level, opts.Format), w, nil
+}
+
+// nopCloser is returned by Open for sinks that don't have to be released
+type nopCloser struct{}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// File is a log file that is rotated once it grows over its maximum size: the current file is
+// renamed with a .1 suffix, the previous .1 becomes .2 and so on, the oldest one is removed.
+type File struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// OpenFile opens the log file at path for appending. The file and its directory are only
+// accessible by the user.
+func OpenFile(path string, maxSize int64, maxBackups int) (*File, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	if maxBackups <= 0 {
+		maxBackups = DefaultMaxBackups
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	file := &File{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := file.open(); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// open opens (or creates) the file at f.path
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	// files created by older versions may be readable by others
+	if info.Mode().Perm() != 0600 {
+		_ = file.Chmod(0600)
+	}
+
+	f.f, f.size = file, info.Size()
+	return nil
+}
+
+// rotate renames the current file and opens a new one. If the file can't be renamed it is opened
+// again and keeps growing, so no records are lost.
+func (f *File) rotate() error {
+	_ = f.f.Close()
+
+	_ = os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxBackups))
+	for i := f.maxBackups - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+	}
+	_ = os.Rename(f.path, f.path+".1")
+
+	if err := f.open(); err != nil {
+		f.f = nil
+		return err
+	}
+
+	return nil
+}
+
+// Write appends p to the file, rotating it first if p doesn't fit
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.f == nil {
+		return 0, os.ErrClosed
+	}
+
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.f.Write(p)
+	f.size += int64(n)
+
+	return n, err
+}
+
+// Close closes the file
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.f == nil {
+		return nil
+	}
+
+	err := f.f.Close()
+	f.f = nil
+
+	return err
+}
@@ -8,56 +8,57 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/fs"
-	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/enescakir/emoji"
 	"github.com/foxcpp/go-assuan/common"
 	"github.com/foxcpp/go-assuan/pinentry"
 	pinentryBinary "github.com/gopasspw/pinentry"
+	"github.com/jorgelbg/pinentry-touchid/audit"
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/config"
+	"github.com/jorgelbg/pinentry-touchid/logging"
+	"github.com/jorgelbg/pinentry-touchid/metadata"
+	"github.com/jorgelbg/pinentry-touchid/passgen"
+	"github.com/jorgelbg/pinentry-touchid/policy"
+	"github.com/jorgelbg/pinentry-touchid/secret"
 	"github.com/jorgelbg/pinentry-touchid/sensor"
+	"github.com/jorgelbg/pinentry-touchid/store"
+	"github.com/jorgelbg/pinentry-touchid/tty"
 	"github.com/keybase/go-keychain"
 	touchid "github.com/lox/go-touchid"
 )
 
-// AuthFunc is a function that runs some check to verify if the caller has access to the Keychain
-// entry
-type AuthFunc func(string) (bool, error)
-
 // PromptFunc is a function that asks a password from the user
 type PromptFunc func(pinentry.Settings) ([]byte, error)
 
 // GetPinFunc is a function that executes the process for getting a password from the Keychain
-type GetPinFunc func(pinentry.Settings) (string, *common.Error)
-
-const (
-	// DefaultLogFilename default name for the log files
-	DefaultLogFilename = "pinentry-touchid.log"
-	defaultLoggerFlags = log.Ldate | log.Ltime | log.Lshortfile
-)
+type GetPinFunc func(pinentry.Settings) ([]byte, *common.Error)
 
 var (
-	// DefaultLogLocation is the location of the log file
-	DefaultLogLocation = filepath.Join(filepath.Clean(os.TempDir()), DefaultLogFilename)
+	// version is set at build time
+	version = "dev"
 
 	emailRegex    = regexp.MustCompile(`\"(?P<name>.*<(?P<email>.*)>)\"`)
 	keyIDRegex    = regexp.MustCompile(`ID (?P<keyId>.*),`) // keyID should be of exactly 8 or 16 characters
 	sshKeyIDRegex = regexp.MustCompile(`SHA256:(?P<keyId>.*)`)
 
-	errEmptyResults    = errors.New("no matching entry was found")
-	errMultipleMatches = errors.New("multiple entries matched the query")
+	errEmptyResults = store.ErrNotFound
 
 	check      = flag.Bool("check", false, "Verify that pinentry-mac is present in the system.")
 	fixSymlink = flag.Bool("fix", false, "Set up pinentry-mac as the fallback PIN entry program.")
+	debug      = flag.Bool("debug", false, "Log every line exchanged with gpg-agent and pinentry-mac, PINs are redacted.")
 	_          = flag.String("display", "", "Set the X display (unused)")
 )
 
@@ -65,6 +66,10 @@ const (
 	expectedKeyLengthGPG     = 8
 	expectedKeyLengthFullGPG = 16
 	expectedKeyLengthSSH     = 43
+
+	// maxAuthAttempts is the number of times a failed match (e.g. unknown fingerprint) is retried
+	// before asking for the PIN
+	maxAuthAttempts = 3
 )
 
 // checkEntryInKeychain executes a search in the current keychain. The search configured to not
@@ -88,74 +93,145 @@ func checkEntryInKeychain(label string) (bool, error) {
 
 // KeychainClient represents a single instance of a pinentry server
 type KeychainClient struct {
-	logger   *log.Logger
-	authFn   AuthFunc
-	promptFn PromptFunc
+	logger        *logging.Logger
+	authenticator auth.Authenticator
+	promptFn      PromptFunc
+	generator     *passgen.Generator
+	entries       *metadata.Store
+	trail         *audit.Log
+	// tracer receives the lines exchanged with the gpg-agent, nil unless tracing is enabled
+	tracer common.Tracer
 }
 
 // New returns a new instance of KeychainClient with some sane defaults, a logger automatically
-// configured, an authFn that invokes Touch ID and a promptFn that fallbacks to the pinentry-mac
+// configured, an authenticator that invokes Touch ID and a promptFn that fallbacks to the pinentry-mac
 // program.
 func New() KeychainClient {
-	var logger *log.Logger
-	path := filepath.Clean(DefaultLogLocation)
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		file, err := os.Create(path)
-		if err != nil {
-			panic("Couldn't create log file")
-		}
+	cfg, cfgErr := config.Load(config.DefaultPath())
+	cfg.Trace = cfg.Trace || *debug
+
+	// the log is kept open until the process exits
+	logger, _ := loggerFromConfig(cfg)
+	logger.Info("Ready!", "version", version)
+
+	client := KeychainClient{
+		logger:        logger,
+		promptFn:      tracedPasswordPrompt(nil, logger),
+		authenticator: auth.Func(touchid.Authenticate),
+	}
+
+	if cfg.Trace {
+		var upstream common.Tracer
+		client.tracer, upstream = traceSession(logger)
+		client.promptFn = tracedPasswordPrompt(upstream, logger)
+	}
+
+	if cfgErr != nil {
+		logger.Warn("Error reading the configuration, using defaults", "path", config.DefaultPath(), "err", cfgErr)
+	}
 
-		logger = log.New(file, "", defaultLoggerFlags)
+	chain, err := authenticatorFromConfig(cfg)
+	if err != nil {
+		logger.Warn("Error configuring the authenticators, using Touch ID", "err", err)
 	} else {
-		// append to the existing log file
-		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		chain.Observe = func(name string, result auth.Result, err error) {
+			if err != nil {
+				logger.Info("Authenticator answered", "authenticator", name, "result", result, "err", err)
+				return
+			}
+			logger.Info("Authenticator answered", "authenticator", name, "result", result)
+		}
+		logger.Debug("Authenticators configured", "authenticators", chain)
+		client.authenticator = chain
+	}
+
+	client.entries = entriesFromConfig(cfg)
+	client.trail = auditFromConfig(cfg)
+	removePinentryMacItems = cfg.RemovePinentryMac
+
+	client.authenticator = wrapAuthenticator(cfg, client.authenticator, logger)
+
+	if cfg.GenPIN {
+		gen, err := generatorFromConfig(cfg)
 		if err != nil {
-			panic(err)
+			logger.Warn("Error configuring the passphrase generator", "err", err)
+		} else {
+			client.generator = &gen
 		}
+	}
+
+	return client
+}
 
-		logger = log.New(file, "", defaultLoggerFlags)
+// loggerFromConfig opens the configured log sink. Logging never fails a request: invalid options
+// are replaced by their defaults and if the sink can't be opened the records are written to the
+// standard error, which the gpg-agent forwards to its own log.
+func loggerFromConfig(cfg config.Config) (*logging.Logger, io.Closer) {
+	opts := logging.Options{
+		Level:      logging.LevelInfo,
+		Path:       cfg.LogFile,
+		MaxSize:    cfg.LogMaxSize,
+		MaxBackups: cfg.LogMaxFiles,
 	}
 
-	logger.Print("Ready!")
+	var errs []error
+	if cfg.LogSink != "" {
+		sink, err := logging.ParseSink(cfg.LogSink)
+		errs = append(errs, err)
+		opts.Sink = sink
+	}
+	if cfg.LogLevel != "" {
+		level, err := logging.ParseLevel(cfg.LogLevel)
+		errs = append(errs, err)
+		opts.Level = level
+	}
+	// the trace is written at the debug level
+	if cfg.Trace {
+		opts.Level = logging.LevelDebug
+	}
+	if cfg.LogFormat != "" {
+		format, err := logging.ParseFormat(cfg.LogFormat)
+		errs = append(errs, err)
+		opts.Format = format
+	}
 
-	return KeychainClient{
-		logger:   logger,
-		promptFn: passwordPrompt,
-		authFn:   touchid.Authenticate,
+	logger, closer, err := logging.Open(opts)
+	if err != nil {
+		logger = logging.Stderr()
+		logger.Warn("Error opening the log, using the standard error", "sink", opts.Sink, "err", err)
 	}
+
+	for _, err := range errs {
+		if err != nil {
+			logger.Warn("Invalid logging option", "err", err)
+		}
+	}
+
+	return logger, closer
 }
 
 // WithLogger allows to create a new instance of KeychainClient with a custom logger
-func WithLogger(logger *log.Logger) KeychainClient {
+func WithLogger(logger *logging.Logger) KeychainClient {
 	return KeychainClient{
-		logger:   logger,
-		promptFn: passwordPrompt,
-		authFn:   touchid.Authenticate,
+		logger:        logger,
+		promptFn:      tracedPasswordPrompt(nil, logger),
+		authenticator: auth.Func(touchid.Authenticate),
 	}
 }
 
-// passwordFromKeychain retrieves a password given a label from the Keychain
-func passwordFromKeychain(label string) (string, error) {
-	query := keychain.NewItem()
-	query.SetSecClass(keychain.SecClassGenericPassword)
-	query.SetLabel(label)
-	query.SetMatchLimit(keychain.MatchLimitOne)
-	query.SetReturnData(true)
-
-	results, err := keychain.QueryItem(query)
+// passwordFromKeychain retrieves a password given a label from the Keychain. When several items
+// share the label the one that most likely belongs to keygrip is used.
+func passwordFromKeychain(label, keygrip string) (*secret.Buffer, error) {
+	matches, err := keychainMatches(label, keygrip)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	if len(results) == 0 {
-		return "", errEmptyResults
+	if len(matches) == 0 {
+		return nil, errEmptyResults
 	}
 
-	if len(results) > 1 {
-		return "", errMultipleMatches
-	}
-
-	return string(results[0].Data), nil
+	return keychainData(matches[0])
 }
 
 // storePasswordInKeychain saves a password/pin in the keychain with the given label
@@ -163,9 +239,10 @@ func passwordFromKeychain(label string) (string, error) {
 func storePasswordInKeychain(label, keyInfo string, pin []byte) error {
 	item := keychain.NewItem()
 	item.SetSecClass(keychain.SecClassGenericPassword)
-	item.SetService("GnuPG")
+	item.SetService(keychainService)
 	item.SetAccount(keyInfo)
 	item.SetLabel(label)
+	item.SetDescription(keychainDescription)
 	item.SetData(pin)
 	item.SetSynchronizable(keychain.SynchronizableNo)
 	item.SetAccessible(keychain.AccessibleWhenUnlocked)
@@ -177,68 +254,185 @@ func storePasswordInKeychain(label, keyInfo string, pin []byte) error {
 	return nil
 }
 
-// passwordPrompt uses the default pinentry-mac program for getting the password from the user
+// updatePasswordInKeychain replaces the password/pin of the keychain item for the given keyInfo,
+// the item is created if it doesn't exist yet
+func updatePasswordInKeychain(label, keyInfo string, pin []byte) error {
+	// an entry imported from pinentry-mac next to the original has an account of its own
+	if !strings.HasSuffix(keyInfo, keptAccountSuffix) {
+		err := updateKeychainItem(label, keyInfo+keptAccountSuffix, pin)
+		if err != keychain.ErrorItemNotFound {
+			return err
+		}
+	}
+
+	err := storePasswordInKeychain(label, keyInfo, pin)
+	if err != keychain.ErrorDuplicateItem {
+		return err
+	}
+
+	return updateKeychainItem(label, keyInfo, pin)
+}
+
+// updateKeychainItem sets the label and the password/pin of the keychain item of account
+func updateKeychainItem(label, account string, pin []byte) error {
+	query := keychain.NewItem()
+	query.SetSecClass(keychain.SecClassGenericPassword)
+	query.SetService(keychainService)
+	query.SetAccount(account)
+
+	update := keychain.NewItem()
+	update.SetLabel(label)
+	update.SetData(pin)
+
+	return keychain.UpdateItem(query, update)
+}
+
+// passwordPrompt uses the default pinentry-mac program for getting the password from the user. If
+// the program can't be started the password is requested from the terminal instead.
 func passwordPrompt(s pinentry.Settings) ([]byte, error) {
-	p, err := pinentryBinary.New()
+	return tracedPasswordPrompt(nil, nil)(s)
+}
+
+// tracedPasswordPrompt returns a passwordPrompt that passes the lines exchanged with pinentry-mac
+// to tracer and the problems of the terminal prompt to logger, both may be nil
+func tracedPasswordPrompt(tracer common.Tracer, logger *logging.Logger) PromptFunc {
+	return func(s pinentry.Settings) ([]byte, error) {
+		return promptPinentry(s, tracer, logger)
+	}
+}
+
+// ttyWarning logs the problems of the terminal prompt that don't stop it
+func ttyWarning(logger *logging.Logger) func(msg string, err error) {
+	return func(msg string, err error) {
+		logger.Warn(msg, "err", err)
+	}
+}
+
+// promptPinentry asks the password with pinentry-mac, see passwordPrompt
+func promptPinentry(s pinentry.Settings, tracer common.Tracer, logger *logging.Logger) ([]byte, error) {
+	p, err := pinentry.LaunchTraced(pinentryBinary.GetBinary(), promptTracer(s, tracer))
 	if err != nil {
-		return []byte{}, fmt.Errorf("failed to start %q: %w", pinentryBinary.GetBinary(), err)
+		t, ttyErr := tty.Open(s.Opts.TTYName)
+		if ttyErr != nil {
+			return []byte{}, fmt.Errorf("%w: failed to start %q: %s", errNoPinentry, pinentryBinary.GetBinary(), err)
+		}
+		defer t.Close()
+
+		if logger != nil {
+			t.Warn = ttyWarning(logger)
+		}
+		return t.GetPIN(s)
 	}
-	defer p.Close()
+	defer p.Shutdown()
 
-	p.Set("title", "pinentry-touchid PIN Prompt")
+	p.SetTitle("pinentry-touchid PIN Prompt")
 
 	// passthrough the original description that its used for creating the keychain item
-	p.Set("desc", strings.ReplaceAll(s.Desc, "\n", "\\n"))
+	p.SetDesc(s.Desc)
+	if s.Error != "" {
+		p.SetError(s.Error)
+	}
 
 	// Enable opt-in external PIN caching (in the OS keychain).
 	// https://gist.github.com/mdeguzis/05d1f284f931223624834788da045c65#file-info-pinentry-L324
 	//
 	// Ideally if this option was not set, pinentry-mac should hide the `Save in Keychain`
 	// checkbox, but this is not the case.
-	// p.Option("allow-external-password-cache")
-	p.Set("KEYINFO", s.KeyInfo)
+	// p.Session.Option("allow-external-password-cache", "")
+	_, _ = p.Session.SimpleCmd("SETKEYINFO", s.KeyInfo)
 	if s.Prompt != "" {
-		p.Set("PROMPT", s.Prompt)
+		p.SetPrompt(s.Prompt)
 	} else {
 		// set "PIN" as the default prompt
-		p.Set("PROMPT", "PIN")
+		p.SetPrompt("PIN")
 	}
 	if s.RepeatPrompt != "" {
-		p.Set("REPEAT", s.RepeatPrompt)
+		p.SetRepeatPrompt(s.RepeatPrompt)
+	}
+	p.SetRepeatError(s.RepeatError)
+
+	// the quality of the passphrase is rated by the gpg-agent, pinentry-mac inquires it through us
+	if s.QualityBar != "" && s.PasswordQuality != nil {
+		p.SetQualityBar(s.QualityBar)
+		p.SetPasswdQualityCallback(s.PasswordQuality)
+	}
+
+	pin, pinErr := p.GetPIN(s)
+	if pinErr != nil {
+		return []byte{}, pinErr
 	}
-	p.Set("REPEATERROR", s.RepeatError)
 
-	return p.GetPin()
+	return pin, nil
 }
 
-func assuanError(err error) *common.Error {
-	return &common.Error{
-		Src:     common.ErrSrcPinentry,
-		SrcName: "pinentry",
-		Code:    common.ErrCanceled,
-		Message: err.Error(),
+// authenticate asks the user to authenticate for accessing the keychain item with the given label.
+// The request honours the timeout set by the gpg-agent and failed matches are retried up to
+// maxAuthAttempts times.
+func authenticate(authenticator auth.Authenticator, s pinentry.Settings, reason, label string) (auth.Result, error) {
+	return authenticateEvent(authenticator, s, reason, label, &audit.Record{})
+}
+
+// authenticateEvent is authenticate that also describes the authentication in event: the
+// authenticator that answered (empty if unknown, e.g. the key is locked out), the result and how
+// long it took
+func authenticateEvent(authenticator auth.Authenticator, s pinentry.Settings, reason, label string,
+	event *audit.Record) (auth.Result, error) {
+	ctx := auth.WithObserver(context.Background(), func(name string, _ auth.Result, _ error) {
+		event.Method = name
+	})
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	req := auth.Request{
+		Reason: reason,
+		Key:    auth.Key{Label: label, KeyInfo: s.KeyInfo},
+		Caller: auth.Caller{Owner: s.Opts.Owner, TTYName: s.Opts.TTYName},
+	}
+
+	var (
+		result auth.Result
+		err    error
+	)
+	start := time.Now()
+	for i := 0; i < maxAuthAttempts; i++ {
+		result, err = authenticator.Authenticate(ctx, req)
+		if result != auth.Mismatch {
+			break
+		}
 	}
+	event.Result, event.Latency = result.String(), time.Since(start)
+
+	return result, err
 }
 
-// GetPIN executes the main logic for returning a password/pin back to the gpg-agent
-func (c KeychainClient) GetPIN(s pinentry.Settings) (string, *common.Error) {
+// GetPIN executes the main logic for returning a password/pin back to the gpg-agent. The pinentry
+// server wipes the returned PIN once it is sent.
+func (c KeychainClient) GetPIN(s pinentry.Settings) ([]byte, *common.Error) {
 	if len(s.Error) == 0 && len(s.RepeatPrompt) == 0 && s.Opts.AllowExtPasswdCache && len(s.KeyInfo) != 0 {
-		return GetPIN(c.authFn, c.promptFn, c.logger)(s)
+		return GetPIN(c.authenticator, c.promptFn, c.entries, c.trail, c.logger)(s)
+	}
+
+	// gpg-agent is asking for a new passphrase and allows generating one
+	if c.generator != nil && len(s.GenPIN) != 0 && len(s.RepeatPrompt) != 0 {
+		return GeneratePIN(c.authenticator, c.promptFn, *c.generator, c.entries, c.logger)(s)
 	}
 
 	// fallback to pinentry-mac in any other case
 	pin, err := c.promptFn(s)
 	if err != nil {
-		return "", assuanError(err)
+		return nil, assuanError(err)
 	}
 
 	// TODO(jorge): try to persist automatically in the keychain?
-	return string(pin), nil
+	return pin, nil
 }
 
 // Confirm Asks for confirmation, not implemented.
 func (c KeychainClient) Confirm(s pinentry.Settings) (bool, *common.Error) {
-	c.logger.Println("Confirm was called!")
+	c.logger.Debug("Confirm was called")
 
 	if _, err := c.promptFn(s); err != nil {
 		return false, assuanError(err)
@@ -249,50 +443,80 @@ func (c KeychainClient) Confirm(s pinentry.Settings) (bool, *common.Error) {
 
 // Msg shows a message, not implemented.
 func (c KeychainClient) Msg(pinentry.Settings) *common.Error {
-	c.logger.Println("Msg was called!")
+	c.logger.Debug("Msg was called")
 
 	return nil
 }
 
-// GetPIN executes the main logic for returning a password/pin back to the gpg-agent
-func GetPIN(authFn AuthFunc, promptFn PromptFunc, logger *log.Logger) GetPinFunc {
-	return func(s pinentry.Settings) (string, *common.Error) {
-		matches := emailRegex.FindStringSubmatch(s.Desc)
-		name := ""
-		email := ""
+// keychainLabelFromDesc builds the label of the keychain item from the name, email and ID of the
+// key found in the description sent by the gpg-agent
+func keychainLabelFromDesc(desc string) (string, error) {
+	matches := emailRegex.FindStringSubmatch(desc)
+	name := ""
+	email := ""
 
-		if len(matches) > 2 {
-			name = strings.Split(matches[1], " <")[0]
-			email = matches[2]
-		}
+	if len(matches) > 2 {
+		name = strings.Split(matches[1], " <")[0]
+		email = matches[2]
+	}
 
-		keyID := ""
+	keyID := ""
 
-		matches = keyIDRegex.FindStringSubmatch(s.Desc)
-		if len(matches) >= 2 {
+	matches = keyIDRegex.FindStringSubmatch(desc)
+	if len(matches) >= 2 {
+		keyID = matches[1]
+	} else {
+		matches = sshKeyIDRegex.FindStringSubmatch(desc)
+		if len(matches) >= 1 {
 			keyID = matches[1]
-		} else {
-			matches = sshKeyIDRegex.FindStringSubmatch(s.Desc)
-			if len(matches) >= 1 {
-				keyID = matches[1]
-				name = "ssh"
-				email = keyID
-			}
+			name = "ssh"
+			email = keyID
 		}
+	}
 
-		// Drop the optional 0x prefix from keyID (--keyid-format)
-		// https://www.gnupg.org/documentation/manuals/gnupg/GPG-Configuration-Options.html
-		keyID = strings.TrimPrefix(keyID, "0x")
+	// Drop the optional 0x prefix from keyID (--keyid-format)
+	// https://www.gnupg.org/documentation/manuals/gnupg/GPG-Configuration-Options.html
+	keyID = strings.TrimPrefix(keyID, "0x")
 
-		if len(keyID) != expectedKeyLengthGPG && len(keyID) != expectedKeyLengthFullGPG && len(keyID) != expectedKeyLengthSSH {
-			return "", assuanError(fmt.Errorf("invalid keyID: %s", keyID))
+	if len(keyID) != expectedKeyLengthGPG && len(keyID) != expectedKeyLengthFullGPG && len(keyID) != expectedKeyLengthSSH {
+		return "", fmt.Errorf("invalid keyID: %s", keyID)
+	}
+
+	return fmt.Sprintf("%s <%s> (%s)", name, email, keyID), nil
+}
+
+// GetPIN executes the main logic for returning a password/pin back to the gpg-agent. The creation
+// and every use of an entry is recorded in entries, and every decision in trail, if not nil.
+func GetPIN(authenticator auth.Authenticator, promptFn PromptFunc, entries *metadata.Store, trail *audit.Log,
+	logger *logging.Logger) GetPinFunc {
+	return func(s pinentry.Settings) ([]byte, *common.Error) {
+		keychainLabel, err := keychainLabelFromDesc(s.Desc)
+		if err != nil {
+			return nil, assuanError(err)
 		}
 
-		keychainLabel := fmt.Sprintf("%s <%s> (%s)", name, email, keyID)
+		// every record of the request describes who made it
+		caller := identifyCaller(s, logger)
+		logger := logger.With(caller.logFields()...)
+		logger.Info("PIN requested", "label", keychainLabel)
+
 		exists, err := checkEntryInKeychain(keychainLabel)
 		if err != nil {
-			logger.Printf("error checking entry in keychain: %s", err)
-			return "", assuanError(err)
+			logger.Error("Error checking the entry in the keychain", "label", keychainLabel, "err", err)
+			return nil, assuanError(storeError{err})
+		}
+
+		// pinentry-mac may have saved the passphrase with its own label
+		if !exists {
+			pin, err := adoptPIN(authenticator, s, caller, keychainLabel, entries, trail, logger)
+			if err != nil {
+				logger.Error("Error importing the entry of pinentry-mac", "label", keychainLabel, "err", err)
+				return nil, assuanError(err)
+			}
+
+			if pin != nil {
+				return pin, nil
+			}
 		}
 
 		// If the entry is not found in the keychain, we trigger `pinentry-mac` with the option
@@ -305,14 +529,19 @@ func GetPIN(authFn AuthFunc, promptFn PromptFunc, logger *log.Logger) GetPinFunc
 		// Currently I'm not aware of a way for automatically adding our binary to the list of always
 		// allowed apps, see: https://github.com/keybase/go-keychain/issues/54.
 		if !exists {
+			event := auditRecord(s, caller, keychainLabel, audit.ModeNew)
 			pin, err := promptFn(s)
 			if err != nil {
-				logger.Printf("Error calling pinentry program (%s): %s", pinentryBinary.GetBinary(), err)
+				logger.Error("Error calling the pinentry program", "program", pinentryBinary.GetBinary(), "err", err)
+				_ = recordAudit(trail, event, audit.Failed, err, logger)
+				return nil, assuanError(err)
 			}
 
 			if len(pin) == 0 {
-				logger.Printf("pinentry-mac didn't return a password")
-				return "", assuanError(fmt.Errorf("pinentry-mac didn't return a password"))
+				logger.Warn("pinentry-mac didn't return a password", "label", keychainLabel)
+				err := fmt.Errorf("%w: pinentry-mac didn't return a password", errCanceled)
+				_ = recordAudit(trail, event, audit.Failed, err, logger)
+				return nil, assuanError(err)
 			}
 
 			// s.KeyInfo is always in the form of x/cacheId
@@ -326,8 +555,8 @@ func GetPIN(authFn AuthFunc, promptFn PromptFunc, logger *log.Logger) GetPinFunc
 			// guarded by Touch ID.
 			exists, err = checkEntryInKeychain(keychainLabel)
 			if err != nil {
-				logger.Printf("error checking entry in keychain: %s", err)
-				return "", assuanError(err)
+				logger.Error("Error checking the entry in the keychain", "label", keychainLabel, "err", err)
+				return nil, assuanError(storeError{err})
 			}
 
 			if !exists {
@@ -335,34 +564,98 @@ func GetPIN(authFn AuthFunc, promptFn PromptFunc, logger *log.Logger) GetPinFunc
 				// ownership over the entry.
 				err = storePasswordInKeychain(keychainLabel, keyInfo, pin)
 
+				// pinentry-mac saved the passphrase with its own label, the typed passphrase is
+				// stored like an imported one. Otherwise our own entry has an outdated label.
 				if err == keychain.ErrorDuplicateItem {
-					logger.Printf("Duplicated entry in the keychain")
-					return "", assuanError(err)
+					item, found, findErr := keychainItemByKeygrip(keyInfo)
+					switch {
+					case findErr != nil:
+						err = findErr
+					case found:
+						logger.Info("Importing the entry saved by pinentry-mac", "label", keychainLabel, "item", item.Label)
+						err = adoptKeychainItem(item, keychainLabel, pin, removePinentryMacItems)
+					default:
+						err = updatePasswordInKeychain(keychainLabel, keyInfo, pin)
+					}
+				}
+
+				// the PIN is still valid, it will be requested again on the next run
+				if err != nil {
+					logger.Error("Error storing the PIN in the keychain", "label", keychainLabel, "err", err)
+				} else {
+					recordCreated(entries, entryRecord(s, keychainLabel), logger)
 				}
 			} else {
-				logger.Printf("The keychain entry was created by pinentry-mac. Permission will be required on next run.")
+				logger.Info("The keychain entry was created by pinentry-mac, permission will be required on the next run",
+					"label", keychainLabel)
+				record := entryRecord(s, keychainLabel)
+				record.CreatedBy = pinentryMacCreator
+				recordCreated(entries, record, logger)
 			}
 
-			return string(pin), nil
+			_ = recordAudit(trail, event, audit.Typed, nil, logger)
+			return pin, nil
 		}
 
-		var ok bool
-		if ok, err = authFn(fmt.Sprintf("access the PIN for %s", keychainLabel)); err != nil {
-			logger.Printf("Error authenticating with Touch ID: %s", err)
-			return "", assuanError(err)
+		// the cached passphrase expired, the user has to prove that it's still known
+		if reentryDue(entries, s, keychainLabel, logger) {
+			logger.Info("The passphrase has to be typed again", "label", keychainLabel)
+			event := auditRecord(s, caller, keychainLabel, audit.ModeReentry)
+			pin, err := reenterPIN(authenticator, s, caller, keychainLabel, promptFn, entries, &event, logger)
+			if err != nil {
+				logger.Error("Error typing the passphrase again", "label", keychainLabel, "err", err)
+				outcome := audit.Failed
+				if errors.As(err, new(authError)) {
+					outcome = audit.Denied
+				}
+				_ = recordAudit(trail, event, outcome, err, logger)
+				return nil, assuanError(err)
+			}
+
+			_ = recordAudit(trail, event, audit.Typed, nil, logger)
+			return pin, nil
 		}
 
-		if !ok {
-			logger.Printf("Failed to authenticate")
-			return "", nil
+		event := auditRecord(s, caller, keychainLabel, audit.ModeCache)
+		result, err := authenticateEvent(authenticator, s,
+			caller.reason(fmt.Sprintf("access the PIN for %s", keychainLabel)), keychainLabel, &event)
+		switch result {
+		case auth.Success:
+		case auth.Mismatch, auth.LockedOut, auth.NotEnrolled, auth.Unavailable:
+			// the user can't authenticate with Touch ID right now, but can still type the PIN
+			logger.Info("Touch ID authentication not possible, asking for the PIN", "label", keychainLabel,
+				"result", result, "err", err)
+			event.Mode = audit.ModePrompt
+			pin, err := promptFn(s)
+			if err != nil {
+				_ = recordAudit(trail, event, audit.Failed, err, logger)
+				return nil, assuanError(err)
+			}
+
+			_ = recordAudit(trail, event, audit.Typed, authError{result, err}, logger)
+			return pin, nil
+		default:
+			logger.Error("Failed to authenticate", "label", keychainLabel, "result", result, "err", err)
+			_ = recordAudit(trail, event, audit.Denied, authError{result, err}, logger)
+			return nil, assuanError(authError{result, err})
 		}
 
-		password, err := passwordFromKeychain(keychainLabel)
+		password, err := pinStore.Get(keychainLabel, keygripFromKeyInfo(s.KeyInfo))
 		if err != nil {
-			log.Printf("Error fetching password from Keychain %s", err)
+			logger.Error("Error fetching the password from the keychain", "label", keychainLabel, "err", err)
+			_ = recordAudit(trail, event, audit.Failed, err, logger)
+			return nil, assuanError(storeError{err})
 		}
 
-		return password, nil
+		if err := recordAudit(trail, event, audit.Released, nil, logger); err != nil {
+			password.Wipe()
+			return nil, assuanError(err)
+		}
+
+		recordUsed(entries, entryRecord(s, keychainLabel), logger)
+
+		// the bytes are wiped by the pinentry server once they are sent to the gpg-agent
+		return password.Bytes(), nil
 	}
 }
 
@@ -419,26 +712,58 @@ func fixPINBinary(oldPath string) error {
 	return nil
 }
 
-func main() {
-	flag.Parse()
-	if !sensor.IsTouchIDAvailable() {
-		client, err := pinentry.LaunchCustom("pinentry-mac")
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Pinentry Launch returned error: %v\n", err)
-			os.Exit(-1)
-		}
-		callbacks := pinentry.Callbacks{
-			GetPIN:  client.GetPIN,
-			Confirm: client.Confirm,
-			Msg:     client.Message,
-		}
+// serveMode is how a pinentry session is served
+type serveMode int
 
-		if err := pinentry.Serve(callbacks, "Hi from pinentry-mac!"); err != nil && err != io.EOF {
-			fmt.Fprintf(os.Stderr, "Pinentry Serve returned error: %v\n", err)
-			os.Exit(-1)
+const (
+	// modeKeychain serves the PIN from the keychain after authenticating the user
+	modeKeychain serveMode = iota
+	// modeProxy relays the session to pinentry-mac (or the terminal)
+	modeProxy
+)
+
+// pinStore keeps the cached passphrases
+var pinStore store.Store = keychainStore{}
+
+// prober reports the biometric capabilities of the device
+var prober sensor.Prober = sensor.LocalAuthentication{}
+
+// chooseMode picks how the session is served given the capabilities of the device and the
+// configured authenticators. The session is only relayed when none of them can be used, an
+// authenticator that doesn't need the biometric sensor is always worth trying.
+func chooseMode(caps sensor.Capabilities, chain auth.Chain) serveMode {
+	for _, link := range chain.Links {
+		if caps.Available() || !usesSensor(link.Name) {
+			return modeKeychain
 		}
 	}
 
+	return modeProxy
+}
+
+// checkBiometry explains whether biometric authentication can be used
+func checkBiometry(caps sensor.Capabilities) {
+	if caps.Available() {
+		fmt.Fprintf(os.Stdout, "%v %s is available\n", emoji.CheckMarkButton, caps.Biometry)
+		return
+	}
+
+	fmt.Fprintf(os.Stdout, "%v Biometric authentication is disabled: %s\n", emoji.Warning, caps.Reason)
+	if caps.LockedOut {
+		fmt.Fprintf(os.Stdout, "%v %s is locked after too many failed attempts, unlock it with your password\n",
+			emoji.Information, caps.Biometry)
+	}
+	if !caps.PasscodeSet {
+		fmt.Fprintf(os.Stdout, "%v No login password is set\n", emoji.Information)
+	}
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(flag.Arg(0), flag.Args()[1:]))
+	}
+
 	if *fixSymlink {
 		path := pinentryBinary.GetBinary()
 		if err := fixPINBinary(path); err != nil {
@@ -461,9 +786,70 @@ func main() {
 			fmt.Fprintf(os.Stdout, "%v %s will be used as a fallback PIN program\n", emoji.CheckMarkButton, path)
 		}
 
+		caps := prober.Probe()
+		checkBiometry(caps)
+
+		cfg, err := config.Load(config.DefaultPath())
+		if err == nil {
+			var chain auth.Chain
+			if chain, err = authenticatorFromConfig(cfg); err == nil {
+				fmt.Fprintf(os.Stdout, "%v Authenticators (in order): %s\n", emoji.CheckMarkButton, chain)
+				if chooseMode(caps, chain) == modeProxy {
+					fmt.Fprintf(os.Stdout, "%v Every session will be relayed to pinentry-mac\n", emoji.Information)
+				}
+			}
+		}
+		if err == nil {
+			var p policy.Policy
+			if p, err = policyFromConfig(cfg); err == nil {
+				for _, rule := range p.Rules {
+					fmt.Fprintf(os.Stdout, "%v Access policy: %s\n", emoji.CheckMarkButton, rule)
+				}
+			}
+		}
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v %s: %s\n", emoji.CrossMark, config.DefaultPath(), err)
+			os.Exit(-1)
+		}
+
 		os.Exit(0)
 	}
 
+	cfg, _ := config.Load(config.DefaultPath())
+	chain, err := authenticatorFromConfig(cfg)
+	if err != nil {
+		// New falls back to Touch ID as well
+		chain, _ = authenticatorFromConfig(config.Config{})
+	}
+
+	if chooseMode(prober.Probe(), chain) == modeProxy {
+		cfg.Trace = cfg.Trace || *debug
+		// the log is kept open until the process exits
+		logger, _ := loggerFromConfig(cfg)
+
+		var agent, upstream common.Tracer
+		if cfg.Trace {
+			agent, upstream = traceSession(logger)
+		}
+
+		// relay the whole session to pinentry-mac, without it (e.g. over SSH) the built-in terminal
+		// prompt is used
+		client, err := pinentry.LaunchTraced("pinentry-mac", upstream)
+		if err == nil {
+			err = pinentry.ServeProxyTraced(client.Session, pinentry.Callbacks{}, agent)
+		} else {
+			err = pinentry.ServeTraced(tty.Callbacks(ttyWarning(logger)), "Hi from pinentry-touchid!", agent)
+		}
+
+		if err != nil && err != io.EOF {
+			fmt.Fprintf(os.Stderr, "Pinentry Serve returned error: %v\n", err)
+			os.Exit(-1)
+		}
+
+		return
+	}
+
 	client := New()
 
 	callbacks := pinentry.Callbacks{
@@ -472,7 +858,7 @@ func main() {
 		Msg:     client.Msg,
 	}
 
-	if err := pinentry.Serve(callbacks, "Hi from pinentry-touchid!"); err != nil && err != io.EOF {
+	if err := pinentry.ServeTraced(callbacks, "Hi from pinentry-touchid!", client.tracer); err != nil && err != io.EOF {
 		fmt.Fprintf(os.Stderr, "Pinentry Serve returned error: %v\n", err)
 		os.Exit(-1)
 	}
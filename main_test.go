@@ -0,0 +1,41 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/config"
+	"github.com/jorgelbg/pinentry-touchid/sensor"
+)
+
+func TestChooseMode(t *testing.T) {
+	touchID, err := authenticatorFromConfig(config.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an authenticator that doesn't need the sensor, tried after Touch ID
+	fallback := auth.Chain{Links: []auth.Link{touchID.Links[0], {Name: "scripted", Authenticator: auth.NewScripted()}}}
+
+	tests := []struct {
+		prober sensor.Prober
+		chain  auth.Chain
+		want   serveMode
+	}{
+		{sensor.Fake{Biometry: sensor.BiometryTouchID, Enrolled: true}, touchID, modeKeychain},
+		{sensor.Fake{Reason: "no sensor"}, touchID, modeProxy},
+		{sensor.Fake{Biometry: sensor.BiometryTouchID, Reason: "no fingers enrolled"}, touchID, modeProxy},
+		{sensor.Fake{Biometry: sensor.BiometryTouchID, Enrolled: true, LockedOut: true}, touchID, modeProxy},
+		{sensor.Fake{Reason: "no sensor"}, fallback, modeKeychain},
+		{sensor.Fake{Biometry: sensor.BiometryTouchID, Enrolled: true}, fallback, modeKeychain},
+	}
+
+	for _, tt := range tests {
+		if got := chooseMode(tt.prober.Probe(), tt.chain); got != tt.want {
+			t.Fatalf("%+v %s: mode mismatch got: %d want: %d", tt.prober, tt.chain, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package metadata keeps a record of every cached PIN: when it was created and last used, how
+// often it is used and which key it belongs to. The records don't depend on where the PIN itself
+// is stored, they are identified by the keygrip sent by the gpg-agent.
+package metadata
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jorgelbg/pinentry-touchid/internal/jsonfile"
+)
+
+// DefaultFilename is the name of the file where the records are persisted
+const DefaultFilename = "pinentry-touchid-entries.json"
+
+// Reentry forces typing a cached passphrase from time to time, so it is not forgotten. A zero value
+// disables the limit.
+type Reentry struct {
+	// MaxAge is how long a passphrase is released after it was last typed
+	MaxAge time.Duration `json:"max_age,omitempty"`
+	// Every forces typing the passphrase on every Nth use
+	Every int `json:"every,omitempty"`
+}
+
+// override returns r with the limits set in entry, negative limits of entry disable them
+func (r Reentry) override(entry Reentry) Reentry {
+	if entry.MaxAge != 0 {
+		r.MaxAge = entry.MaxAge
+	}
+	if entry.Every != 0 {
+		r.Every = entry.Every
+	}
+
+	return r
+}
+
+// Record describes a cached PIN
+type Record struct {
+	// Keygrip identifies the key in the gpg-agent, it is the cache ID of the SETKEYINFO command
+	Keygrip string `json:"keygrip"`
+	// Label of the entry in the store
+	Label string `json:"label"`
+	// Fingerprint of the key as found in the description: the key ID for GPG keys or the SHA256
+	// fingerprint for SSH keys
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Description shown by the gpg-agent when the entry was last created or used
+	Description string `json:"description,omitempty"`
+	// Created is zero when the entry was created before its metadata was recorded
+	Created   time.Time `json:"created,omitempty"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	LastUsed  time.Time `json:"last_used,omitempty"`
+	UseCount  int       `json:"use_count"`
+	// LastVerified is when the passphrase was last typed instead of released from the cache
+	LastVerified time.Time `json:"last_verified,omitempty"`
+	// UsesSinceVerified counts the uses since the passphrase was last typed
+	UsesSinceVerified int `json:"uses_since_verified"`
+	// Reentry overrides the default re-entry limits for this entry
+	Reentry Reentry `json:"reentry"`
+}
+
+// ReentryDue reports whether the passphrase has to be typed again given the default limits. An
+// entry that was never typed, nor created, with a max age is due right away.
+func (r Record) ReentryDue(defaults Reentry, now time.Time) bool {
+	limits := defaults.override(r.Reentry)
+
+	if limits.Every > 0 && r.UsesSinceVerified+1 >= limits.Every {
+		return true
+	}
+
+	if limits.MaxAge > 0 {
+		verified := r.LastVerified
+		if verified.IsZero() {
+			verified = r.Created
+		}
+
+		return now.Sub(verified) >= limits.MaxAge
+	}
+
+	return false
+}
+
+// Store persists the records in a JSON file
+type Store struct {
+	path    string
+	reentry Reentry
+	now     func() time.Time
+	mu      sync.Mutex
+}
+
+// NewStore returns a store that keeps the records in the file at path, reentry are the default
+// re-entry limits of every entry
+func NewStore(path string, reentry Reentry) *Store {
+	return &Store{path: path, reentry: reentry, now: time.Now}
+}
+
+func (s *Store) load() (map[string]Record, error) {
+	records := make(map[string]Record)
+	if err := jsonfile.Load(s.path, &records); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// Created records a new entry, replacing the record of an older entry for the same key. The
+// creation date is now unless it is already set in r (e.g. for imported entries).
+func (s *Store) Created(r Record) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.load()
+	if err != nil {
+		return Record{}, err
+	}
+
+	// the passphrase of a new entry was just typed, the per entry limits are kept
+	if r.Created.IsZero() {
+		r.Created = s.now()
+	}
+	r.LastUsed = s.now()
+	r.UseCount = 1
+	r.LastVerified = r.LastUsed
+	r.UsesSinceVerified = 0
+	r.Reentry = records[r.Keygrip].Reentry
+	records[r.Keygrip] = r
+
+	return r, jsonfile.Save(s.path, records)
+}
+
+// Used records an access to the entry of r.Keygrip. The label, fingerprint and description are
+// refreshed from r, an entry without a record gets one with an unknown creation date.
+func (s *Store) Used(r Record) (Record, error) {
+	return s.update(r, false)
+}
+
+// Verified records an access to the entry of r.Keygrip where the passphrase was typed by the user,
+// it restarts the re-entry limits
+func (s *Store) Verified(r Record) (Record, error) {
+	return s.update(r, true)
+}
+
+func (s *Store) update(r Record, verified bool) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.load()
+	if err != nil {
+		return Record{}, err
+	}
+
+	stored := records[r.Keygrip]
+	stored.Keygrip = r.Keygrip
+	stored.Label = r.Label
+	if r.Fingerprint != "" {
+		stored.Fingerprint = r.Fingerprint
+	}
+	if r.Description != "" {
+		stored.Description = r.Description
+	}
+	stored.LastUsed = s.now()
+	stored.UseCount++
+	if verified {
+		stored.LastVerified = stored.LastUsed
+		stored.UsesSinceVerified = 0
+	} else {
+		stored.UsesSinceVerified++
+	}
+	records[r.Keygrip] = stored
+
+	return stored, jsonfile.Save(s.path, records)
+}
+
+// ReentryDue reports whether the passphrase of keygrip has to be typed again, the default limits
+// also apply to entries without a record
+func (s *Store) ReentryDue(keygrip string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.load()
+	if err != nil {
+		return false, err
+	}
+
+	return records[keygrip].ReentryDue(s.reentry, s.now()), nil
+}
+
+// SetReentry overrides the default re-entry limits of keygrip. A zero limit uses the default, a
+// negative one disables it.
+func (s *Store) SetReentry(keygrip string, reentry Reentry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	r, ok := records[keygrip]
+	if !ok {
+		return fmt.Errorf("no entry found for %s", keygrip)
+	}
+	r.Reentry = reentry
+	records[keygrip] = r
+
+	return jsonfile.Save(s.path, records)
+}
+
+// Get returns the record of keygrip
+func (s *Store) Get(keygrip string) (Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.load()
+	if err != nil {
+		return Record{}, false, err
+	}
+
+	r, ok := records[keygrip]
+
+	return r, ok, nil
+}
+
+// List returns every record sorted by label
+func (s *Store) List() ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Record, 0, len(records))
+	for _, r := range records {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Label != list[j].Label {
+			return list[i].Label < list[j].Label
+		}
+		return list[i].Keygrip < list[j].Keygrip
+	})
+
+	return list, nil
+}
+
+// Delete forgets the record of keygrip
+func (s *Store) Delete(keygrip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := records[keygrip]; !ok {
+		return nil
+	}
+	delete(records, keygrip)
+
+	return jsonfile.Save(s.path, records)
+}
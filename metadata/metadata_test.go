@@ -0,0 +1,145 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testStore returns a store with a clock that can be moved forward with the returned function
+func testStore(t *testing.T, reentry Reentry) (*Store, func(time.Duration)) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewStore(filepath.Join(t.TempDir(), DefaultFilename), reentry)
+	s.now = func() time.Time { return now }
+
+	return s, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestCreatedAndUsed(t *testing.T) {
+	s, advance := testStore(t, Reentry{})
+	created := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if _, err := s.Created(Record{Keygrip: "KEY", Label: "test", CreatedBy: "v1"}); err != nil {
+		t.Fatalf("recording a new entry should succeed: %s", err)
+	}
+
+	advance(time.Hour)
+	if _, err := s.Used(Record{Keygrip: "KEY", Label: "renamed", Description: "desc"}); err != nil {
+		t.Fatalf("recording an access should succeed: %s", err)
+	}
+
+	r, ok, err := NewStore(s.path, s.reentry).Get("KEY")
+	if err != nil || !ok {
+		t.Fatalf("the record should be persisted: %v", err)
+	}
+
+	want := Record{
+		Keygrip:     "KEY",
+		Label:       "renamed",
+		Description: "desc",
+		Created:     created,
+		CreatedBy:   "v1",
+		LastUsed:    created.Add(time.Hour),
+		UseCount:    2,
+
+		LastVerified:      created,
+		UsesSinceVerified: 1,
+	}
+	if r != want {
+		t.Fatalf("record mismatch got: %+v want: %+v", r, want)
+	}
+}
+
+func TestUsedWithoutRecord(t *testing.T) {
+	s, _ := testStore(t, Reentry{})
+
+	r, err := s.Used(Record{Keygrip: "KEY", Label: "test"})
+	if err != nil {
+		t.Fatalf("recording an access should succeed: %s", err)
+	}
+
+	if !r.Created.IsZero() || r.UseCount != 1 {
+		t.Fatalf("an entry without a record has an unknown creation date: %+v", r)
+	}
+}
+
+func TestListAndDelete(t *testing.T) {
+	s, _ := testStore(t, Reentry{})
+
+	for _, r := range []Record{{Keygrip: "C", Label: "b"}, {Keygrip: "A", Label: "a"}, {Keygrip: "B", Label: "b"}} {
+		if _, err := s.Created(r); err != nil {
+			t.Fatalf("recording a new entry should succeed: %s", err)
+		}
+	}
+
+	if err := s.Delete("A"); err != nil {
+		t.Fatalf("deleting a record should succeed: %s", err)
+	}
+
+	list, err := s.List()
+	if err != nil {
+		t.Fatalf("listing the records should succeed: %s", err)
+	}
+
+	if len(list) != 2 || list[0].Keygrip != "B" || list[1].Keygrip != "C" {
+		t.Fatalf("records should be sorted by label and keygrip: %+v", list)
+	}
+}
+
+func TestReentryDue(t *testing.T) {
+	s, advance := testStore(t, Reentry{MaxAge: 24 * time.Hour, Every: 3})
+
+	due := func() bool {
+		t.Helper()
+		d, err := s.ReentryDue("KEY")
+		if err != nil {
+			t.Fatalf("checking the re-entry should succeed: %s", err)
+		}
+		return d
+	}
+
+	if !due() {
+		t.Fatalf("an entry that was never typed should be due with a max age")
+	}
+
+	if _, err := s.Created(Record{Keygrip: "KEY"}); err != nil {
+		t.Fatalf("recording a new entry should succeed: %s", err)
+	}
+
+	// the third use after typing the passphrase has to be typed again
+	for i := 0; i < 2; i++ {
+		if due() {
+			t.Fatalf("use %d should not be due", i+2)
+		}
+		if _, err := s.Used(Record{Keygrip: "KEY"}); err != nil {
+			t.Fatalf("recording an access should succeed: %s", err)
+		}
+	}
+	if !due() {
+		t.Fatalf("every third use should be due")
+	}
+
+	if _, err := s.Verified(Record{Keygrip: "KEY"}); err != nil {
+		t.Fatalf("recording a verified access should succeed: %s", err)
+	}
+	if due() {
+		t.Fatalf("typing the passphrase should restart the limits")
+	}
+
+	advance(24 * time.Hour)
+	if !due() {
+		t.Fatalf("the entry should be due after its max age")
+	}
+
+	// limits can be disabled per entry
+	if err := s.SetReentry("KEY", Reentry{MaxAge: -1, Every: -1}); err != nil {
+		t.Fatalf("overriding the limits should succeed: %s", err)
+	}
+	if due() {
+		t.Fatalf("the entry should never be due without limits")
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+//
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/enescakir/emoji"
+	"github.com/foxcpp/go-assuan/pinentry"
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/store"
+)
+
+// migrateOptions are the arguments of the migrate command
+type migrateOptions struct {
+	from, to string
+	store.MigrateOptions
+}
+
+// parseMigrateArgs parses the arguments of the migrate command
+func parseMigrateArgs(args []string) (migrateOptions, error) {
+	var opts migrateOptions
+
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&opts.from, "from", "", "")
+	fs.StringVar(&opts.to, "to", "", "")
+	fs.BoolVar(&opts.DryRun, "n", false, "")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "")
+	fs.BoolVar(&opts.Remove, "remove", false, "")
+
+	usage := fmt.Errorf("usage: migrate --from <backend> --to <backend> [-n] [--remove]")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 || opts.from == "" || opts.to == "" {
+		return opts, usage
+	}
+
+	if opts.from == opts.to {
+		return opts, fmt.Errorf("the source and destination backends are the same")
+	}
+
+	return opts, nil
+}
+
+// migrate copies every entry from one backend to another, verifying each copy. The user
+// authenticates once for all the entries. The metadata records are identified by the keygrip, they
+// apply to the copies as they are.
+func migrate(w io.Writer, authenticator auth.Authenticator, src, dst store.Store, opts migrateOptions) error {
+	list, err := src.List()
+	if err != nil {
+		return err
+	}
+
+	if len(list) == 0 {
+		fmt.Fprintf(w, "%v No entries found in %s\n", emoji.CheckMarkButton, opts.from)
+		return nil
+	}
+
+	if !opts.DryRun {
+		result, err := authenticate(authenticator, pinentry.Settings{},
+			fmt.Sprintf("migrate %d passphrase(s) from %s to %s", len(list), opts.from, opts.to), "")
+		if result != auth.Success {
+			return authError{result, err}
+		}
+	}
+
+	opts.Progress = func(e store.Entry, action store.Action, err error) {
+		if err != nil {
+			fmt.Fprintf(w, "%v %s: %s\n", emoji.CrossMark, e.Label, err)
+			return
+		}
+
+		verb := "copying"
+		if action == store.Replace {
+			verb = "replacing"
+		}
+		fmt.Fprintf(w, "%v %s: %s in %s\n", emoji.Information, e.Label, verb, opts.to)
+	}
+
+	n, err := store.Migrate(src, dst, opts.MigrateOptions)
+	if err != nil {
+		return err
+	}
+
+	if !opts.DryRun {
+		fmt.Fprintf(w, "%v Migrated %d entries from %s to %s\n", emoji.CheckMarkButton, n, opts.from, opts.to)
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/store"
+)
+
+func TestParseMigrateArgs(t *testing.T) {
+	opts, err := parseMigrateArgs([]string{"--from", "keychain", "--to", "secret-service", "-n", "--remove"})
+	if err != nil {
+		t.Fatalf("parsing the arguments should succeed: %s", err)
+	}
+
+	if opts.from != "keychain" || opts.to != "secret-service" || !opts.DryRun || !opts.Remove {
+		t.Fatalf("unexpected options: %+v", opts)
+	}
+
+	for _, args := range [][]string{
+		{},
+		{"--from", "keychain"},
+		{"--from", "keychain", "--to", "keychain"},
+		{"--from", "keychain", "--to", "secret-service", "extra"},
+	} {
+		if _, err := parseMigrateArgs(args); err == nil {
+			t.Fatalf("%q should be rejected", args)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	src, dst := store.NewMemory(), store.NewMemory()
+	if err := src.Put(store.Entry{Label: "label", Keygrip: "KEYGRIP"}, []byte(testPassword)); err != nil {
+		t.Fatalf("storing an entry should succeed: %s", err)
+	}
+
+	opts := migrateOptions{from: "src", to: "dst"}
+	opts.Remove = true
+
+	var out bytes.Buffer
+	if err := migrate(&out, auth.NewScripted(auth.Declined), src, dst, opts); err == nil {
+		t.Fatalf("the migration should fail if the user doesn't authenticate")
+	}
+	if entries, _ := dst.List(); len(entries) != 0 {
+		t.Fatalf("nothing should be copied without authentication: %+v", entries)
+	}
+
+	// a single authentication covers every entry
+	if err := migrate(&out, auth.NewScripted(auth.Success), src, dst, opts); err != nil {
+		t.Fatalf("the migration should succeed: %s", err)
+	}
+
+	pin, err := dst.Get("label", "KEYGRIP")
+	if err != nil || string(pin.Bytes()) != testPassword {
+		t.Fatalf("the entry should be copied: %q (%v)", pin.Bytes(), err)
+	}
+	if entries, _ := src.List(); len(entries) != 0 {
+		t.Fatalf("the source entry should be removed: %+v", entries)
+	}
+}
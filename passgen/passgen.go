@@ -0,0 +1,147 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package passgen generates random passphrases from a set of characters or from a wordlist
+package passgen
+
+import (
+	"bufio"
+	"crypto/rand"
+	"errors"
+	"math"
+	"math/big"
+	"os"
+	"strings"
+)
+
+const (
+	// MinEntropy is the entropy (in bits) of the generated passphrases when no length is set
+	MinEntropy = 128
+	// DefaultCharset is the set of characters used when no wordlist or charset is configured
+	DefaultCharset = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_.,:;!?#+*="
+	// DefaultSeparator is the string placed between the words of a passphrase
+	DefaultSeparator = "-"
+)
+
+var errTooFewSymbols = errors.New("at least two distinct characters or words are required")
+
+// Generator creates passphrases by picking Length random symbols (characters of Charset or
+// entries of Words) using crypto/rand.
+type Generator struct {
+	// Charset used when no Words are set, DefaultCharset if empty
+	Charset string
+	// Words used instead of the Charset
+	Words []string
+	// Separator placed between Words, DefaultSeparator if empty
+	Separator string
+	// Length is the number of symbols, if zero it is derived from MinEntropy
+	Length int
+}
+
+// LoadWordlist reads one word per line from the file at path. Lines in the diceware format
+// (e.g. "11111	abacus") are supported as well, only the last field is used.
+func LoadWordlist(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		words = append(words, fields[len(fields)-1])
+	}
+
+	return words, scanner.Err()
+}
+
+// symbols returns the distinct symbols the passphrase is built from, duplicates would reduce the
+// entropy of the result.
+func (g Generator) symbols() []string {
+	var all []string
+	if len(g.Words) > 0 {
+		all = g.Words
+	} else {
+		charset := g.Charset
+		if charset == "" {
+			charset = DefaultCharset
+		}
+		all = strings.Split(charset, "")
+	}
+
+	seen := make(map[string]bool, len(all))
+	symbols := make([]string, 0, len(all))
+	for _, s := range all {
+		if s != "" && !seen[s] {
+			seen[s] = true
+			symbols = append(symbols, s)
+		}
+	}
+
+	return symbols
+}
+
+func (g Generator) length(symbols int) int {
+	if g.Length > 0 {
+		return g.Length
+	}
+
+	return int(math.Ceil(MinEntropy / math.Log2(float64(symbols))))
+}
+
+// Entropy returns the entropy in bits of the passphrases created by the generator
+func (g Generator) Entropy() float64 {
+	symbols := len(g.symbols())
+	if symbols < 2 {
+		return 0
+	}
+
+	return float64(g.length(symbols)) * math.Log2(float64(symbols))
+}
+
+// Generate returns a new random passphrase
+func (g Generator) Generate() ([]byte, error) {
+	symbols := g.symbols()
+	if len(symbols) < 2 {
+		return nil, errTooFewSymbols
+	}
+
+	separator := ""
+	if len(g.Words) > 0 {
+		separator = g.Separator
+		if separator == "" {
+			separator = DefaultSeparator
+		}
+	}
+
+	// the buffer is allocated once, growing it would leave copies of the passphrase behind
+	longest := 0
+	for _, symbol := range symbols {
+		if len(symbol) > longest {
+			longest = len(symbol)
+		}
+	}
+	length := g.length(len(symbols))
+	b := make([]byte, 0, length*(longest+len(separator)))
+
+	max := big.NewInt(int64(len(symbols)))
+	for i := 0; i < length; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return nil, err
+		}
+
+		if i > 0 {
+			b = append(b, separator...)
+		}
+		b = append(b, symbols[n.Int64()]...)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package passgen
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateDefault(t *testing.T) {
+	var g Generator
+
+	if g.Entropy() < MinEntropy {
+		t.Fatalf("default entropy is too low: %f", g.Entropy())
+	}
+
+	first, err := g.Generate()
+	if err != nil {
+		t.Fatalf("generating a passphrase should succeed: %s", err)
+	}
+
+	second, err := g.Generate()
+	if err != nil {
+		t.Fatalf("generating a passphrase should succeed: %s", err)
+	}
+
+	if bytes.Equal(first, second) {
+		t.Fatalf("two generated passphrases should not match: %s", first)
+	}
+
+	for _, c := range string(first) {
+		if !strings.ContainsRune(DefaultCharset, c) {
+			t.Fatalf("unexpected character %q in %s", c, first)
+		}
+	}
+}
+
+func TestGenerateCharset(t *testing.T) {
+	g := Generator{Charset: "abab", Length: 16}
+
+	pass, err := g.Generate()
+	if err != nil {
+		t.Fatalf("generating a passphrase should succeed: %s", err)
+	}
+
+	if len(pass) != 16 || strings.Trim(string(pass), "ab") != "" {
+		t.Fatalf("unexpected passphrase: %s", pass)
+	}
+
+	if g.Entropy() != 16 {
+		t.Fatalf("duplicated characters should not add entropy, got: %f", g.Entropy())
+	}
+
+	if _, err := (Generator{Charset: "aaaa"}).Generate(); err == nil {
+		t.Fatalf("a single distinct character should be rejected")
+	}
+}
+
+func TestGenerateWordlist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	if err := os.WriteFile(path, []byte("11111\tabacus\n11112\tabdomen\n\n11113\tabide\n"), 0600); err != nil {
+		t.Fatalf("failed to write wordlist: %s", err)
+	}
+
+	words, err := LoadWordlist(path)
+	if err != nil {
+		t.Fatalf("loading the wordlist should succeed: %s", err)
+	}
+
+	if strings.Join(words, ",") != "abacus,abdomen,abide" {
+		t.Fatalf("unexpected words: %v", words)
+	}
+
+	pass, err := Generator{Words: words, Length: 5}.Generate()
+	if err != nil {
+		t.Fatalf("generating a passphrase should succeed: %s", err)
+	}
+
+	parts := strings.Split(string(pass), DefaultSeparator)
+	if len(parts) != 5 {
+		t.Fatalf("unexpected number of words in %s", pass)
+	}
+}
@@ -6,10 +6,12 @@ package main
 
 import (
 	"io/ioutil"
-	"log"
 	"testing"
 
+	"github.com/foxcpp/go-assuan/common"
 	"github.com/foxcpp/go-assuan/pinentry"
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/logging"
 	"github.com/keybase/go-keychain"
 )
 
@@ -25,9 +27,7 @@ created 2021-01-01 (main key ID 70D56DF4CA30DE16).
 )
 
 var (
-	failedAuthFn     = func(reason string) (bool, error) { return false, nil }
-	successfulAuthFn = func(reason string) (bool, error) { return true, nil }
-	dummyPrompt      = func(s pinentry.Settings) ([]byte, error) { return []byte{}, nil }
+	dummyPrompt = func(s pinentry.Settings) ([]byte, error) { return []byte{}, nil }
 )
 
 func TestStoreEntryInKeychain(t *testing.T) {
@@ -47,14 +47,14 @@ func TestGetPasswordFromKeychain(t *testing.T) {
 		}
 	}()
 
-	pass, err := passwordFromKeychain("sampleLabel")
+	pass, err := passwordFromKeychain("sampleLabel", "")
 
 	if err != nil {
 		t.Fatalf("fetch entry from Keychain should succeed: %s", err)
 	}
 
-	if pass != testPassword {
-		t.Fatalf("password mismatch got: %s want: %s", pass, testPassword)
+	if string(pass.Bytes()) != testPassword {
+		t.Fatalf("password mismatch got: %s want: %s", pass.Bytes(), testPassword)
 	}
 }
 
@@ -72,17 +72,16 @@ func TestGetPINSuccessfulAuthentication(t *testing.T) {
 		t.Fatalf("failed precreating entry in the Keychain: %s", err)
 	}
 
-	logger := &log.Logger{}
-	logger.SetOutput(ioutil.Discard)
+	logger := logging.New(ioutil.Discard, logging.LevelDebug, logging.FormatText)
 
-	fn := GetPIN(successfulAuthFn, dummyPrompt, logger)
+	fn := GetPIN(auth.NewScripted(auth.Success), dummyPrompt, nil, nil, logger)
 	pass, pinErr := fn(params)
 
 	if pinErr != nil {
 		t.Fatalf("call to GetPIN should succeed: %s", err)
 	}
 
-	if pass != testPassword {
+	if string(pass) != testPassword {
 		t.Fatalf("password mismatch got: %s want: %s", pass, testPassword)
 	}
 }
@@ -91,7 +90,7 @@ func TestGetPINUnsuccessfulAuthentication(t *testing.T) {
 	keychainLabel := `Firstname Lastname <test@email.com> (61AF059BD632F971)`
 	defer func() { _ = cleanKeychain(keychainLabel) }()
 
-	logger := log.New(ioutil.Discard, "", 0)
+	logger := logging.New(ioutil.Discard, logging.LevelDebug, logging.FormatText)
 
 	params := pinentry.Settings{
 		Desc:    keyDesc,
@@ -103,30 +102,82 @@ func TestGetPINUnsuccessfulAuthentication(t *testing.T) {
 		t.Fatalf("failed precreating entry in the Keychain: %s", err)
 	}
 
-	fn := GetPIN(failedAuthFn, dummyPrompt, logger)
+	fn := GetPIN(auth.NewScripted(auth.Declined), dummyPrompt, nil, nil, logger)
 	pass, pinErr := fn(params)
 
-	if pinErr != nil {
-		t.Fatalf("call to GetPIN should succeed: %s", pinErr)
+	if pinErr == nil || pinErr.Code != common.ErrCanceled {
+		t.Fatalf("call to GetPIN should be canceled, got: %v", pinErr)
 	}
 
-	if pass != emptyPassword {
+	if string(pass) != emptyPassword {
 		t.Fatalf("password mismatch got: %s want: %s", pass, testPassword)
 	}
 }
 
+func TestGetPINAuthenticationResults(t *testing.T) {
+	keychainLabel := `Firstname Lastname <test@email.com> (61AF059BD632F971)`
+	defer func() { _ = cleanKeychain(keychainLabel) }()
+
+	logger := logging.New(ioutil.Discard, logging.LevelDebug, logging.FormatText)
+	params := pinentry.Settings{
+		Desc:    keyDesc,
+		KeyInfo: keyInfo,
+	}
+
+	err := storePasswordInKeychain(keychainLabel, keyInfo, []byte(testPassword))
+	if err != nil {
+		t.Fatalf("failed precreating entry in the Keychain: %s", err)
+	}
+
+	typedPrompt := func(s pinentry.Settings) ([]byte, error) { return []byte("typed"), nil }
+
+	tests := []struct {
+		results []auth.Result
+		want    string
+		code    common.ErrorCode
+		calls   int
+	}{
+		{[]auth.Result{auth.Mismatch, auth.Success}, testPassword, 0, 2},
+		{[]auth.Result{auth.Mismatch}, "typed", 0, maxAuthAttempts},
+		{[]auth.Result{auth.LockedOut}, "typed", 0, 1},
+		{[]auth.Result{auth.NotEnrolled}, "typed", 0, 1},
+		{[]auth.Result{auth.Unavailable}, "typed", 0, 1},
+		{[]auth.Result{auth.Timeout}, "", common.ErrTimeout, 1},
+		{[]auth.Result{auth.Canceled}, "", common.ErrCanceled, 1},
+		{[]auth.Result{auth.Declined}, "", common.ErrCanceled, 1},
+		{[]auth.Result{auth.Failed}, "", common.ErrGeneral, 1},
+	}
+
+	for _, tt := range tests {
+		authenticator := auth.NewScripted(tt.results...)
+		pass, pinErr := GetPIN(authenticator, typedPrompt, nil, nil, logger)(params)
+
+		if pinErr != nil && pinErr.Code != tt.code || pinErr == nil && tt.code != 0 {
+			t.Fatalf("%v: unexpected error got: %v want code: %d", tt.results, pinErr, tt.code)
+		}
+
+		if string(pass) != tt.want {
+			t.Fatalf("%v: password mismatch got: %s want: %s", tt.results, pass, tt.want)
+		}
+
+		if calls := len(authenticator.Requests()); calls != tt.calls {
+			t.Fatalf("%v: expected %d authentication attempts, got: %d", tt.results, tt.calls, calls)
+		}
+	}
+}
+
 func TestEntryNotInKeychain(t *testing.T) {
 	keychainLabel := `Firstname Lastname <test@email.com> (61AF059BD632F971)`
 	defer func() { _ = cleanKeychain(keychainLabel) }()
 
-	logger := log.New(ioutil.Discard, "", 0)
+	logger := logging.New(ioutil.Discard, logging.LevelDebug, logging.FormatText)
 	params := pinentry.Settings{
 		Desc:    keyDesc,
 		KeyInfo: keyInfo,
 	}
 
 	// initially the entry for the test key is not in the keychain
-	if pass, err := passwordFromKeychain(keychainLabel); err == nil || pass != "" {
+	if pass, err := passwordFromKeychain(keychainLabel, ""); err == nil || pass.Len() != 0 {
 		t.Fatalf("unexpected entry found in the keychain: %s", keychainLabel)
 	}
 
@@ -135,7 +186,7 @@ func TestEntryNotInKeychain(t *testing.T) {
 		fallBack = true
 		return []byte(testPassword), nil
 	}
-	fn := GetPIN(successfulAuthFn, validPinFn, logger)
+	fn := GetPIN(auth.NewScripted(auth.Success), validPinFn, nil, nil, logger)
 	pass, pinErr := fn(params)
 	if pinErr != nil {
 		t.Fatalf("call to GetPIN should succeed: %s", pinErr)
@@ -145,12 +196,12 @@ func TestEntryNotInKeychain(t *testing.T) {
 		t.Fatalf("the fallback password prompt should have been called")
 	}
 
-	if pass != testPassword {
+	if string(pass) != testPassword {
 		t.Fatalf("password mismatch got: %s want: %s", pass, testPassword)
 	}
 
 	// after the successful run of GetPIN the entry should be present in the keychain
-	if pass, err := passwordFromKeychain(keychainLabel); err != nil || pass == "" {
+	if pass, err := passwordFromKeychain(keychainLabel, ""); err != nil || pass.Len() == 0 {
 		t.Fatalf("missing entry from the keychain: %s", keychainLabel)
 	}
 }
@@ -0,0 +1,316 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package policy decides which callers may have a cached PIN released, based on the process that
+// asked the gpg-agent for it. A policy is a list of rules, each one matches the executable of the
+// caller, its ancestors and its terminal. The first matching rule decides: allow the user to
+// authenticate, fall back to typing the PIN or deny the request.
+package policy
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/process"
+)
+
+// Action is what a rule decides for the requests it matches
+type Action int
+
+const (
+	// Allow lets the user authenticate to release the cached PIN
+	Allow Action = iota
+	// Fallback makes the user type the PIN, the cached one isn't released
+	Fallback
+	// Deny refuses the request
+	Deny
+)
+
+var actionNames = []string{"allow", "fallback", "deny"}
+
+func (a Action) String() string {
+	if a >= Allow && int(a) < len(actionNames) {
+		return actionNames[a]
+	}
+
+	return "unknown"
+}
+
+// ParseAction returns the action with the given name
+func ParseAction(name string) (Action, error) {
+	for i, n := range actionNames {
+		if name == n {
+			return Action(i), nil
+		}
+	}
+
+	return Allow, fmt.Errorf("unknown action %q, expected allow, fallback or deny", name)
+}
+
+const (
+	// AnyTTY matches a caller that has a terminal
+	AnyTTY = "*"
+	// NoTTY matches a caller without a terminal
+	NoTTY = "none"
+)
+
+// Rule matches callers, a rule without conditions matches every caller
+type Rule struct {
+	Action Action
+	// Exe are patterns of the executable of the caller, one of them has to match
+	Exe []string
+	// Parent are patterns of the executables of the ancestors of the caller, one of them has to
+	// match any ancestor
+	Parent []string
+	// TTY is a pattern of the terminal of the caller, AnyTTY or NoTTY
+	TTY string
+}
+
+// ParseRule parses a rule written as the action followed by its conditions:
+//
+//	allow exe=git,gpg,ssh parent=tmux,sshd tty=*
+//
+// Executables are matched by name, or by path if the pattern contains a slash. Patterns use the
+// syntax of path.Match.
+func ParseRule(s string) (Rule, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return Rule{}, fmt.Errorf("empty rule")
+	}
+
+	action, err := ParseAction(fields[0])
+	if err != nil {
+		return Rule{}, err
+	}
+
+	r := Rule{Action: action}
+	for _, field := range fields[1:] {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return Rule{}, fmt.Errorf("invalid condition %q, expected exe=, parent= or tty=", field)
+		}
+
+		patterns := strings.Split(parts[1], ",")
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return Rule{}, fmt.Errorf("invalid pattern %q: %w", p, err)
+			}
+		}
+
+		switch parts[0] {
+		case "exe":
+			r.Exe = append(r.Exe, patterns...)
+		case "parent":
+			r.Parent = append(r.Parent, patterns...)
+		case "tty":
+			if len(patterns) > 1 {
+				return Rule{}, fmt.Errorf("invalid condition %q, only one terminal can be given", field)
+			}
+			r.TTY = parts[1]
+		default:
+			return Rule{}, fmt.Errorf("unknown condition %q, expected exe=, parent= or tty=", parts[0])
+		}
+	}
+
+	return r, nil
+}
+
+// String returns the rule as it is parsed by ParseRule
+func (r Rule) String() string {
+	parts := []string{r.Action.String()}
+	if len(r.Exe) > 0 {
+		parts = append(parts, "exe="+strings.Join(r.Exe, ","))
+	}
+	if len(r.Parent) > 0 {
+		parts = append(parts, "parent="+strings.Join(r.Parent, ","))
+	}
+	if r.TTY != "" {
+		parts = append(parts, "tty="+r.TTY)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// Caller is the process that asked for the PIN
+type Caller struct {
+	// Tree is the process followed by its ancestors, see process.Tree. It is empty if the caller
+	// is unknown, then only the rules without exe and parent conditions match.
+	Tree []process.Process
+	// TTY of the request as sent by the gpg-agent, used when the process has no terminal
+	TTY string
+}
+
+// tty returns the terminal of the caller
+func (c Caller) tty() string {
+	if len(c.Tree) > 0 && c.Tree[0].TTY != "" {
+		return c.Tree[0].TTY
+	}
+
+	return c.TTY
+}
+
+// matchExe reports whether the executable of p matches one of patterns. Only the executable
+// reported by the system is trusted, the command line is chosen by the process itself (exec -a).
+// A process whose executable is only known by name (e.g. one of another user) matches no pattern.
+func matchExe(patterns []string, p process.Process) bool {
+	if !path.IsAbs(p.Exe) {
+		return false
+	}
+
+	for _, pattern := range patterns {
+		name := path.Base(p.Exe)
+		if strings.Contains(pattern, "/") {
+			name = p.Exe
+		}
+
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchTTY reports whether tty matches pattern, by path or by name
+func matchTTY(pattern, tty string) bool {
+	switch pattern {
+	case "":
+		return true
+	case AnyTTY:
+		return tty != ""
+	case NoTTY:
+		return tty == ""
+	}
+
+	if tty == "" {
+		return false
+	}
+
+	if strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, tty)
+		return ok
+	}
+
+	ok, _ := path.Match(pattern, path.Base(tty))
+	return ok
+}
+
+// Match reports whether the rule matches c
+func (r Rule) Match(c Caller) bool {
+	if len(r.Exe) > 0 && (len(c.Tree) == 0 || !matchExe(r.Exe, c.Tree[0])) {
+		return false
+	}
+
+	if len(r.Parent) > 0 {
+		found := false
+		for i := 1; i < len(c.Tree) && !found; i++ {
+			found = matchExe(r.Parent, c.Tree[i])
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return matchTTY(r.TTY, c.tty())
+}
+
+// Policy is a list of rules evaluated in order, the zero value allows every caller
+type Policy struct {
+	Rules []Rule
+}
+
+// Parse returns the policy with the given rules, see ParseRule
+func Parse(rules []string) (Policy, error) {
+	var p Policy
+	for i, s := range rules {
+		r, err := ParseRule(s)
+		if err != nil {
+			return Policy{}, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+
+		p.Rules = append(p.Rules, r)
+	}
+
+	return p, nil
+}
+
+// Evaluate returns the action of the first rule that matches c and the rule itself. When no rule
+// matches the caller is allowed and the returned rule is nil.
+func (p Policy) Evaluate(c Caller) (Action, *Rule) {
+	for i := range p.Rules {
+		if p.Rules[i].Match(c) {
+			return p.Rules[i].Action, &p.Rules[i]
+		}
+	}
+
+	return Allow, nil
+}
+
+// Error explains why a request wasn't allowed
+type Error struct {
+	Rule   Rule
+	Caller string
+}
+
+func (e *Error) Error() string {
+	caller := e.Caller
+	if caller == "" {
+		caller = "an unknown caller"
+	}
+
+	verb := "denies"
+	if e.Rule.Action == Fallback {
+		verb = "requires typing the PIN for"
+	}
+
+	return fmt.Sprintf("the access policy %s %s (rule: %s)", verb, caller, e.Rule)
+}
+
+// Authenticator enforces a policy before the next authenticator is asked. The caller is looked up
+// in Processes from the owner of the request. A caller that falls back is reported as
+// auth.Unavailable, so the PIN is typed instead, and a denied one as auth.Forbidden. In both cases
+// the user isn't asked to authenticate.
+type Authenticator struct {
+	Policy    Policy
+	Processes process.Table
+	Next      auth.Authenticator
+}
+
+// caller looks up the caller of req, it is unknown if the owner isn't sent, is on another host or
+// isn't found
+func (a Authenticator) caller(req auth.Request) Caller {
+	c := Caller{TTY: req.Caller.TTYName}
+
+	owner, err := process.ParseOwner(req.Caller.Owner)
+	if err != nil || !owner.Local() {
+		return c
+	}
+
+	c.Tree, _ = process.Tree(a.Processes, owner.PID)
+	return c
+}
+
+// Authenticate evaluates the policy for the caller of req and asks the next authenticator if the
+// caller is allowed
+func (a Authenticator) Authenticate(ctx context.Context, req auth.Request) (auth.Result, error) {
+	if len(a.Policy.Rules) == 0 {
+		return a.Next.Authenticate(ctx, req)
+	}
+
+	c := a.caller(req)
+	action, rule := a.Policy.Evaluate(c)
+	if action == Allow {
+		return a.Next.Authenticate(ctx, req)
+	}
+
+	err := &Error{Rule: *rule, Caller: process.Chain(c.Tree)}
+	if action == Fallback {
+		return auth.Unavailable, err
+	}
+
+	return auth.Forbidden, err
+}
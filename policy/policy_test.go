@@ -0,0 +1,211 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package policy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/process"
+)
+
+// processes simulates the process table of a user working in a terminal, with a background job
+// started by launchd
+var processes = process.Fake{
+	1:   {PPID: 0, Exe: "/sbin/launchd"},
+	10:  {PPID: 1, Exe: "/usr/local/bin/tmux", Args: []string{"tmux"}},
+	11:  {PPID: 10, Exe: "/bin/bash", Args: []string{"-bash"}, TTY: "/dev/ttys001"},
+	12:  {PPID: 11, Exe: "/usr/bin/git", Args: []string{"git", "commit", "-S"}, TTY: "/dev/ttys001"},
+	13:  {PPID: 12, Exe: "/usr/local/bin/gpg", Args: []string{"gpg", "-bsau", "KEYID"}, TTY: "/dev/ttys001"},
+	14:  {PPID: 11, Exe: "/usr/bin/ssh", Args: []string{"ssh", "host"}, TTY: "/dev/ttys001"},
+	20:  {PPID: 1, Exe: "/usr/local/bin/backup-job", Args: []string{"backup-job"}},
+	21:  {PPID: 20, Exe: "/usr/local/bin/gpg", Args: []string{"gpg", "--decrypt"}},
+	30:  {PPID: 11, Exe: "/tmp/evil", Args: []string{"/tmp/evil"}, TTY: "/dev/ttys001"},
+	31:  {PPID: 30, Exe: "/usr/local/bin/gpg", Args: []string{"gpg", "--decrypt"}, TTY: "/dev/ttys001"},
+	40:  {PPID: 11, Exe: "/tmp/anything", Args: []string{"git", "commit"}, TTY: "/dev/ttys001"},
+	100: {PPID: 1, Exe: "/Applications/Mail.app/Contents/MacOS/Mail"},
+}
+
+// callerOf returns the caller with the given PID in processes
+func callerOf(t *testing.T, pid int) Caller {
+	t.Helper()
+
+	tree, err := process.Tree(processes, pid)
+	if err != nil {
+		t.Fatalf("the process %d should be found: %s", pid, err)
+	}
+
+	return Caller{Tree: tree}
+}
+
+func TestParseRule(t *testing.T) {
+	r, err := ParseRule("allow exe=git,gpg parent=tmux,sshd parent=login tty=ttys*")
+	if err != nil {
+		t.Fatalf("parsing a valid rule should succeed: %s", err)
+	}
+
+	want := Rule{Action: Allow, Exe: []string{"git", "gpg"}, Parent: []string{"tmux", "sshd", "login"}, TTY: "ttys*"}
+	if !reflect.DeepEqual(r, want) {
+		t.Fatalf("rule mismatch got: %+v want: %+v", r, want)
+	}
+	if got := r.String(); got != "allow exe=git,gpg parent=tmux,sshd,login tty=ttys*" {
+		t.Fatalf("unexpected string: %s", got)
+	}
+
+	if r, err := ParseRule("fallback"); err != nil || r.Action != Fallback || r.String() != "fallback" {
+		t.Fatalf("a rule without conditions should parse: %+v (%v)", r, err)
+	}
+
+	for _, s := range []string{"", "permit", "deny exe", "deny exe=", "deny user=me", "deny exe=[", "deny tty=a,b"} {
+		if _, err := ParseRule(s); err == nil {
+			t.Fatalf("parsing %q should fail", s)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	p, err := Parse([]string{
+		"deny exe=/tmp/*",
+		"deny parent=/tmp/*",
+		"allow exe=gpg,ssh parent=tmux,sshd tty=*",
+		"fallback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		caller Caller
+		action Action
+		rule   int
+	}{
+		{"git commit in tmux", callerOf(t, 13), Allow, 2},
+		{"ssh in tmux", callerOf(t, 14), Allow, 2},
+		{"background job", callerOf(t, 21), Fallback, 3},
+		{"started by a binary in /tmp", callerOf(t, 31), Deny, 1},
+		{"binary in /tmp", callerOf(t, 30), Deny, 0},
+		// the name the process was started with isn't trusted
+		{"binary in /tmp started as git", callerOf(t, 40), Deny, 0},
+		{"not in the allowed list", callerOf(t, 100), Fallback, 3},
+		{"unknown caller", Caller{TTY: "/dev/ttys001"}, Fallback, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action, rule := p.Evaluate(tt.caller)
+			if action != tt.action {
+				t.Fatalf("action mismatch got: %s want: %s", action, tt.action)
+			}
+			if rule != &p.Rules[tt.rule] {
+				t.Fatalf("rule mismatch got: %v want: %s", rule, p.Rules[tt.rule])
+			}
+		})
+	}
+
+	if action, rule := (Policy{}).Evaluate(callerOf(t, 21)); action != Allow || rule != nil {
+		t.Fatalf("an empty policy should allow every caller, got: %s %v", action, rule)
+	}
+}
+
+func TestMatchTTY(t *testing.T) {
+	tests := []struct {
+		rule   string
+		caller Caller
+		want   bool
+	}{
+		{"allow tty=none", callerOf(t, 21), true},
+		{"allow tty=none", callerOf(t, 13), false},
+		{"allow tty=*", callerOf(t, 21), false},
+		{"allow tty=/dev/ttys00?", callerOf(t, 13), true},
+		{"allow tty=pts*", callerOf(t, 13), false},
+		// the terminal sent by the gpg-agent is used when the process has none
+		{"allow tty=*", Caller{Tree: callerOf(t, 21).Tree, TTY: "/dev/ttys002"}, true},
+		{"allow exe=bash", callerOf(t, 11), true},
+		{"allow exe=/usr/bin/*", callerOf(t, 12), true},
+		{"allow exe=/usr/bin/*", callerOf(t, 13), false},
+		{"allow exe=git", callerOf(t, 40), false},
+		{"allow exe=git", Caller{Tree: []process.Process{{PID: 50, Exe: "git"}}}, false},
+	}
+
+	for _, tt := range tests {
+		r, err := ParseRule(tt.rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := r.Match(tt.caller); got != tt.want {
+			t.Fatalf("%s %s: match mismatch got: %t want: %t", tt.rule, process.Chain(tt.caller.Tree), got, tt.want)
+		}
+	}
+}
+
+func TestAuthenticator(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	p, err := Parse([]string{"deny exe=evil", "allow exe=gpg parent=git", "fallback"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		owner  string
+		result auth.Result
+		asked  bool
+	}{
+		{"13/501 " + host, auth.Success, true},
+		{"21/501 " + host, auth.Unavailable, false},
+		{"30/501 " + host, auth.Forbidden, false},
+		// the process of another host can't be looked up
+		{"13/501 elsewhere.invalid", auth.Unavailable, false},
+		{"", auth.Unavailable, false},
+	}
+
+	for _, tt := range tests {
+		next := auth.NewScripted(auth.Success)
+		a := Authenticator{Policy: p, Processes: processes, Next: next}
+
+		req := auth.Request{Caller: auth.Caller{Owner: tt.owner}}
+		result, err := a.Authenticate(context.Background(), req)
+		if result != tt.result {
+			t.Fatalf("%q: result mismatch got: %s want: %s", tt.owner, result, tt.result)
+		}
+
+		if asked := len(next.Requests()) > 0; asked != tt.asked {
+			t.Fatalf("%q: the next authenticator should be asked: %t", tt.owner, tt.asked)
+		}
+
+		var policyErr *Error
+		if !tt.asked && !errors.As(err, &policyErr) {
+			t.Fatalf("%q: the error should explain the decision, got: %v", tt.owner, err)
+		}
+	}
+
+	// without rules every request is passed on
+	next := auth.NewScripted(auth.Declined)
+	a := Authenticator{Processes: processes, Next: next}
+	if result, _ := a.Authenticate(context.Background(), auth.Request{}); result != auth.Declined {
+		t.Fatalf("an empty policy should ask the next authenticator, got: %s", result)
+	}
+}
+
+func TestError(t *testing.T) {
+	err := &Error{Rule: Rule{Action: Fallback}, Caller: process.Chain(callerOf(t, 21).Tree)}
+	if got := err.Error(); got != "the access policy requires typing the PIN for gpg ← backup-job (rule: fallback)" {
+		t.Fatalf("unexpected message: %s", got)
+	}
+
+	err = &Error{Rule: Rule{Action: Deny, Exe: []string{"evil"}}}
+	if got := err.Error(); got != "the access policy denies an unknown caller (rule: deny exe=evil)" {
+		t.Fatalf("unexpected message: %s", got)
+	}
+}
@@ -0,0 +1,13 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+//
+//go:build !linux
+// +build !linux
+
+package process
+
+// Local returns the process table of the system
+func Local() Table {
+	return PS{}
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package process identifies the process that asked the gpg-agent for a PIN. The gpg-agent sends
+// its owner as OPTION owner=<pid>/<uid> <host>, the process and its ancestors are then looked up
+// in the process table of the system, e.g. git commit ← bash ← tmux.
+package process
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MaxDepth is the number of ancestors looked up by Tree
+const MaxDepth = 16
+
+// ErrNotFound is returned when a process isn't in the process table
+var ErrNotFound = errors.New("no such process")
+
+// Owner is the client of the gpg-agent
+type Owner struct {
+	PID  int
+	UID  int
+	Host string
+}
+
+// ParseOwner parses the owner sent by the gpg-agent: <pid>/<uid> <host>. The uid and host are
+// optional.
+func ParseOwner(s string) (Owner, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return Owner{}, errors.New("empty owner")
+	}
+
+	var o Owner
+	if len(fields) > 1 {
+		o.Host = fields[1]
+	}
+
+	ids := strings.SplitN(fields[0], "/", 2)
+	pid, err := strconv.Atoi(ids[0])
+	if err != nil || pid <= 0 {
+		return Owner{}, fmt.Errorf("invalid owner %q", s)
+	}
+	o.PID, o.UID = pid, -1
+
+	if len(ids) == 2 {
+		if o.UID, err = strconv.Atoi(ids[1]); err != nil {
+			return Owner{}, fmt.Errorf("invalid owner %q", s)
+		}
+	}
+
+	return o, nil
+}
+
+// Local reports whether the owner runs on this host. A gpg-agent forwarded over SSH reports the
+// host of the client, its process can't be looked up here.
+func (o Owner) Local() bool {
+	if o.Host == "" {
+		return true
+	}
+
+	host, err := os.Hostname()
+	return err == nil && strings.EqualFold(o.Host, host)
+}
+
+// Process is an entry of the process table
+type Process struct {
+	PID  int
+	PPID int
+	UID  int
+	// Exe is the path of the executable, or its name if the path is unknown
+	Exe string
+	// Args is the command line, the first one is the name the process was started with
+	Args []string
+	// TTY is the controlling terminal (e.g. /dev/ttys001), empty if there is none
+	TTY string
+}
+
+// Name describes the process by the name of its executable followed by its first argument, unless
+// it is an option: git commit, bash, ssh. The name the process was started with is chosen by its
+// parent (exec -a), it is only used if the executable is unknown.
+func (p Process) Name() string {
+	name := filepath.Base(p.Exe)
+	if p.Exe == "" && len(p.Args) > 0 && p.Args[0] != "" {
+		name = filepath.Base(strings.TrimPrefix(p.Args[0], "-"))
+	}
+
+	if len(p.Args) > 1 && !strings.HasPrefix(p.Args[1], "-") {
+		name += " " + p.Args[1]
+	}
+
+	return name
+}
+
+// Table looks up processes
+type Table interface {
+	// Process returns the process with the given PID, ErrNotFound if there is none
+	Process(pid int) (Process, error)
+}
+
+// Tree returns the process with the given PID followed by its ancestors, up to MaxDepth of them.
+// The init process is left out. It fails only if the process itself can't be found, the chain of
+// ancestors stops at the first one that can't be looked up.
+func Tree(t Table, pid int) ([]Process, error) {
+	p, err := t.Process(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := []Process{p}
+	seen := map[int]bool{p.PID: true}
+	for len(tree) <= MaxDepth && p.PPID > 1 && !seen[p.PPID] {
+		if p, err = t.Process(p.PPID); err != nil {
+			break
+		}
+
+		seen[p.PID] = true
+		tree = append(tree, p)
+	}
+
+	return tree, nil
+}
+
+// Chain describes a tree returned by Tree with the name of every process, from the requesting
+// process to its oldest ancestor: git commit ← bash ← tmux
+func Chain(tree []Process) string {
+	names := make([]string, len(tree))
+	for i, p := range tree {
+		names[i] = p.Name()
+	}
+
+	return strings.Join(names, " ← ")
+}
+
+// Fake is a Table of predefined processes, meant to be used in tests
+type Fake map[int]Process
+
+// Process returns the process of the fake table with the given PID
+func (f Fake) Process(pid int) (Process, error) {
+	p, ok := f[pid]
+	if !ok {
+		return Process{}, ErrNotFound
+	}
+
+	p.PID = pid
+	return p, nil
+}
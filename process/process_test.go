@@ -0,0 +1,226 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package process
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestParseOwner(t *testing.T) {
+	tests := []struct {
+		owner string
+		want  Owner
+	}{
+		{"1234/501 macbook.local", Owner{PID: 1234, UID: 501, Host: "macbook.local"}},
+		{"1234/501", Owner{PID: 1234, UID: 501}},
+		{"1234", Owner{PID: 1234, UID: -1}},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseOwner(tt.owner)
+		if err != nil || got != tt.want {
+			t.Fatalf("%q: owner mismatch got: %+v (%v) want: %+v", tt.owner, got, err, tt.want)
+		}
+	}
+
+	for _, owner := range []string{"", "abc/501 host", "0/501", "1234/abc host"} {
+		if _, err := ParseOwner(owner); err == nil {
+			t.Fatalf("parsing %q should fail", owner)
+		}
+	}
+}
+
+func TestOwnerLocal(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	if !(Owner{PID: 1, Host: host}).Local() || !(Owner{PID: 1}).Local() {
+		t.Fatalf("an owner on this host should be local")
+	}
+	if (Owner{PID: 1, Host: "elsewhere.invalid"}).Local() {
+		t.Fatalf("an owner on another host shouldn't be local")
+	}
+}
+
+// gitTree is the process tree of a commit signed from a shell in tmux
+var gitTree = Fake{
+	1:   {PPID: 0, Exe: "/sbin/launchd"},
+	10:  {PPID: 1, Exe: "/usr/local/bin/tmux", Args: []string{"tmux", "-u"}},
+	20:  {PPID: 10, Exe: "/bin/bash", Args: []string{"-bash"}},
+	30:  {PPID: 20, Exe: "/usr/bin/git", Args: []string{"git", "commit", "-S"}},
+	40:  {PPID: 30, Exe: "/usr/local/bin/gpg", Args: []string{"gpg", "--status-fd=2", "-bsau", "KEYID"}},
+	50:  {PPID: 99, Exe: "/usr/bin/orphan"},
+	60:  {PPID: 61, Exe: "a"},
+	61:  {PPID: 60, Exe: "b"},
+	100: {PPID: 100, Exe: "self"},
+}
+
+func TestTree(t *testing.T) {
+	tree, err := Tree(gitTree, 40)
+	if err != nil {
+		t.Fatalf("the tree should be found: %s", err)
+	}
+
+	if got, want := Chain(tree), "gpg ← git commit ← bash ← tmux"; got != want {
+		t.Fatalf("chain mismatch got: %q want: %q", got, want)
+	}
+	if tree[0].PID != 40 || tree[3].PID != 10 {
+		t.Fatalf("the tree should start with the process and end before init: %+v", tree)
+	}
+
+	// the parent of the orphan is gone
+	if tree, err := Tree(gitTree, 50); err != nil || len(tree) != 1 {
+		t.Fatalf("the tree should stop at the missing parent: %+v (%v)", tree, err)
+	}
+
+	// a loop in the table doesn't hang
+	if tree, err := Tree(gitTree, 60); err != nil || Chain(tree) != "a ← b" {
+		t.Fatalf("the tree should stop at the loop: %+v (%v)", tree, err)
+	}
+	if tree, err := Tree(gitTree, 100); err != nil || len(tree) != 1 {
+		t.Fatalf("the tree should stop at the loop: %+v (%v)", tree, err)
+	}
+
+	if _, err := Tree(gitTree, 999); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("a missing process should fail, got: %v", err)
+	}
+}
+
+func TestName(t *testing.T) {
+	tests := []struct {
+		p    Process
+		want string
+	}{
+		{Process{Exe: "/usr/bin/git", Args: []string{"git", "commit", "-S"}}, "git commit"},
+		{Process{Exe: "/bin/bash", Args: []string{"-bash"}}, "bash"},
+		{Process{Exe: "/usr/local/bin/gpg", Args: []string{"gpg", "--decrypt"}}, "gpg"},
+		// the name the process was started with is not trusted
+		{Process{Exe: "/tmp/evil", Args: []string{"git", "commit"}}, "evil commit"},
+		{Process{Args: []string{"-bash"}}, "bash"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.p.Name(); got != tt.want {
+			t.Fatalf("name of %+v mismatch got: %q want: %q", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestTreeMaxDepth(t *testing.T) {
+	deep := Fake{}
+	for pid := 2; pid < 100; pid++ {
+		deep[pid] = Process{PPID: pid - 1, Exe: "sh"}
+	}
+
+	if tree, _ := Tree(deep, 99); len(tree) != MaxDepth+1 {
+		t.Fatalf("the tree should be limited to %d ancestors, got: %d", MaxDepth, len(tree)-1)
+	}
+}
+
+// writeProc creates the entry of a process in a fake proc file system
+func writeProc(t *testing.T, root string, pid int, stat, cmdline, status string) {
+	t.Helper()
+
+	dir := filepath.Join(root, strconv.Itoa(pid))
+	if err := os.MkdirAll(filepath.Join(dir, "fd"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string]string{"stat": stat, "cmdline": cmdline, "status": status} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestProcFS(t *testing.T) {
+	root := t.TempDir()
+	writeProc(t, root, 30, "30 (git) S 20 30 20 34816 30 4194304", "git\x00commit\x00-S\x00", "Name:\tgit\nUid:\t1000\t1000\t1000\t1000\n")
+	writeProc(t, root, 20, "20 (tmux: server (1)) S 1 20", "", "")
+	if err := os.Symlink("/dev/pts/3", filepath.Join(root, "30", "fd", "0")); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := ProcFS{Root: root}
+	p, err := fs.Process(30)
+	if err != nil {
+		t.Fatalf("reading the process should succeed: %s", err)
+	}
+
+	want := Process{PID: 30, PPID: 20, UID: 1000, Exe: "git", Args: []string{"git", "commit", "-S"}, TTY: "/dev/pts/3"}
+	if !reflect.DeepEqual(p, want) {
+		t.Fatalf("process mismatch got: %+v want: %+v", p, want)
+	}
+
+	// the name may contain parentheses and spaces, the other files may not be readable
+	if p, err := fs.Process(20); err != nil || p.PPID != 1 || p.Exe != "tmux: server (1)" || p.UID != -1 {
+		t.Fatalf("unexpected process: %+v (%v)", p, err)
+	}
+
+	if _, err := fs.Process(40); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("a missing process should fail, got: %v", err)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("no process table on", runtime.GOOS)
+	}
+
+	tree, err := Tree(Local(), os.Getpid())
+	if err != nil {
+		t.Fatalf("the tree of the test should be found: %s", err)
+	}
+
+	if tree[0].PID != os.Getpid() || tree[0].PPID != os.Getppid() || tree[0].UID != os.Getuid() {
+		t.Fatalf("unexpected process: %+v", tree[0])
+	}
+}
+
+func TestPS(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip(err)
+	}
+
+	// the output of ps on macOS
+	ps := filepath.Join(t.TempDir(), "ps")
+	script := `#!/bin/sh
+case "$2-$4" in
+ppid=,uid=,tty=,comm=-30) echo "   20   501 ttys001  /Applications/Dev Tools/git" ;;
+args=-30) echo "git commit -S" ;;
+ppid=,uid=,tty=,comm=-20) echo "    1   501 ??       /bin/bash" ;;
+*) exit 1 ;;
+esac
+`
+	if err := os.WriteFile(ps, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := PS{Program: ps}.Process(30)
+	if err != nil {
+		t.Fatalf("reading the process should succeed: %s", err)
+	}
+
+	want := Process{PID: 30, PPID: 20, UID: 501, Exe: "/Applications/Dev Tools/git", Args: []string{"git", "commit", "-S"}, TTY: "/dev/ttys001"}
+	if !reflect.DeepEqual(p, want) {
+		t.Fatalf("process mismatch got: %+v want: %+v", p, want)
+	}
+
+	if p, err := (PS{Program: ps}).Process(20); err != nil || p.TTY != "" || p.Name() != "bash" {
+		t.Fatalf("unexpected process: %+v (%v)", p, err)
+	}
+
+	if _, err := (PS{Program: ps}).Process(40); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("a missing process should fail, got: %v", err)
+	}
+}
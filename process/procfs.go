@@ -0,0 +1,93 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package process
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ProcFS reads the process table from a proc file system, as found on Linux
+type ProcFS struct {
+	// Root is the mount point, usually /proc
+	Root string
+}
+
+// Process reads the process with the given PID from its directory in the proc file system
+func (fs ProcFS) Process(pid int) (Process, error) {
+	dir := filepath.Join(fs.Root, strconv.Itoa(pid))
+
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if errors.Is(err, os.ErrNotExist) {
+		return Process{}, ErrNotFound
+	}
+	if err != nil {
+		return Process{}, err
+	}
+
+	p := Process{PID: pid, UID: -1}
+
+	// the name is between parentheses and may contain spaces or parentheses itself:
+	// pid (name) state ppid ...
+	open, end := bytes.IndexByte(stat, '('), bytes.LastIndexByte(stat, ')')
+	if open < 0 || end < open {
+		return Process{}, fmt.Errorf("%s: invalid stat", dir)
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 2 {
+		return Process{}, fmt.Errorf("%s: invalid stat", dir)
+	}
+	if p.PPID, err = strconv.Atoi(fields[1]); err != nil {
+		return Process{}, fmt.Errorf("%s: invalid stat", dir)
+	}
+	p.Exe = string(stat[open+1 : end])
+
+	// the executable and the descriptors of processes of other users can't be read
+	if exe, err := os.Readlink(filepath.Join(dir, "exe")); err == nil {
+		p.Exe = exe
+	}
+	if tty, err := os.Readlink(filepath.Join(dir, "fd", "0")); err == nil && isTTY(tty) {
+		p.TTY = tty
+	}
+
+	if cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline")); err == nil && len(cmdline) > 0 {
+		p.Args = strings.Split(strings.TrimSuffix(string(cmdline), "\x00"), "\x00")
+	}
+
+	if uid, err := readUID(filepath.Join(dir, "status")); err == nil {
+		p.UID = uid
+	}
+
+	return p, nil
+}
+
+// isTTY reports whether path is a terminal device
+func isTTY(path string) bool {
+	return strings.HasPrefix(path, "/dev/pts/") || strings.HasPrefix(path, "/dev/tty")
+}
+
+// readUID returns the real user ID from a status file
+func readUID(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return -1, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 1 && fields[0] == "Uid:" {
+			return strconv.Atoi(fields[1])
+		}
+	}
+
+	return -1, fmt.Errorf("%s: no Uid", path)
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package process
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// PS reads the process table with the ps program, as found on macOS
+type PS struct {
+	// Program is the ps binary, "ps" if empty
+	Program string
+}
+
+// run executes ps for pid with the given output format and returns its output, a missing process
+// is ErrNotFound
+func (ps PS) run(pid int, format string) (string, error) {
+	program := ps.Program
+	if program == "" {
+		program = "ps"
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.Command(program, "-o", format, "-p", strconv.Itoa(pid))
+	cmd.Stdout = &stdout
+
+	err := cmd.Run()
+	out := strings.TrimSpace(stdout.String())
+	if out == "" {
+		// ps exits with an error when no process matches
+		if _, ok := err.(*exec.ExitError); ok || err == nil {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("%s: %w", program, err)
+	}
+
+	return out, nil
+}
+
+// Process looks up the process with the given PID
+func (ps PS) Process(pid int) (Process, error) {
+	// the command is last, its path may contain spaces
+	out, err := ps.run(pid, "ppid=,uid=,tty=,comm=")
+	if err != nil {
+		return Process{}, err
+	}
+
+	fields := strings.Fields(out)
+	if len(fields) < 4 {
+		return Process{}, fmt.Errorf("unexpected ps output %q", out)
+	}
+
+	p := Process{PID: pid}
+	if p.PPID, err = strconv.Atoi(fields[0]); err != nil {
+		return Process{}, fmt.Errorf("unexpected ps output %q", out)
+	}
+	if p.UID, err = strconv.Atoi(fields[1]); err != nil {
+		return Process{}, fmt.Errorf("unexpected ps output %q", out)
+	}
+	if tty := fields[2]; tty != "??" && tty != "?" {
+		p.TTY = "/dev/" + tty
+	}
+
+	rest := out
+	for i := 0; i < 3; i++ {
+		rest = strings.TrimSpace(rest)
+		rest = rest[len(fields[i]):]
+	}
+	p.Exe = strings.TrimSpace(rest)
+
+	// the arguments are separated by spaces, an argument with spaces can't be told apart
+	if args, err := ps.run(pid, "args="); err == nil {
+		p.Args = strings.Fields(args)
+	}
+
+	return p, nil
+}
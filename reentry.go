@@ -0,0 +1,201 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+//
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/foxcpp/go-assuan/pinentry"
+	"github.com/jorgelbg/pinentry-touchid/audit"
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/config"
+	"github.com/jorgelbg/pinentry-touchid/logging"
+	"github.com/jorgelbg/pinentry-touchid/metadata"
+	"github.com/jorgelbg/pinentry-touchid/secret"
+)
+
+// reentryDue reports whether the cached passphrase of label has to be typed again. The check is
+// skipped when the metadata can't be read.
+func reentryDue(entries *metadata.Store, s pinentry.Settings, label string, logger *logging.Logger) bool {
+	if entries == nil {
+		return false
+	}
+
+	due, err := entries.ReentryDue(entryRecord(s, label).Keygrip)
+	if err != nil {
+		logger.Warn("Error reading the metadata", "label", label, "err", err)
+		return false
+	}
+
+	return due
+}
+
+// reenterPIN asks the user to type the passphrase of label instead of releasing it from the
+// keychain. The user authenticates first, like for a release, since the stored passphrase is read
+// and may be replaced. When the typed passphrase doesn't match the stored one the user is asked to
+// type it twice, the stored passphrase is replaced with it unless it matches this time. When the
+// user can't authenticate the typed passphrase is passed on without being compared.
+func reenterPIN(authenticator auth.Authenticator, s pinentry.Settings, caller requestCaller, label string,
+	promptFn PromptFunc, entries *metadata.Store, event *audit.Record, logger *logging.Logger) ([]byte, error) {
+	prompt := s
+	prompt.Desc = fmt.Sprintf("Please type the passphrase of %s, it is asked from time to time so "+
+		"you don't forget it.", label)
+
+	result, err := authenticateEvent(authenticator, s,
+		caller.reason(fmt.Sprintf("verify the passphrase of %s", label)), label, event)
+	switch result {
+	case auth.Success:
+	case auth.Mismatch, auth.LockedOut, auth.NotEnrolled, auth.Unavailable:
+		logger.Info("Authentication not possible, the passphrase isn't compared with the stored one",
+			"label", label, "result", result, "err", err)
+		return promptFn(prompt)
+	default:
+		return nil, authError{result, err}
+	}
+
+	stored, err := passwordFromKeychain(label, keygripFromKeyInfo(s.KeyInfo))
+	if err != nil {
+		return nil, storeError{err}
+	}
+	defer stored.Wipe()
+
+	pin, err := promptFn(prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare(pin, stored.Bytes()) != 1 {
+		secret.Wipe(pin)
+
+		prompt.Error = "The passphrase doesn't match the one stored in the keychain"
+		prompt.Desc = fmt.Sprintf("Please type the passphrase of %s twice, it replaces the one "+
+			"stored in the keychain if they are different.", label)
+		prompt.RepeatPrompt = "Repeat:"
+		prompt.RepeatError = "Passphrases don't match"
+
+		if pin, err = promptFn(prompt); err != nil {
+			return nil, err
+		}
+
+		if len(pin) == 0 {
+			return nil, fmt.Errorf("%w: no passphrase was typed", errCanceled)
+		}
+
+		if subtle.ConstantTimeCompare(pin, stored.Bytes()) != 1 {
+			if err := updatePasswordInKeychain(label, keygripFromKeyInfo(s.KeyInfo), pin); err != nil {
+				secret.Wipe(pin)
+				return nil, storeError{err}
+			}
+
+			logger.Info("The passphrase was replaced in the keychain", "label", label)
+		}
+	}
+
+	recordVerified(entries, entryRecord(s, label), logger)
+
+	return pin, nil
+}
+
+// reentryFromConfig returns the default re-entry limits configured by the user
+func reentryFromConfig(cfg config.Config) metadata.Reentry {
+	return metadata.Reentry{MaxAge: cfg.ReentryMaxAge, Every: cfg.ReentryEvery}
+}
+
+// setReentry overrides the re-entry limits of the entry given by label or keygrip. The limits are
+// given as: <max-age> [<every>], either of them can be "default" or "off".
+func setReentry(w io.Writer, entries *metadata.Store, args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return fmt.Errorf("usage: reentry <label or keygrip> <max-age> [<every>]")
+	}
+
+	r, err := findEntry(entries, args[0])
+	if err != nil {
+		return err
+	}
+
+	limits := r.Reentry
+	if limits.MaxAge, err = parseMaxAge(args[1]); err != nil {
+		return err
+	}
+
+	if len(args) == 3 {
+		if limits.Every, err = parseEvery(args[2]); err != nil {
+			return err
+		}
+	}
+
+	if err := entries.SetReentry(r.Keygrip, limits); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "%s: max age %s, every %s\n", r.Label, formatMaxAge(limits.MaxAge), formatEvery(limits.Every))
+
+	return nil
+}
+
+// parseMaxAge parses the max age of an entry, "default" uses the configured one and "off" disables
+// it
+func parseMaxAge(value string) (time.Duration, error) {
+	switch value {
+	case "default":
+		return 0, nil
+	case "off":
+		return -1, nil
+	}
+
+	d, err := config.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid max age %q", value)
+	}
+
+	return d, nil
+}
+
+// parseEvery parses the number of uses after which an entry has to be typed, "default" uses the
+// configured one and "off" disables it
+func parseEvery(value string) (int, error) {
+	switch value {
+	case "default":
+		return 0, nil
+	case "off":
+		return -1, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid number of uses %q", value)
+	}
+
+	return n, nil
+}
+
+func formatMaxAge(d time.Duration) string {
+	switch {
+	case d == 0:
+		return "default"
+	case d < 0:
+		return "off"
+	}
+
+	return d.String()
+}
+
+func formatEvery(n int) string {
+	switch {
+	case n == 0:
+		return "default"
+	case n < 0:
+		return "off"
+	}
+
+	return strconv.Itoa(n) + " uses"
+}
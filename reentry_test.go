@@ -0,0 +1,119 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/foxcpp/go-assuan/pinentry"
+	"github.com/jorgelbg/pinentry-touchid/auth"
+	"github.com/jorgelbg/pinentry-touchid/logging"
+	"github.com/jorgelbg/pinentry-touchid/metadata"
+)
+
+func TestGetPINReentry(t *testing.T) {
+	keychainLabel := `Firstname Lastname <test@email.com> (61AF059BD632F971)`
+	defer func() { _ = cleanKeychain(keychainLabel) }()
+
+	if err := storePasswordInKeychain(keychainLabel, keygripFromKeyInfo(keyInfo), []byte(testPassword)); err != nil {
+		t.Fatalf("failed precreating entry in the Keychain: %s", err)
+	}
+
+	logger := logging.New(ioutil.Discard, logging.LevelDebug, logging.FormatText)
+	// every use has to be typed
+	entries := metadata.NewStore(filepath.Join(t.TempDir(), metadata.DefaultFilename), metadata.Reentry{Every: 1})
+	params := pinentry.Settings{
+		Desc:    keyDesc,
+		KeyInfo: keyInfo,
+	}
+
+	tests := []struct {
+		typed   []string
+		want    string
+		prompts int
+	}{
+		{typed: []string{testPassword}, want: testPassword, prompts: 1},
+		// a typo followed by the stored passphrase
+		{typed: []string{"typo", testPassword}, want: testPassword, prompts: 2},
+		// a new passphrase replaces the stored one
+		{typed: []string{"typo", "newpassphrase"}, want: "newpassphrase", prompts: 2},
+	}
+
+	for _, tt := range tests {
+		var prompts []pinentry.Settings
+		prompt := func(s pinentry.Settings) ([]byte, error) {
+			prompts = append(prompts, s)
+			return []byte(tt.typed[len(prompts)-1]), nil
+		}
+
+		authenticator := auth.NewScripted(auth.Success)
+		pass, pinErr := GetPIN(authenticator, prompt, entries, nil, logger)(params)
+		if pinErr != nil {
+			t.Fatalf("%v: call to GetPIN should succeed: %s", tt.typed, pinErr)
+		}
+
+		if string(pass) != tt.want || len(prompts) != tt.prompts {
+			t.Fatalf("%v: got %q after %d prompts, want: %q after %d", tt.typed, pass, len(prompts), tt.want, tt.prompts)
+		}
+
+		if len(authenticator.Requests()) != 1 {
+			t.Fatalf("%v: the user should authenticate once before the passphrase is compared", tt.typed)
+		}
+
+		if len(prompts) == 2 && prompts[1].RepeatPrompt == "" {
+			t.Fatalf("%v: the replacement should be typed twice", tt.typed)
+		}
+
+		stored, err := passwordFromKeychain(keychainLabel, "")
+		if err != nil || string(stored.Bytes()) != tt.want {
+			t.Fatalf("%v: the keychain should hold %q: %v", tt.typed, tt.want, err)
+		}
+	}
+
+	r, _, _ := entries.Get("8043823CBC5C5A0C66866520F333076D")
+	if r.LastVerified.IsZero() || r.UsesSinceVerified != 0 || r.UseCount != 3 {
+		t.Fatalf("typing the passphrase should restart the limits: %+v", r)
+	}
+}
+
+func TestGetPINReentryNotAuthenticated(t *testing.T) {
+	keychainLabel := `Firstname Lastname <test@email.com> (61AF059BD632F971)`
+	defer func() { _ = cleanKeychain(keychainLabel) }()
+
+	if err := storePasswordInKeychain(keychainLabel, keygripFromKeyInfo(keyInfo), []byte(testPassword)); err != nil {
+		t.Fatalf("failed precreating entry in the Keychain: %s", err)
+	}
+
+	logger := logging.New(ioutil.Discard, logging.LevelDebug, logging.FormatText)
+	entries := metadata.NewStore(filepath.Join(t.TempDir(), metadata.DefaultFilename), metadata.Reentry{Every: 1})
+	params := pinentry.Settings{
+		Desc:    keyDesc,
+		KeyInfo: keyInfo,
+	}
+
+	var prompts int
+	prompt := func(s pinentry.Settings) ([]byte, error) {
+		prompts++
+		return []byte("newpassphrase"), nil
+	}
+
+	// a declined authentication doesn't prompt
+	if _, pinErr := GetPIN(auth.NewScripted(auth.Declined), prompt, entries, nil, logger)(params); pinErr == nil || prompts != 0 {
+		t.Fatalf("a declined authentication should fail without a prompt, got: %v after %d prompts", pinErr, prompts)
+	}
+
+	// without authentication the typed passphrase is passed on but doesn't replace the stored one
+	pass, pinErr := GetPIN(auth.NewScripted(auth.Unavailable), prompt, entries, nil, logger)(params)
+	if pinErr != nil || string(pass) != "newpassphrase" || prompts != 1 {
+		t.Fatalf("the typed passphrase should be passed on: %q (%v) after %d prompts", pass, pinErr, prompts)
+	}
+
+	stored, err := passwordFromKeychain(keychainLabel, "")
+	if err != nil || string(stored.Bytes()) != testPassword {
+		t.Fatalf("the stored passphrase should not be replaced without authentication: %v", err)
+	}
+}
@@ -0,0 +1,16 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+//
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package secret
+
+import "errors"
+
+var errNotSupported = errors.New("locking memory is not supported on this platform")
+
+func lock([]byte) error { return errNotSupported }
+
+func unlock([]byte) error { return errNotSupported }
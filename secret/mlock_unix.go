@@ -0,0 +1,26 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+//
+//go:build darwin || linux
+// +build darwin linux
+
+package secret
+
+import "golang.org/x/sys/unix"
+
+func lock(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+
+	return unix.Mlock(b)
+}
+
+func unlock(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+
+	return unix.Munlock(b)
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package secret holds PIN material in byte buffers that are wiped once they are not needed
+// anymore. Strings are avoided on purpose: they are immutable and their copies can't be cleared.
+package secret
+
+// Buffer holds a secret. The memory is locked (if the platform allows it) so it is not written to
+// swap, failing to lock it is not an error.
+type Buffer struct {
+	b      []byte
+	locked bool
+}
+
+// New returns a buffer that takes ownership of b, the caller should not keep other references to
+// it
+func New(b []byte) *Buffer {
+	return &Buffer{b: b, locked: lock(b) == nil}
+}
+
+// Bytes returns the secret, the slice is only valid until Wipe is called
+func (s *Buffer) Bytes() []byte {
+	if s == nil {
+		return nil
+	}
+
+	return s.b
+}
+
+// Len returns the length of the secret
+func (s *Buffer) Len() int {
+	return len(s.Bytes())
+}
+
+// Locked reports whether the memory of the secret is locked
+func (s *Buffer) Locked() bool {
+	return s != nil && s.locked
+}
+
+// Wipe overwrites the secret with zeros and unlocks its memory. It is safe to call Wipe more than
+// once.
+func (s *Buffer) Wipe() {
+	if s == nil {
+		return
+	}
+
+	Wipe(s.b)
+	if s.locked {
+		_ = unlock(s.b)
+		s.locked = false
+	}
+	s.b = nil
+}
+
+// Wipe overwrites b with zeros
+func Wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
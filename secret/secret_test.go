@@ -0,0 +1,33 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package secret
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBufferWipe(t *testing.T) {
+	data := []byte("secret")
+	s := New(data)
+
+	if !bytes.Equal(s.Bytes(), []byte("secret")) || s.Len() != 6 {
+		t.Fatalf("unexpected secret: %q", s.Bytes())
+	}
+
+	s.Wipe()
+	if !bytes.Equal(data, make([]byte, 6)) {
+		t.Fatalf("the secret should be overwritten with zeros: %q", data)
+	}
+
+	if s.Len() != 0 || s.Locked() {
+		t.Fatalf("a wiped buffer should be empty and unlocked")
+	}
+
+	// wiping twice (or a nil buffer) is a no-op
+	s.Wipe()
+	var empty *Buffer
+	empty.Wipe()
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package sensor
+
+// BiometryKind is the type of biometric sensor of the device
+type BiometryKind int
+
+const (
+	// BiometryNone means that the device has no biometric sensor (or it can't be used)
+	BiometryNone BiometryKind = iota
+	// BiometryTouchID is a fingerprint sensor
+	BiometryTouchID
+	// BiometryFaceID is a face recognition camera
+	BiometryFaceID
+)
+
+func (k BiometryKind) String() string {
+	switch k {
+	case BiometryTouchID:
+		return "Touch ID"
+	case BiometryFaceID:
+		return "Face ID"
+	}
+
+	return "none"
+}
+
+// Capabilities describes what the device supports for biometric authentication
+type Capabilities struct {
+	Biometry BiometryKind
+	// Enrolled is true when at least one finger (or face) is enrolled
+	Enrolled bool
+	// LockedOut is true when biometry is locked after too many failed attempts, the passcode is
+	// required to unlock it
+	LockedOut bool
+	// PasscodeSet is true when the user has a login password/passcode
+	PasscodeSet bool
+	// Reason explains why biometry can't be used, empty when it can
+	Reason string
+}
+
+// Available reports whether biometric authentication can be used right now
+func (c Capabilities) Available() bool {
+	return c.Biometry != BiometryNone && c.Enrolled && !c.LockedOut
+}
+
+// Prober reports the biometric capabilities of the device
+type Prober interface {
+	Probe() Capabilities
+}
+
+// Fake is a Prober that always reports the same capabilities, meant to be used in tests
+type Fake Capabilities
+
+// Probe returns the capabilities of the fake
+func (f Fake) Probe() Capabilities {
+	return Capabilities(f)
+}
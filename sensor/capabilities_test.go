@@ -0,0 +1,26 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package sensor
+
+import "testing"
+
+func TestAvailable(t *testing.T) {
+	tests := []struct {
+		caps Capabilities
+		want bool
+	}{
+		{Capabilities{Biometry: BiometryTouchID, Enrolled: true, PasscodeSet: true}, true},
+		{Capabilities{Biometry: BiometryFaceID, Enrolled: true}, true},
+		{Capabilities{Reason: "no sensor"}, false},
+		{Capabilities{Biometry: BiometryTouchID, Reason: "no fingers enrolled"}, false},
+		{Capabilities{Biometry: BiometryTouchID, Enrolled: true, LockedOut: true}, false},
+	}
+
+	for _, tt := range tests {
+		if got := Fake(tt.caps).Probe().Available(); got != tt.want {
+			t.Fatalf("%+v: available mismatch got: %t want: %t", tt.caps, got, tt.want)
+		}
+	}
+}
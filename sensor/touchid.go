@@ -2,6 +2,7 @@
 // Use of this source code is governed by the Apache License, Version 2.0
 // that can be found in the LICENSE file.
 //
+//go:build darwin && cgo
 // +build darwin,cgo
 
 package sensor
@@ -11,25 +12,100 @@ package sensor
 #cgo LDFLAGS: -framework CoreFoundation -framework LocalAuthentication -framework Foundation
 #include <stdlib.h>
 #include <stdio.h>
+#include <string.h>
 #import <LocalAuthentication/LocalAuthentication.h>
 
-int isTouchIDAvailable() {
-    int result = 0;
-    bool success = [[[LAContext alloc] init] canEvaluatePolicy:LAPolicyDeviceOwnerAuthenticationWithBiometrics error:nil];
-    if (success) {
-        return 1;
+typedef struct {
+    int canEvaluate;
+    int biometryType;
+    long errorCode;
+    int passcodeSet;
+    char *reason;
+} capabilities;
+
+capabilities probe() {
+    capabilities caps = {0, 0, 0, 0, NULL};
+    LAContext *context = [[LAContext alloc] init];
+    NSError *error = nil;
+
+    caps.canEvaluate = [context canEvaluatePolicy:LAPolicyDeviceOwnerAuthenticationWithBiometrics error:&error];
+    // biometryType is only set after calling canEvaluatePolicy
+    if (@available(macOS 10.13.2, *)) {
+        caps.biometryType = (int)context.biometryType;
     }
+    if (error != nil) {
+        caps.errorCode = (long)error.code;
+        caps.reason = strdup([[error localizedDescription] UTF8String]);
+    }
+
+    caps.passcodeSet = [context canEvaluatePolicy:LAPolicyDeviceOwnerAuthentication error:nil];
 
-    return 0;
+    return caps;
 }
 */
 import (
 	"C"
 )
+import "unsafe"
+
+// LocalAuthentication error codes (LAError.h)
+const (
+	errPasscodeNotSet         = -5
+	errBiometryNotAvailable   = -6
+	errBiometryNotEnrolled    = -7
+	errBiometryLockout        = -8
+	laBiometryTypeTouchID     = 1
+	laBiometryTypeFaceID      = 2
+	defaultUnavailableMessage = "biometry is not available on this device"
+)
+
+// LocalAuthentication probes the capabilities with the LocalAuthentication framework
+type LocalAuthentication struct{}
+
+// Probe asks LocalAuthentication whether biometric authentication can be evaluated and, if not,
+// why.
+func (LocalAuthentication) Probe() Capabilities {
+	caps := C.probe()
+	if caps.reason != nil {
+		defer C.free(unsafe.Pointer(caps.reason))
+	}
+
+	c := Capabilities{
+		PasscodeSet: caps.passcodeSet == 1,
+		Enrolled:    caps.canEvaluate == 1,
+	}
+
+	switch caps.biometryType {
+	case laBiometryTypeTouchID:
+		c.Biometry = BiometryTouchID
+	case laBiometryTypeFaceID:
+		c.Biometry = BiometryFaceID
+	}
+
+	switch caps.errorCode {
+	case errBiometryNotAvailable:
+		c.Biometry = BiometryNone
+	case errBiometryNotEnrolled:
+		c.Enrolled = false
+	case errBiometryLockout:
+		// the fingers are enrolled but can't be used until the passcode is entered
+		c.Enrolled = true
+		c.LockedOut = true
+	case errPasscodeNotSet:
+		c.PasscodeSet = false
+	}
+
+	if caps.canEvaluate != 1 {
+		c.Reason = defaultUnavailableMessage
+		if caps.reason != nil {
+			c.Reason = C.GoString(caps.reason)
+		}
+	}
+
+	return c
+}
 
 // IsTouchIDAvailable checks if Touch ID is available in the current device
 func IsTouchIDAvailable() bool {
-	result := C.isTouchIDAvailable()
-
-	return result == 1
+	return LocalAuthentication{}.Probe().Available()
 }
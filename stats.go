@@ -0,0 +1,99 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+//
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jorgelbg/pinentry-touchid/audit"
+)
+
+// defaultStatsErrors is the number of errors printed by the stats command
+const defaultStatsErrors = 5
+
+// errStatsUsage describes the arguments of the stats command
+var errStatsUsage = fmt.Errorf("usage: stats [--key <label or keygrip>] [--since <duration or date>] [--errors <n>]")
+
+// statsCommand prints how the passphrases were served, per key and overall, from the records of
+// the audit log
+func statsCommand(w io.Writer, trail *audit.Log, args []string) error {
+	var (
+		filter audit.Filter
+		since  string
+		errs   int
+	)
+
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&filter.Key, "key", "", "")
+	fs.StringVar(&since, "since", "", "")
+	fs.IntVar(&errs, "errors", defaultStatsErrors, "")
+
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 || errs < 0 {
+		return errStatsUsage
+	}
+
+	if since != "" {
+		t, err := parseSince(since, time.Now())
+		if err != nil {
+			return fmt.Errorf("invalid value for --since %q, expected a duration or a date", since)
+		}
+		filter.Since = t
+	}
+
+	records, err := trail.Records()
+	if err != nil {
+		return err
+	}
+
+	var selected []audit.Record
+	for _, r := range records {
+		if filter.Match(r) {
+			selected = append(selected, r)
+		}
+	}
+
+	total, keys := audit.Summarize(selected, errs)
+	total.Label = "all keys"
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tREQUESTS\tCACHED\tTYPED\tDENIED\tFAILED\tSUCCESS\tDECLINED\tTIMEOUT\tMEDIAN AUTH")
+	for _, s := range append(keys, total) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\n", s.Label, s.Requests,
+			s.Outcomes[audit.Released], s.Outcomes[audit.Typed], s.Outcomes[audit.Denied], s.Outcomes[audit.Failed],
+			s.Results["success"], s.Results["declined"], s.Results["timeout"], formatLatency(s.MedianLatency()))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(total.Errors) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(w, "\nLast errors:")
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, r := range total.Errors {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", formatTime(r.Time), r.Label, r.Outcome, orDash(r.Error))
+	}
+
+	return tw.Flush()
+}
+
+// formatLatency rounds d to a readable precision, a dash if no authentication was measured
+func formatLatency(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+
+	return d.Round(10 * time.Millisecond).String()
+}
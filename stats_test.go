@@ -0,0 +1,65 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jorgelbg/pinentry-touchid/audit"
+)
+
+func TestStatsCommand(t *testing.T) {
+	trail := audit.New(filepath.Join(t.TempDir(), audit.DefaultFilename))
+	for _, r := range []audit.Record{
+		{Label: "first", Keygrip: "AAAA", Mode: audit.ModeCache, Method: "touchid", Result: "success",
+			Latency: 1500 * time.Millisecond, Outcome: audit.Released},
+		{Label: "second", Keygrip: "BBBB", Mode: audit.ModeCache, Method: "touchid", Result: "timeout",
+			Latency: 30 * time.Second, Outcome: audit.Denied, Error: "authentication timeout"},
+		{Label: "first", Keygrip: "AAAA", Mode: audit.ModePrompt, Method: "touchid", Result: "unavailable",
+			Latency: 500 * time.Millisecond, Outcome: audit.Typed},
+	} {
+		if _, err := trail.Append(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := statsCommand(&out, trail, nil); err != nil {
+		t.Fatalf("stats should succeed: %s", err)
+	}
+
+	lines := strings.Split(out.String(), "\n")
+	want := []string{
+		"first     2         1       1      0       0       1        0         0        1s",
+		"second    1         0       0      1       0       0        0         1        30s",
+		"all keys  3         1       1      1       0       1        0         1        1.5s",
+	}
+	for i, line := range want {
+		if strings.TrimSpace(lines[i+1]) != line {
+			t.Fatalf("line %d mismatch got: %q want: %q\n%s", i+1, lines[i+1], line, out.String())
+		}
+	}
+	if !strings.Contains(out.String(), "Last errors:") || !strings.Contains(out.String(), "authentication timeout") {
+		t.Fatalf("stats should print the last errors: %s", out.String())
+	}
+
+	out.Reset()
+	if err := statsCommand(&out, trail, []string{"--key", "BBBB", "--errors", "0"}); err != nil {
+		t.Fatalf("stats should succeed: %s", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 3 || !strings.HasPrefix(lines[1], "second") {
+		t.Fatalf("stats should only summarise the key: %q", lines)
+	}
+
+	for _, args := range [][]string{{"extra"}, {"--errors", "-1"}, {"--since", "yesterday"}} {
+		if err := statsCommand(&out, trail, args); err == nil {
+			t.Fatalf("%q should fail", args)
+		}
+	}
+}
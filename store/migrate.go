@@ -0,0 +1,92 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package store
+
+import (
+	"fmt"
+)
+
+// Action is what a migration does with an entry
+type Action int
+
+const (
+	// Create copies an entry that doesn't exist in the destination
+	Create Action = iota
+	// Replace copies an entry over the one of the same keygrip in the destination
+	Replace
+)
+
+func (a Action) String() string {
+	if a == Replace {
+		return "replace"
+	}
+
+	return "create"
+}
+
+// MigrateOptions changes how entries are migrated
+type MigrateOptions struct {
+	// DryRun only reports what would be copied
+	DryRun bool
+	// Remove deletes every entry from the source once its copy is verified
+	Remove bool
+	// Progress, if not nil, is called for every entry. err is nil if the entry was copied (or would
+	// be with DryRun).
+	Progress func(e Entry, action Action, err error)
+}
+
+// Migrate copies every entry of src to dst with Copy. A failed entry doesn't stop the migration,
+// it is kept in src and an error is returned once every entry was tried. It returns the number of
+// entries copied.
+func Migrate(src, dst Store, opts MigrateOptions) (int, error) {
+	entries, err := src.List()
+	if err != nil {
+		return 0, fmt.Errorf("listing the entries: %w", err)
+	}
+
+	copied, failed := 0, 0
+	for _, e := range entries {
+		action := Create
+		if current, ok, err := dst.Find(e.Label, e.Keygrip); err == nil && ok && current.Keygrip == e.Keygrip {
+			action = Replace
+		}
+
+		err := migrateEntry(src, dst, e, opts)
+		if opts.Progress != nil {
+			opts.Progress(e, action, err)
+		}
+
+		if err != nil {
+			failed++
+			continue
+		}
+		copied++
+	}
+
+	if failed > 0 {
+		return copied, fmt.Errorf("%d of %d entries couldn't be migrated", failed, len(entries))
+	}
+
+	return copied, nil
+}
+
+// migrateEntry copies e and removes it from src if requested
+func migrateEntry(src, dst Store, e Entry, opts MigrateOptions) error {
+	if opts.DryRun {
+		return nil
+	}
+
+	if err := Copy(src, dst, e); err != nil {
+		return err
+	}
+
+	if opts.Remove {
+		if err := src.Delete(e); err != nil {
+			return fmt.Errorf("removing %s: %w", e.Label, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,392 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package secretservice keeps the passphrases in the keyring of the desktop through the
+// freedesktop.org Secret Service API (org.freedesktop.secrets on the session D-Bus), implemented by
+// GNOME Keyring and KeePassXC among others.
+package secretservice
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jorgelbg/pinentry-touchid/internal/dbusutil"
+	"github.com/jorgelbg/pinentry-touchid/secret"
+	"github.com/jorgelbg/pinentry-touchid/store"
+	dbus "github.com/keybase/go.dbus"
+)
+
+const (
+	// BusName is the well-known name of the Secret Service on the session bus
+	BusName = "org.freedesktop.secrets"
+	// ServicePath is the object path of the Secret Service
+	ServicePath = dbus.ObjectPath("/org/freedesktop/secrets")
+	// DefaultCollection is the object path of the default collection, usually the login keyring
+	DefaultCollection = dbus.ObjectPath("/org/freedesktop/secrets/aliases/default")
+	// Schema marks the items created by pinentry-touchid
+	Schema = "com.github.jorgelbg.pinentry-touchid"
+
+	serviceInterface    = "org.freedesktop.Secret.Service"
+	collectionInterface = "org.freedesktop.Secret.Collection"
+	itemInterface       = "org.freedesktop.Secret.Item"
+	sessionInterface    = "org.freedesktop.Secret.Session"
+	promptInterface     = "org.freedesktop.Secret.Prompt"
+	promptCompleted     = promptInterface + ".Completed"
+
+	schemaAttribute  = "xdg:schema"
+	keygripAttribute = "keygrip"
+
+	// noPrompt is returned by the service when an operation doesn't need to ask the user
+	noPrompt = dbus.ObjectPath("/")
+	// promptTimeout is how long the user has to answer a prompt of the service (e.g. unlocking
+	// the keyring)
+	promptTimeout = 2 * time.Minute
+)
+
+// ErrDismissed is returned when the user dismisses a prompt of the Secret Service
+var ErrDismissed = errors.New("the prompt of the Secret Service was dismissed")
+
+var _ store.Store = Store{}
+
+// Store keeps the passphrases in the default collection. Entries are identified by the keygrip
+// attribute, so storing an entry replaces the previous one of the same keygrip.
+type Store struct {
+	// Address of the bus where the Secret Service runs, the session bus if empty
+	Address string
+}
+
+// secretValue is the Secret struct of the API
+type secretValue struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// item is an entry and the object path of its item
+type item struct {
+	path  dbus.ObjectPath
+	entry store.Entry
+}
+
+// session is a connection with an open Secret Service session. The plain algorithm is used, the
+// passphrases don't leave the private connection to the session bus.
+type session struct {
+	conn *dbusutil.Conn
+	path dbus.ObjectPath
+}
+
+// open connects to the Secret Service and opens a session
+func (s Store) open() (*session, error) {
+	conn, err := dbusutil.ConnectSession(s.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		output dbus.Variant
+		path   dbus.ObjectPath
+	)
+	err = conn.Object(BusName, ServicePath).
+		Call(serviceInterface+".OpenSession", 0, "plain", dbus.MakeVariant("")).
+		Store(&output, &path)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &session{conn: conn, path: path}, nil
+}
+
+// close closes the session and the connection
+func (s *session) close() {
+	s.conn.Object(BusName, s.path).Call(sessionInterface+".Close", 0)
+	s.conn.Close()
+}
+
+// search returns the items created by pinentry-touchid, only the ones of keygrip if not empty
+func (s *session) search(keygrip string) ([]item, error) {
+	attributes := map[string]string{schemaAttribute: Schema}
+	if keygrip != "" {
+		attributes[keygripAttribute] = keygrip
+	}
+
+	var paths []dbus.ObjectPath
+	err := s.conn.Object(BusName, DefaultCollection).
+		Call(collectionInterface+".SearchItems", 0, attributes).
+		Store(&paths)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]item, 0, len(paths))
+	for _, path := range paths {
+		e, err := s.entry(path)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, item{path: path, entry: e})
+	}
+
+	return items, nil
+}
+
+// entry reads the properties of the item at path
+func (s *session) entry(path dbus.ObjectPath) (store.Entry, error) {
+	obj := s.conn.Object(BusName, path)
+
+	var e store.Entry
+	label, err := obj.GetProperty(itemInterface + ".Label")
+	if err != nil {
+		return e, err
+	}
+	e.Label, _ = label.Value().(string)
+
+	attributes, err := obj.GetProperty(itemInterface + ".Attributes")
+	if err != nil {
+		return e, err
+	}
+	if a, ok := attributes.Value().(map[string]string); ok {
+		e.Keygrip = a[keygripAttribute]
+	}
+
+	// the dates are optional
+	if created, err := obj.GetProperty(itemInterface + ".Created"); err == nil {
+		e.Created = unixTime(created)
+	}
+	if modified, err := obj.GetProperty(itemInterface + ".Modified"); err == nil {
+		e.Modified = unixTime(modified)
+	}
+
+	return e, nil
+}
+
+// unixTime converts the seconds since the epoch used by the service
+func unixTime(v dbus.Variant) time.Time {
+	if seconds, ok := v.Value().(uint64); ok && seconds > 0 {
+		return time.Unix(int64(seconds), 0)
+	}
+
+	return time.Time{}
+}
+
+// find returns the item with label, the one of keygrip is preferred
+func (s *session) find(label, keygrip string) (item, bool, error) {
+	items, err := s.search("")
+	if err != nil {
+		return item{}, false, err
+	}
+
+	var found []store.Entry
+	paths := make(map[string]dbus.ObjectPath)
+	for _, it := range items {
+		if it.entry.Label != label {
+			continue
+		}
+		if keygrip != "" && it.entry.Keygrip == keygrip {
+			return it, true, nil
+		}
+
+		found = append(found, it.entry)
+		paths[it.entry.Keygrip] = it.path
+	}
+
+	if len(found) == 0 {
+		return item{}, false, nil
+	}
+
+	store.Sort(found)
+	return item{path: paths[found[0].Keygrip], entry: found[0]}, true, nil
+}
+
+// prompt shows the prompt at path, if any, and waits until the user answers it
+func (s *session) prompt(path dbus.ObjectPath) error {
+	if path == noPrompt || path == "" {
+		return nil
+	}
+
+	// subscribe before showing the prompt so the answer is not missed
+	signals := make(chan *dbus.Signal, 16)
+	s.conn.Signal(signals)
+	match := fmt.Sprintf("type='signal',interface='%s',member='Completed',path='%s'",
+		promptInterface, path)
+	if err := s.conn.BusObject().Call("org.freedesktop.DBus.AddMatch", 0, match).Err; err != nil {
+		return err
+	}
+
+	if err := s.conn.Object(BusName, path).Call(promptInterface+".Prompt", 0, "").Err; err != nil {
+		return err
+	}
+
+	timeout := time.After(promptTimeout)
+	for {
+		select {
+		case sig, ok := <-signals:
+			if !ok {
+				return errors.New("the connection to the Secret Service was closed")
+			}
+			if sig.Name != promptCompleted || sig.Path != path || len(sig.Body) < 1 {
+				continue
+			}
+
+			if dismissed, _ := sig.Body[0].(bool); dismissed {
+				return ErrDismissed
+			}
+			return nil
+		case <-timeout:
+			return errors.New("the prompt of the Secret Service timed out")
+		}
+	}
+}
+
+// unlock unlocks the item at path, the user may be asked to unlock the keyring
+func (s *session) unlock(path dbus.ObjectPath) error {
+	var (
+		unlocked []dbus.ObjectPath
+		prompt   dbus.ObjectPath
+	)
+	err := s.conn.Object(BusName, ServicePath).
+		Call(serviceInterface+".Unlock", 0, []dbus.ObjectPath{path}).
+		Store(&unlocked, &prompt)
+	if err != nil {
+		return err
+	}
+
+	return s.prompt(prompt)
+}
+
+// Find returns the entry with label
+func (s Store) Find(label, keygrip string) (store.Entry, bool, error) {
+	sess, err := s.open()
+	if err != nil {
+		return store.Entry{}, false, err
+	}
+	defer sess.close()
+
+	it, ok, err := sess.find(label, keygrip)
+	return it.entry, ok, err
+}
+
+// Get reads the passphrase of the entry with label, the keyring is unlocked if needed
+func (s Store) Get(label, keygrip string) (*secret.Buffer, error) {
+	sess, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	defer sess.close()
+
+	it, ok, err := sess.find(label, keygrip)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+
+	if err := sess.unlock(it.path); err != nil {
+		return nil, err
+	}
+
+	var value secretValue
+	err = sess.conn.Object(BusName, it.path).
+		Call(itemInterface+".GetSecret", 0, sess.path).
+		Store(&value)
+	if err != nil {
+		return nil, err
+	}
+
+	return secret.New(value.Value), nil
+}
+
+// Put stores pin in the entry of e.Keygrip, the existing item is replaced
+func (s Store) Put(e store.Entry, pin []byte) error {
+	sess, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer sess.close()
+
+	properties := map[string]dbus.Variant{
+		itemInterface + ".Label": dbus.MakeVariant(e.Label),
+		itemInterface + ".Attributes": dbus.MakeVariant(map[string]string{
+			schemaAttribute:  Schema,
+			keygripAttribute: e.Keygrip,
+		}),
+	}
+	value := secretValue{
+		Session:     sess.path,
+		Parameters:  []byte{},
+		Value:       pin,
+		ContentType: "text/plain",
+	}
+
+	var path, prompt dbus.ObjectPath
+	err = sess.conn.Object(BusName, DefaultCollection).
+		Call(collectionInterface+".CreateItem", 0, properties, value, true).
+		Store(&path, &prompt)
+	if err != nil {
+		return err
+	}
+
+	return sess.prompt(prompt)
+}
+
+// Delete removes the entry of e.Keygrip
+func (s Store) Delete(e store.Entry) error {
+	// an empty keygrip would match every item
+	if e.Keygrip == "" {
+		return store.ErrNotFound
+	}
+
+	sess, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer sess.close()
+
+	items, err := sess.search(e.Keygrip)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return store.ErrNotFound
+	}
+
+	for _, it := range items {
+		var prompt dbus.ObjectPath
+		if err := sess.conn.Object(BusName, it.path).Call(itemInterface+".Delete", 0).Store(&prompt); err != nil {
+			return err
+		}
+
+		if err := sess.prompt(prompt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// List returns the entries created by pinentry-touchid
+func (s Store) List() ([]store.Entry, error) {
+	sess, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	defer sess.close()
+
+	items, err := sess.search("")
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]store.Entry, 0, len(items))
+	for _, it := range items {
+		entries = append(entries, it.entry)
+	}
+	store.Sort(entries)
+
+	return entries, nil
+}
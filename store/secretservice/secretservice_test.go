@@ -0,0 +1,254 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package secretservice
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/jorgelbg/pinentry-touchid/internal/dbustest"
+	"github.com/jorgelbg/pinentry-touchid/internal/dbusutil"
+	"github.com/jorgelbg/pinentry-touchid/store"
+	dbus "github.com/keybase/go.dbus"
+)
+
+const (
+	sessionPath = dbus.ObjectPath("/org/freedesktop/secrets/session/1")
+	promptPath  = dbus.ObjectPath("/org/freedesktop/secrets/prompt/1")
+
+	propertiesInterface = "org.freedesktop.DBus.Properties"
+	errIsLocked         = "org.freedesktop.Secret.Error.IsLocked"
+)
+
+// fakeService is a stand-in of the Secret Service with a single collection. While it is locked
+// unlocking an item requires a prompt.
+type fakeService struct {
+	conn    *dbusutil.Conn
+	dismiss bool
+
+	mu      sync.Mutex
+	locked  bool
+	items   map[dbus.ObjectPath]*fakeItem
+	created int
+}
+
+type fakeItem struct {
+	service    *fakeService
+	path       dbus.ObjectPath
+	label      string
+	attributes map[string]string
+	secret     []byte
+	created    uint64
+}
+
+func (s *fakeService) OpenSession(algorithm string, input dbus.Variant) (dbus.Variant, dbus.ObjectPath, *dbus.Error) {
+	if algorithm != "plain" {
+		return dbus.Variant{}, "", &dbus.Error{Name: "org.freedesktop.DBus.Error.NotSupported"}
+	}
+
+	return dbus.MakeVariant(""), sessionPath, nil
+}
+
+func (s *fakeService) Unlock(objects []dbus.ObjectPath) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.locked {
+		return nil, promptPath, nil
+	}
+
+	return objects, noPrompt, nil
+}
+
+func (s *fakeService) SearchItems(attributes map[string]string) ([]dbus.ObjectPath, *dbus.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var paths []dbus.ObjectPath
+	for path, it := range s.items {
+		if matches(it.attributes, attributes) {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths, nil
+}
+
+func (s *fakeService) CreateItem(properties map[string]dbus.Variant, value secretValue, replace bool) (dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	label, _ := properties[itemInterface+".Label"].Value().(string)
+	attributes, _ := properties[itemInterface+".Attributes"].Value().(map[string]string)
+
+	if replace {
+		for path, it := range s.items {
+			if matches(it.attributes, attributes) && len(it.attributes) == len(attributes) {
+				it.label, it.secret = label, value.Value
+				return path, noPrompt, nil
+			}
+		}
+	}
+
+	s.created++
+	it := &fakeItem{
+		service:    s,
+		path:       dbus.ObjectPath(fmt.Sprintf("/org/freedesktop/secrets/collection/login/%d", s.created)),
+		label:      label,
+		attributes: attributes,
+		secret:     value.Value,
+		created:    uint64(1600000000 + s.created),
+	}
+	s.items[it.path] = it
+	_ = s.conn.Export(it, it.path, itemInterface)
+	_ = s.conn.Export(it, it.path, propertiesInterface)
+
+	return it.path, noPrompt, nil
+}
+
+// Prompt asks the user to unlock the collection
+func (s *fakeService) Prompt(windowID string) *dbus.Error {
+	s.mu.Lock()
+	s.locked = s.dismiss
+	s.mu.Unlock()
+
+	_ = s.conn.Emit(promptPath, promptCompleted, s.dismiss, dbus.MakeVariant([]dbus.ObjectPath{}))
+	return nil
+}
+
+func (it *fakeItem) GetSecret(session dbus.ObjectPath) (secretValue, *dbus.Error) {
+	it.service.mu.Lock()
+	defer it.service.mu.Unlock()
+
+	if it.service.locked {
+		return secretValue{}, &dbus.Error{Name: errIsLocked, Body: []interface{}{"Cannot get secret of a locked object"}}
+	}
+
+	return secretValue{Session: session, Parameters: []byte{}, Value: it.secret, ContentType: "text/plain"}, nil
+}
+
+func (it *fakeItem) Delete() (dbus.ObjectPath, *dbus.Error) {
+	it.service.mu.Lock()
+	defer it.service.mu.Unlock()
+
+	// the object stays exported, go.dbus doesn't release its lock when unexporting
+	delete(it.service.items, it.path)
+
+	return noPrompt, nil
+}
+
+// Get returns a property of the item
+func (it *fakeItem) Get(iface, name string) (dbus.Variant, *dbus.Error) {
+	it.service.mu.Lock()
+	defer it.service.mu.Unlock()
+
+	switch name {
+	case "Label":
+		return dbus.MakeVariant(it.label), nil
+	case "Attributes":
+		return dbus.MakeVariant(it.attributes), nil
+	case "Created", "Modified":
+		return dbus.MakeVariant(it.created), nil
+	}
+
+	return dbus.Variant{}, &dbus.Error{Name: "org.freedesktop.DBus.Error.UnknownProperty"}
+}
+
+// matches reports whether attributes include every one of query
+func matches(attributes, query map[string]string) bool {
+	for k, v := range query {
+		if attributes[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// startSecretService serves a stand-in of the Secret Service on a private bus and returns its
+// address
+func startSecretService(t *testing.T, service *fakeService) string {
+	service.items = make(map[dbus.ObjectPath]*fakeItem)
+
+	address := dbustest.Start(t)
+	service.conn = dbustest.Serve(t, address, BusName, map[dbus.ObjectPath]map[string]interface{}{
+		ServicePath:       {serviceInterface: service},
+		DefaultCollection: {collectionInterface: service},
+		promptPath:        {promptInterface: service},
+	})
+
+	return address
+}
+
+func TestStore(t *testing.T) {
+	s := Store{Address: startSecretService(t, &fakeService{locked: true})}
+
+	if _, err := s.Get("label", "A"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+
+	for keygrip, pin := range map[string]string{"A": "a", "B": "b"} {
+		if err := s.Put(store.Entry{Label: "label", Keygrip: keygrip}, []byte(pin)); err != nil {
+			t.Fatalf("storing an entry should succeed: %s", err)
+		}
+	}
+
+	// the keyring is unlocked on the first read
+	for keygrip, want := range map[string]string{"B": "b", "A": "a", "": "a"} {
+		pin, err := s.Get("label", keygrip)
+		if err != nil || string(pin.Bytes()) != want {
+			t.Fatalf("%q: got %q (%v) want: %q", keygrip, pin.Bytes(), err, want)
+		}
+	}
+
+	// storing an entry again replaces it
+	if err := s.Put(store.Entry{Label: "renamed", Keygrip: "B"}, []byte("new")); err != nil {
+		t.Fatalf("replacing an entry should succeed: %s", err)
+	}
+
+	entries, err := s.List()
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("unexpected entries: %+v (%v)", entries, err)
+	}
+	if entries[0].Keygrip != "A" || entries[1].Label != "renamed" || entries[1].Created.IsZero() {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+
+	if pin, err := s.Get("renamed", "B"); err != nil || string(pin.Bytes()) != "new" {
+		t.Fatalf("the passphrase should be replaced, got: %q (%v)", pin.Bytes(), err)
+	}
+
+	if err := s.Delete(store.Entry{Keygrip: "A"}); err != nil {
+		t.Fatalf("deleting an entry should succeed: %s", err)
+	}
+	if _, ok, _ := s.Find("label", "A"); ok {
+		t.Fatalf("the deleted entry should not be found")
+	}
+	if err := s.Delete(store.Entry{Keygrip: "A"}); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("deleting a missing entry should fail, got: %v", err)
+	}
+}
+
+func TestGetDismissed(t *testing.T) {
+	s := Store{Address: startSecretService(t, &fakeService{locked: true, dismiss: true})}
+
+	if err := s.Put(store.Entry{Label: "label", Keygrip: "A"}, []byte("a")); err != nil {
+		t.Fatalf("storing an entry should succeed: %s", err)
+	}
+
+	if _, err := s.Get("label", "A"); !errors.Is(err, ErrDismissed) {
+		t.Fatalf("expected ErrDismissed, got: %v", err)
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	s := Store{Address: dbustest.Start(t)}
+
+	if err := s.Put(store.Entry{Label: "label", Keygrip: "A"}, []byte("a")); !dbusutil.IsUnavailable(err) {
+		t.Fatalf("a missing Secret Service should be reported, got: %v", err)
+	}
+}
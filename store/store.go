@@ -0,0 +1,189 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package store defines where the passphrases cached by pinentry-touchid are kept, so the same
+// logic works with any backend and entries can be moved from one backend to another.
+package store
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jorgelbg/pinentry-touchid/secret"
+)
+
+// ErrNotFound is returned when no entry matches a lookup
+var ErrNotFound = errors.New("no matching entry was found")
+
+// Entry identifies a cached passphrase. A backend holds at most one entry per keygrip.
+type Entry struct {
+	// Label is the name shown to the user, built from the name, email and ID of the key
+	Label string
+	// Keygrip is the cache ID sent by the gpg-agent
+	Keygrip string
+	// Created and Modified are reported by the backend, they are zero if it doesn't track them
+	Created  time.Time
+	Modified time.Time
+}
+
+// Store keeps passphrases. Lookups take the label of the entry and the keygrip of the key, when
+// several entries share a label the one of the keygrip is preferred.
+type Store interface {
+	// Find returns the entry with label without reading its passphrase
+	Find(label, keygrip string) (Entry, bool, error)
+	// Get reads the passphrase of the entry with label, ErrNotFound if there is none
+	Get(label, keygrip string) (*secret.Buffer, error)
+	// Put stores pin in the entry of e.Keygrip, creating it or replacing its label and
+	// passphrase
+	Put(e Entry, pin []byte) error
+	// Delete removes the entry of e.Keygrip
+	Delete(e Entry) error
+	// List returns every entry sorted by label and keygrip
+	List() ([]Entry, error)
+}
+
+// Copy stores the passphrase of e from src in dst and reads it back to verify that the copy
+// matches. The entry in dst is created or replaced.
+func Copy(src, dst Store, e Entry) error {
+	pin, err := src.Get(e.Label, e.Keygrip)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", e.Label, err)
+	}
+	defer pin.Wipe()
+
+	if err := dst.Put(e, pin.Bytes()); err != nil {
+		return fmt.Errorf("storing %s: %w", e.Label, err)
+	}
+
+	stored, err := dst.Get(e.Label, e.Keygrip)
+	if err != nil {
+		return fmt.Errorf("verifying %s: %w", e.Label, err)
+	}
+	defer stored.Wipe()
+
+	if subtle.ConstantTimeCompare(pin.Bytes(), stored.Bytes()) != 1 {
+		return fmt.Errorf("verifying %s: the stored passphrase doesn't match", e.Label)
+	}
+
+	return nil
+}
+
+// Sort orders entries by label and keygrip
+func Sort(entries []Entry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Label != entries[j].Label {
+			return entries[i].Label < entries[j].Label
+		}
+		return entries[i].Keygrip < entries[j].Keygrip
+	})
+}
+
+// Memory is a Store that keeps the passphrases in memory, meant to be used in tests
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]Entry
+	pins    map[string][]byte
+	now     func() time.Time
+}
+
+// NewMemory returns an empty Memory store
+func NewMemory() *Memory {
+	return &Memory{
+		entries: make(map[string]Entry),
+		pins:    make(map[string][]byte),
+		now:     time.Now,
+	}
+}
+
+// find returns the keygrip of the entry with label, the one of keygrip is preferred
+func (m *Memory) find(label, keygrip string) (string, bool) {
+	if e, ok := m.entries[keygrip]; ok && e.Label == label {
+		return keygrip, true
+	}
+
+	found := ""
+	for k, e := range m.entries {
+		if e.Label == label && (found == "" || k < found) {
+			found = k
+		}
+	}
+
+	return found, found != ""
+}
+
+// Find returns the entry with label
+func (m *Memory) Find(label, keygrip string) (Entry, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k, ok := m.find(label, keygrip)
+	return m.entries[k], ok, nil
+}
+
+// Get returns a copy of the passphrase of the entry with label
+func (m *Memory) Get(label, keygrip string) (*secret.Buffer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k, ok := m.find(label, keygrip)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return secret.New(append([]byte(nil), m.pins[k]...)), nil
+}
+
+// Put stores a copy of pin
+func (m *Memory) Put(e Entry, pin []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if current, ok := m.entries[e.Keygrip]; ok {
+		e.Created = current.Created
+		secret.Wipe(m.pins[e.Keygrip])
+	} else if e.Created.IsZero() {
+		e.Created = now
+	}
+	e.Modified = now
+
+	m.entries[e.Keygrip] = e
+	m.pins[e.Keygrip] = append([]byte(nil), pin...)
+
+	return nil
+}
+
+// Delete removes the entry of e.Keygrip
+func (m *Memory) Delete(e Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.entries[e.Keygrip]; !ok {
+		return ErrNotFound
+	}
+
+	secret.Wipe(m.pins[e.Keygrip])
+	delete(m.entries, e.Keygrip)
+	delete(m.pins, e.Keygrip)
+
+	return nil
+}
+
+// List returns every entry
+func (m *Memory) List() ([]Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := make([]Entry, 0, len(m.entries))
+	for _, e := range m.entries {
+		entries = append(entries, e)
+	}
+	Sort(entries)
+
+	return entries, nil
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// corrupting is a Store that alters every passphrase it stores
+type corrupting struct {
+	*Memory
+}
+
+func (c corrupting) Put(e Entry, pin []byte) error {
+	return c.Memory.Put(e, append(pin, '!'))
+}
+
+func TestMemory(t *testing.T) {
+	m := NewMemory()
+
+	if _, err := m.Get("label", "KEYGRIP"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+
+	if err := m.Put(Entry{Label: "label", Keygrip: "B"}, []byte("b")); err != nil {
+		t.Fatalf("storing an entry should succeed: %s", err)
+	}
+	if err := m.Put(Entry{Label: "label", Keygrip: "A"}, []byte("a")); err != nil {
+		t.Fatalf("storing an entry should succeed: %s", err)
+	}
+
+	// the entry of the keygrip is preferred, otherwise the order is deterministic
+	for keygrip, want := range map[string]string{"B": "b", "A": "a", "": "a"} {
+		pin, err := m.Get("label", keygrip)
+		if err != nil || string(pin.Bytes()) != want {
+			t.Fatalf("%q: got %q (%v) want: %q", keygrip, pin.Bytes(), err, want)
+		}
+	}
+
+	// replacing an entry keeps its creation date
+	e, _, _ := m.Find("label", "B")
+	time.Sleep(time.Millisecond)
+	if err := m.Put(Entry{Label: "renamed", Keygrip: "B"}, []byte("new")); err != nil {
+		t.Fatalf("replacing an entry should succeed: %s", err)
+	}
+
+	replaced, ok, _ := m.Find("renamed", "B")
+	if !ok || !replaced.Created.Equal(e.Created) || !replaced.Modified.After(e.Modified) {
+		t.Fatalf("unexpected replaced entry: %+v (was %+v)", replaced, e)
+	}
+
+	entries, _ := m.List()
+	if len(entries) != 2 || entries[0].Keygrip != "A" || entries[1].Label != "renamed" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+
+	if err := m.Delete(Entry{Keygrip: "A"}); err != nil {
+		t.Fatalf("deleting an entry should succeed: %s", err)
+	}
+	if _, ok, _ := m.Find("label", ""); ok {
+		t.Fatalf("the deleted entry should not be found")
+	}
+	if err := m.Delete(Entry{Keygrip: "A"}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("deleting a missing entry should fail, got: %v", err)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	src := NewMemory()
+	created := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	e := Entry{Label: "label", Keygrip: "KEYGRIP", Created: created}
+	if err := src.Put(e, []byte("secret")); err != nil {
+		t.Fatalf("storing an entry should succeed: %s", err)
+	}
+
+	dst := NewMemory()
+	if err := Copy(src, dst, e); err != nil {
+		t.Fatalf("copying an entry should succeed: %s", err)
+	}
+
+	copied, ok, _ := dst.Find("label", "KEYGRIP")
+	if !ok || !copied.Created.Equal(created) {
+		t.Fatalf("the copy should keep the creation date: %+v", copied)
+	}
+
+	if err := Copy(src, corrupting{NewMemory()}, e); err == nil {
+		t.Fatalf("a copy that doesn't match should fail")
+	}
+
+	if err := Copy(src, dst, Entry{Label: "unknown"}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("copying a missing entry should fail with ErrNotFound, got: %v", err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	src := NewMemory()
+	for _, k := range []string{"A", "B", "C"} {
+		if err := src.Put(Entry{Label: "label " + k, Keygrip: k}, []byte("secret "+k)); err != nil {
+			t.Fatalf("storing an entry should succeed: %s", err)
+		}
+	}
+
+	dst := NewMemory()
+	if err := dst.Put(Entry{Label: "label B", Keygrip: "B"}, []byte("old")); err != nil {
+		t.Fatalf("storing an entry should succeed: %s", err)
+	}
+
+	actions := make(map[string]Action)
+	progress := func(e Entry, action Action, err error) {
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", e.Label, err)
+		}
+		actions[e.Keygrip] = action
+	}
+
+	// a dry run doesn't change either store
+	n, err := Migrate(src, dst, MigrateOptions{DryRun: true, Remove: true, Progress: progress})
+	if err != nil || n != 3 {
+		t.Fatalf("a dry run should report every entry: %d (%v)", n, err)
+	}
+	if actions["A"] != Create || actions["B"] != Replace {
+		t.Fatalf("unexpected actions: %v", actions)
+	}
+	if entries, _ := dst.List(); len(entries) != 1 {
+		t.Fatalf("a dry run should not copy entries: %+v", entries)
+	}
+
+	n, err = Migrate(src, dst, MigrateOptions{Remove: true, Progress: progress})
+	if err != nil || n != 3 {
+		t.Fatalf("migrating should succeed: %d (%v)", n, err)
+	}
+
+	pin, err := dst.Get("label B", "B")
+	if err != nil || string(pin.Bytes()) != "secret B" {
+		t.Fatalf("the entry in the destination should be replaced: %q (%v)", pin.Bytes(), err)
+	}
+	if entries, _ := src.List(); len(entries) != 0 {
+		t.Fatalf("the migrated entries should be removed: %+v", entries)
+	}
+
+	// failed copies are kept in the source
+	if err := src.Put(Entry{Label: "label D", Keygrip: "D"}, []byte("secret D")); err != nil {
+		t.Fatalf("storing an entry should succeed: %s", err)
+	}
+	n, err = Migrate(src, corrupting{NewMemory()}, MigrateOptions{Remove: true})
+	if err == nil || n != 0 {
+		t.Fatalf("a failed copy should fail the migration: %d (%v)", n, err)
+	}
+	if _, ok, _ := src.Find("label D", "D"); !ok {
+		t.Fatalf("an entry that failed to copy should be kept")
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+//
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/foxcpp/go-assuan/common"
+	"github.com/foxcpp/go-assuan/pinentry"
+	"github.com/jorgelbg/pinentry-touchid/logging"
+)
+
+const (
+	// peerAgent is the peer of the session served to the gpg-agent
+	peerAgent = "gpg-agent"
+	// peerPinentry is the peer of the sessions opened with pinentry-mac
+	peerPinentry = "pinentry-mac"
+)
+
+// logTracer writes every line of an Assuan session to the log at the debug level. The lines are
+// redacted by go-assuan before they get here, so no PIN is ever logged.
+type logTracer struct {
+	logger *logging.Logger
+	peer   string
+}
+
+// TraceLine implements common.Tracer
+func (t logTracer) TraceLine(dir common.Direction, line string) {
+	t.logger.Debug("assuan", "peer", t.peer, "dir", string(dir), "line", line)
+}
+
+// traceSession returns the tracers of the sessions with the gpg-agent and with pinentry-mac, their
+// records share a new session ID so concurrent pinentry processes can be told apart in the log
+func traceSession(logger *logging.Logger) (agent, upstream common.Tracer) {
+	logger = logger.With("session", newSessionID())
+
+	return logTracer{logger, peerAgent}, logTracer{logger, peerPinentry}
+}
+
+// secretDescTracer redacts the descriptions sent to pinentry-mac, they show a secret
+type secretDescTracer struct {
+	common.Tracer
+}
+
+// TraceLine implements common.Tracer
+func (t secretDescTracer) TraceLine(dir common.Direction, line string) {
+	if dir == common.Sent && strings.HasPrefix(strings.ToUpper(line), "SETDESC ") {
+		line = "SETDESC [redacted]"
+	}
+
+	t.Tracer.TraceLine(dir, line)
+}
+
+// promptTracer returns the tracer of the session with pinentry-mac that shows the prompt s, which
+// redacts the description when it shows a secret. tracer may be nil.
+func promptTracer(s pinentry.Settings, tracer common.Tracer) common.Tracer {
+	if tracer == nil || !s.SecretDesc {
+		return tracer
+	}
+
+	return secretDescTracer{tracer}
+}
+
+// newSessionID returns a short random ID
+func newSessionID() string {
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return "unknown"
+	}
+
+	return hex.EncodeToString(id)
+}
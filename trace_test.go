@@ -0,0 +1,85 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/foxcpp/go-assuan/client"
+	"github.com/foxcpp/go-assuan/common"
+	"github.com/foxcpp/go-assuan/pinentry"
+	"github.com/jorgelbg/pinentry-touchid/logging"
+)
+
+func TestTraceSession(t *testing.T) {
+	var out bytes.Buffer
+	agent, upstream := traceSession(logging.New(&out, logging.LevelDebug, logging.FormatText))
+
+	pipe := common.TracePipe(common.ReadWriter{Reader: strings.NewReader("GETPIN\n"), Writer: &bytes.Buffer{}}, agent)
+	_, _ = pipe.Read(make([]byte, 16))
+	_, _ = pipe.Write([]byte("D " + testPassword + "\nOK\n"))
+	upstream.TraceLine(common.Sent, "SETDESC key")
+
+	if strings.Contains(out.String(), testPassword) {
+		t.Fatalf("the PIN should be redacted from the trace: %s", out.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := []string{
+		`peer=gpg-agent dir=< line=GETPIN`,
+		`peer=gpg-agent dir=> line="D [15 bytes redacted]"`,
+		`peer=gpg-agent dir=> line=OK`,
+		`peer=pinentry-mac dir=> line="SETDESC key"`,
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("traced lines mismatch got: %q want: %q", lines, want)
+	}
+
+	session := regexp.MustCompile(`session=([0-9a-f]{8})`)
+	id := session.FindStringSubmatch(lines[0])
+	for i, line := range lines {
+		if !strings.HasSuffix(line, want[i]) {
+			t.Fatalf("traced line mismatch got: %s want: %s", line, want[i])
+		}
+		if m := session.FindStringSubmatch(line); m == nil || m[1] != id[1] {
+			t.Fatalf("every line should have the same session ID: %s", line)
+		}
+	}
+}
+
+func TestTraceGeneratedPIN(t *testing.T) {
+	var out bytes.Buffer
+	_, upstream := traceSession(logging.New(&out, logging.LevelDebug, logging.FormatText))
+
+	// the confirmation prompt is sent to pinentry-mac, the passphrase is typed back
+	pin := []byte(testPassword)
+	err := confirmGeneratedPIN(func(s pinentry.Settings) ([]byte, error) {
+		pipe := common.TracePipe(common.ReadWriter{Reader: strings.NewReader("OK\nOK\n"), Writer: &bytes.Buffer{}},
+			promptTracer(s, upstream))
+		ses, err := client.InitNopClose(pipe)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := ses.SimpleCmd("SETDESC", s.Desc); err != nil {
+			return nil, err
+		}
+
+		return []byte(testPassword), nil
+	}, pinentry.Settings{}, "key", pin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(out.String(), testPassword) {
+		t.Fatalf("the generated passphrase should be redacted from the trace: %s", out.String())
+	}
+
+	if !strings.Contains(out.String(), `line="SETDESC [redacted]"`) {
+		t.Fatalf("the description should be traced redacted: %s", out.String())
+	}
+}
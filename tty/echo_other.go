@@ -0,0 +1,15 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+//
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package tty
+
+import "errors"
+
+// hideInput fails, a PIN is never read with the echo enabled
+func hideInput(int) (func(), error) {
+	return nil, errors.New("hiding the input is not supported on this platform")
+}
@@ -0,0 +1,345 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package tty implements a minimal pinentry that interacts with the user through a terminal. It is
+// used when no external pinentry program is available, e.g. on SSH sessions or minimal installs.
+package tty
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/foxcpp/go-assuan/common"
+	"github.com/foxcpp/go-assuan/pinentry"
+	"github.com/jorgelbg/pinentry-touchid/secret"
+	"golang.org/x/term"
+)
+
+// DefaultTTY is the terminal used when gpg-agent doesn't provide one via OPTION ttyname
+const DefaultTTY = "/dev/tty"
+
+var (
+	// ErrTimeout is returned when the user doesn't answer before the configured timeout
+	ErrTimeout = errors.New("timeout while waiting for user input")
+	// ErrCanceled is returned when the input is closed before the user answers
+	ErrCanceled = errors.New("operation canceled")
+)
+
+// maxLineLen is the length of the longest line that can be typed, including the newline
+const maxLineLen = 4096
+
+// Terminal is a pinentry prompt bound to a terminal device
+type Terminal struct {
+	// Warn, if set, is called with the problems that don't stop a prompt, e.g. a terminal that
+	// doesn't support timeouts
+	Warn func(msg string, err error)
+
+	in     *os.File
+	out    io.Writer
+	reader *lineReader
+}
+
+// lineReader reads lines into a fixed buffer that is wiped as they are returned, so the only copy
+// of a typed PIN is the one returned. Input typed after a line is kept for the next one.
+type lineReader struct {
+	r   io.Reader
+	buf []byte
+	// n is the number of bytes buffered
+	n int
+}
+
+// readLine returns the next line without its line terminator. On error the partial line is
+// discarded, a line that is not terminated before the end of the input is returned as is.
+func (l *lineReader) readLine() ([]byte, error) {
+	for {
+		if i := bytes.IndexByte(l.buf[:l.n], '\n'); i >= 0 {
+			return l.take(i, i+1), nil
+		}
+
+		if l.n == len(l.buf) {
+			l.discard()
+			return nil, errors.New("the line is too long")
+		}
+
+		read, err := l.r.Read(l.buf[l.n:])
+		l.n += read
+		switch {
+		case err == io.EOF && l.n > 0:
+			return l.take(l.n, l.n), nil
+		case err != nil:
+			l.discard()
+			return nil, err
+		}
+	}
+}
+
+// take returns a copy of the first n bytes buffered and wipes the first skip bytes from the buffer
+func (l *lineReader) take(n, skip int) []byte {
+	line := make([]byte, n)
+	copy(line, l.buf[:n])
+
+	copy(l.buf, l.buf[skip:l.n])
+	secret.Wipe(l.buf[l.n-skip : l.n])
+	l.n -= skip
+
+	return bytes.TrimRight(line, "\r")
+}
+
+// discard wipes the buffered input
+func (l *lineReader) discard() {
+	secret.Wipe(l.buf[:l.n])
+	l.n = 0
+}
+
+// Open opens the terminal with the given name, DefaultTTY is used if name is empty
+func Open(name string) (*Terminal, error) {
+	if name == "" {
+		name = DefaultTTY
+	}
+
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open terminal %s: %w", name, err)
+	}
+
+	return newTerminal(f, f), nil
+}
+
+func newTerminal(in *os.File, out io.Writer) *Terminal {
+	return &Terminal{
+		in:     in,
+		out:    out,
+		reader: &lineReader{r: in, buf: make([]byte, maxLineLen)},
+	}
+}
+
+// Close releases the underlying terminal device
+func (t *Terminal) Close() error {
+	return t.in.Close()
+}
+
+// GetPIN asks the user for a PIN. Echo is disabled while the PIN is typed and, if a repeat prompt
+// is configured, the user is asked to enter the PIN twice until both entries match.
+func (t *Terminal) GetPIN(s pinentry.Settings) ([]byte, error) {
+	t.printHeader(s)
+
+	prompt := s.Prompt
+	if prompt == "" {
+		prompt = "PIN:"
+	}
+
+	for {
+		pin, err := t.ask(prompt, true, s.Timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		if s.RepeatPrompt == "" {
+			return pin, nil
+		}
+
+		repeated, err := t.ask(s.RepeatPrompt, true, s.Timeout)
+		if err != nil {
+			secret.Wipe(pin)
+			return nil, err
+		}
+
+		match := bytes.Equal(pin, repeated)
+		secret.Wipe(repeated)
+		if match {
+			return pin, nil
+		}
+		secret.Wipe(pin)
+
+		repeatError := s.RepeatError
+		if repeatError == "" {
+			repeatError = "PINs do not match"
+		}
+		fmt.Fprintln(t.out, repeatError)
+	}
+}
+
+// Confirm asks the user to confirm the description shown in the terminal
+func (t *Terminal) Confirm(s pinentry.Settings) (bool, error) {
+	t.printHeader(s)
+
+	answer, err := t.ask("Confirm? [y/N]", false, s.Timeout)
+	if err != nil {
+		return false, err
+	}
+
+	switch strings.ToLower(strings.TrimSpace(string(answer))) {
+	case "y", "yes":
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// Message shows the description in the terminal and waits until the user acknowledges it
+func (t *Terminal) Message(s pinentry.Settings) error {
+	t.printHeader(s)
+
+	_, err := t.ask("Press Enter to continue", false, s.Timeout)
+
+	return err
+}
+
+func (t *Terminal) printHeader(s pinentry.Settings) {
+	for _, text := range []string{s.Title, s.Desc, s.Error} {
+		if text != "" {
+			fmt.Fprintln(t.out, strings.TrimRight(text, "\n"))
+		}
+	}
+}
+
+// control runs f with the file descriptor of the terminal. Unlike Fd it leaves the descriptor in
+// non-blocking mode, which the read deadlines rely on.
+func (t *Terminal) control(f func(fd int)) error {
+	conn, err := t.in.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	return conn.Control(func(fd uintptr) { f(int(fd)) })
+}
+
+// warn passes a problem that doesn't stop the prompt to Warn
+func (t *Terminal) warn(msg string, err error) {
+	if t.Warn != nil {
+		t.Warn(msg, err)
+	}
+}
+
+// ask shows the prompt and reads a single line from the terminal. When secret is set and the input
+// is a terminal echo is disabled while reading. A timeout of zero waits forever, once it expires the
+// read is abandoned and the next line is left for the next prompt. Terminals that don't support
+// timeouts (e.g. /dev/tty on macOS, which can't be polled) wait forever too.
+func (t *Terminal) ask(prompt string, secret bool, timeout time.Duration) ([]byte, error) {
+	fmt.Fprintf(t.out, "%s ", prompt)
+
+	var restore func()
+	if secret {
+		var hideErr error
+		err := t.control(func(fd int) {
+			if term.IsTerminal(fd) {
+				restore, hideErr = hideInput(fd)
+			}
+		})
+		if err == nil {
+			err = hideErr
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the newline typed by the user is not echoed back
+	defer func() {
+		if restore != nil {
+			restore()
+			fmt.Fprintln(t.out)
+		}
+	}()
+
+	if timeout > 0 {
+		if err := t.in.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			t.warn("The terminal doesn't support timeouts, waiting for the answer without one", err)
+		} else {
+			defer func() { _ = t.in.SetReadDeadline(time.Time{}) }()
+		}
+	}
+
+	line, err := t.reader.readLine()
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		if restore == nil {
+			fmt.Fprintln(t.out)
+		}
+		return nil, ErrTimeout
+	case err == io.EOF:
+		return nil, ErrCanceled
+	case err != nil:
+		return nil, err
+	}
+
+	return line, nil
+}
+
+func assuanError(err error) *common.Error {
+	var code common.ErrorCode = common.ErrCanceled
+	if errors.Is(err, ErrTimeout) {
+		code = common.ErrTimeout
+	}
+
+	return &common.Error{
+		Src:     common.ErrSrcPinentry,
+		SrcName: "pinentry",
+		Code:    code,
+		Message: err.Error(),
+	}
+}
+
+// Callbacks returns pinentry callbacks that serve every request from the terminal named in the
+// request options (or DefaultTTY). warn, which may be nil, is the Warn of the terminals.
+func Callbacks(warn func(msg string, err error)) pinentry.Callbacks {
+	open := func(name string) (*Terminal, error) {
+		t, err := Open(name)
+		if err != nil {
+			return nil, err
+		}
+
+		t.Warn = warn
+		return t, nil
+	}
+
+	return pinentry.Callbacks{
+		GetPIN: func(s pinentry.Settings) ([]byte, *common.Error) {
+			t, err := open(s.Opts.TTYName)
+			if err != nil {
+				return nil, assuanError(err)
+			}
+			defer t.Close()
+
+			pin, err := t.GetPIN(s)
+			if err != nil {
+				return nil, assuanError(err)
+			}
+
+			return pin, nil
+		},
+		Confirm: func(s pinentry.Settings) (bool, *common.Error) {
+			t, err := open(s.Opts.TTYName)
+			if err != nil {
+				return false, assuanError(err)
+			}
+			defer t.Close()
+
+			ok, err := t.Confirm(s)
+			if err != nil {
+				return false, assuanError(err)
+			}
+
+			return ok, nil
+		},
+		Msg: func(s pinentry.Settings) *common.Error {
+			t, err := open(s.Opts.TTYName)
+			if err != nil {
+				return assuanError(err)
+			}
+			defer t.Close()
+
+			if err := t.Message(s); err != nil {
+				return assuanError(err)
+			}
+
+			return nil
+		},
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright (c) 2021 Jorge Luis Betancourt. All rights reserved.
+// Use of this source code is governed by the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package tty
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/foxcpp/go-assuan/pinentry"
+)
+
+const testPassword = "toomanysecrets2"
+
+// fakeTerminal returns a Terminal that reads the given input and writes into out
+func fakeTerminal(t *testing.T, input string, out *bytes.Buffer) *Terminal {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %s", err)
+	}
+	t.Cleanup(func() { _ = r.Close() })
+
+	go func() {
+		_, _ = w.WriteString(input)
+		_ = w.Close()
+	}()
+
+	return newTerminal(r, out)
+}
+
+func TestGetPIN(t *testing.T) {
+	var out bytes.Buffer
+	term := fakeTerminal(t, testPassword+"\n", &out)
+
+	pin, err := term.GetPIN(pinentry.Settings{
+		Desc:   "Please enter the passphrase",
+		Error:  "Bad Passphrase (try 2 of 3)",
+		Prompt: "Passphrase:",
+	})
+	if err != nil {
+		t.Fatalf("GetPIN should succeed: %s", err)
+	}
+
+	if string(pin) != testPassword {
+		t.Fatalf("password mismatch got: %s want: %s", pin, testPassword)
+	}
+
+	for _, want := range []string{"Please enter the passphrase", "Bad Passphrase", "Passphrase:"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("terminal output %q should contain %q", out.String(), want)
+		}
+	}
+}
+
+func TestGetPINWipesInput(t *testing.T) {
+	var out bytes.Buffer
+	term := fakeTerminal(t, testPassword+"\r\nnext\n", &out)
+
+	pin, err := term.GetPIN(pinentry.Settings{})
+	if err != nil || string(pin) != testPassword {
+		t.Fatalf("GetPIN should succeed, got: %q (%v)", pin, err)
+	}
+
+	if bytes.Contains(term.reader.buf, []byte(testPassword)) {
+		t.Fatalf("the PIN should be wiped from the input buffer: %q", term.reader.buf)
+	}
+
+	// the input typed after the PIN is kept
+	next, err := term.GetPIN(pinentry.Settings{})
+	if err != nil || string(next) != "next" {
+		t.Fatalf("the next prompt should read the next line, got: %q (%v)", next, err)
+	}
+}
+
+func TestGetPINRepeat(t *testing.T) {
+	var out bytes.Buffer
+	term := fakeTerminal(t, "first\nsecond\n"+testPassword+"\n"+testPassword+"\n", &out)
+
+	pin, err := term.GetPIN(pinentry.Settings{
+		RepeatPrompt: "Repeat:",
+		RepeatError:  "does not match - try again",
+	})
+	if err != nil {
+		t.Fatalf("GetPIN should succeed: %s", err)
+	}
+
+	if string(pin) != testPassword {
+		t.Fatalf("password mismatch got: %s want: %s", pin, testPassword)
+	}
+
+	if strings.Count(out.String(), "does not match") != 1 {
+		t.Fatalf("the repeat error should be shown once: %q", out.String())
+	}
+}
+
+func TestGetPINCanceled(t *testing.T) {
+	var out bytes.Buffer
+	term := fakeTerminal(t, "", &out)
+
+	if _, err := term.GetPIN(pinentry.Settings{}); !errors.Is(err, ErrCanceled) {
+		t.Fatalf("closed input should cancel the prompt, got: %v", err)
+	}
+}
+
+func TestGetPINTimeout(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %s", err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	var out bytes.Buffer
+	term := newTerminal(r, &out)
+
+	if _, err := term.GetPIN(pinentry.Settings{Timeout: 10 * time.Millisecond}); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("the prompt should time out, got: %v", err)
+	}
+}
+
+func TestGetPINWithoutDeadline(t *testing.T) {
+	// regular files can't be polled like /dev/tty on macOS, they don't support deadlines
+	path := filepath.Join(t.TempDir(), "input")
+	if err := os.WriteFile(path, []byte(testPassword+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	var (
+		out      bytes.Buffer
+		warnings []error
+	)
+	term := newTerminal(in, &out)
+	term.Warn = func(msg string, err error) { warnings = append(warnings, err) }
+
+	pin, err := term.GetPIN(pinentry.Settings{Timeout: time.Second})
+	if err != nil || string(pin) != testPassword {
+		t.Fatalf("the PIN should be read without a timeout, got: %q (%v)", pin, err)
+	}
+
+	if len(warnings) != 1 || !errors.Is(warnings[0], os.ErrNoDeadline) {
+		t.Fatalf("the missing timeout should be warned about, got: %v", warnings)
+	}
+}
+
+func TestGetPINAfterTimeout(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %s", err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	var out bytes.Buffer
+	term := newTerminal(r, &out)
+
+	if _, err := term.GetPIN(pinentry.Settings{Timeout: 10 * time.Millisecond}); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("the prompt should time out, got: %v", err)
+	}
+
+	// the line typed after the timeout belongs to the next prompt
+	if _, err := w.WriteString(testPassword + "\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	pin, err := term.GetPIN(pinentry.Settings{Timeout: time.Second})
+	if err != nil || string(pin) != testPassword {
+		t.Fatalf("the next prompt should read the line, got: %q (%v)", pin, err)
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	for input, want := range map[string]bool{"y\n": true, "yes\n": true, "n\n": false, "\n": false} {
+		var out bytes.Buffer
+		term := fakeTerminal(t, input, &out)
+
+		ok, err := term.Confirm(pinentry.Settings{Desc: "Do you really want to continue?"})
+		if err != nil {
+			t.Fatalf("Confirm should succeed: %s", err)
+		}
+
+		if ok != want {
+			t.Fatalf("confirmation mismatch for %q got: %v want: %v", input, ok, want)
+		}
+	}
+}